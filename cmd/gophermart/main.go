@@ -3,20 +3,39 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/SerjRamone/gophermart/internal/accrual"
 	"github.com/SerjRamone/gophermart/internal/config"
+	"github.com/SerjRamone/gophermart/internal/models"
 	"github.com/SerjRamone/gophermart/internal/repository"
+	"github.com/SerjRamone/gophermart/internal/scheduler"
+	"github.com/SerjRamone/gophermart/internal/server/handlers"
+	"github.com/SerjRamone/gophermart/internal/server/middlewares"
 	"github.com/SerjRamone/gophermart/internal/server/router"
+	"github.com/SerjRamone/gophermart/internal/server/security"
 	"github.com/SerjRamone/gophermart/pkg/logger"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+const (
+	// jobsInterval background jobs run interval
+	jobsInterval = time.Minute
+
+	// tiersInterval loyalty tiers recalculation interval
+	tiersInterval = time.Hour
+
+	// cleanupInterval expired data deletion interval
+	cleanupInterval = time.Hour
+)
+
 func main() {
 	if err := run(); err != nil {
 		log.Fatal(err)
@@ -41,17 +60,89 @@ func run() error {
 
 	logger.Info("loaded config", zap.Object("config", &conf))
 
+	tiers, err := config.LoadTierRules(conf.TiersConfig)
+	if err != nil {
+		return err
+	}
+
+	referralBonus, err := models.ParsePoints(conf.ReferralBonus)
+	if err != nil {
+		return err
+	}
+
+	transferLimit, err := models.ParsePoints(conf.TransferDailyLimit)
+	if err != nil {
+		return err
+	}
+
+	// access tokens signing keys, empty secret without keys is rejected
+	keys, err := middlewares.NewKeySet(conf.JWTKeysDir, []byte(conf.SecretKey))
+	if err != nil {
+		return err
+	}
+
+	breached, err := config.LoadBreachedPasswords(conf.BreachedPasswords)
+	if err != nil {
+		return err
+	}
+	passwordPolicy := models.PasswordPolicy{MinLength: conf.PasswordMinLength, Breached: breached}
+
+	// client IP of login attempts is known from trusted proxies only
+	proxies, err := config.ParseTrustedProxies(conf.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	loginPolicy := models.DefaultLoginPolicy()
+	loginPolicy.MaxAttempts = conf.LoginMaxAttempts
+	loginPolicy.IPMaxAttempts = conf.LoginIPMaxAttempts
+	loginPolicy.MaxLockout = time.Duration(conf.LoginMaxLockout) * time.Second
+
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	defer cancel()
 
-	db, err := repository.NewDB(ctx, conf.DatabaseURI)
+	db, err := repository.NewDB(
+		ctx,
+		conf.DatabaseURI,
+		repository.PointsExpiryOption(conf.PointsExpiry),
+		repository.ExpiringSoonOption(time.Duration(conf.PointsExpiringSoon)*24*time.Hour),
+		repository.PointsHoldOption(conf.PointsHold),
+		repository.ReferralOption(referralBonus, conf.ReferralMax),
+		repository.TransferLimitOption(transferLimit, conf.TransferDailyCount),
+		repository.LoginPolicyOption(loginPolicy),
+	)
 	if err != nil {
 		return err
 	}
 
+	// failed login attempts storage, DB shares them between replicas
+	var loginGuard handlers.LoginGuard
+	switch conf.LoginGuard {
+	case "postgres":
+		loginGuard = db
+	case "memory":
+		loginGuard = security.NewLoginGuard(loginPolicy)
+	default:
+		return fmt.Errorf("unknown login guard %q", conf.LoginGuard)
+	}
+
+	// withdrawals are completed by the job out of the same window they are cancelled by the handler
+	cancelWindow := max(time.Duration(conf.WithdrawalCancelWindow)*time.Second, 0)
+
 	server := &http.Server{
-		Addr:    conf.RunAddress,
-		Handler: router.NewRouter([]byte(conf.SecretKey), conf.TokenExpiration, db),
+		Addr: conf.RunAddress,
+		Handler: router.NewRouter(
+			keys,
+			conf.TokenExpiration,
+			db,
+			handlers.IdempotencyTTLOption(time.Duration(conf.IdempotencyTTL)*time.Second),
+			handlers.WithdrawalCancelWindowOption(cancelWindow),
+			handlers.TierRulesOption(tiers),
+			handlers.RefreshTokenTTLOption(time.Duration(conf.RefreshTokenExpiration)*time.Second),
+			handlers.LoginGuardOption(loginGuard),
+			handlers.PasswordPolicyOption(passwordPolicy),
+			handlers.TrustedProxiesOption(proxies),
+		),
 	}
 
 	go func() {
@@ -62,11 +153,90 @@ func run() error {
 		}
 	}()
 
-	// @todo
 	// start watching orders
-	// new accrual client
-	accrualClient := accrual.NewAccrualClient(conf.AccrualSystemAddress)
-	accrualClient.WatchOrders(ctx, db)
+	accrualClient := accrual.NewAccrualClient(
+		conf.AccrualSystemAddress,
+		accrual.WorkersOption(conf.AccrualWorkers),
+		accrual.ReconcileOption(time.Duration(conf.ReconcileWindow)*time.Second),
+		accrual.TierRulesOption(tiers),
+	)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		accrualClient.WatchOrders(ctx, db)
+	}()
+
+	// complete withdrawals out of cancellation window
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scheduler.Every(ctx, "complete withdrawals", jobsInterval, func(ctx context.Context) error {
+			_, err := db.CompleteWithdrawals(ctx, cancelWindow)
+			return err
+		})
+	}()
+
+	// release held points
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scheduler.Every(ctx, "release held points", jobsInterval, func(ctx context.Context) error {
+			_, err := db.ReleaseHeldPoints(ctx)
+			return err
+		})
+	}()
+
+	// write off expired points
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scheduler.Every(ctx, "expire points", jobsInterval, func(ctx context.Context) error {
+			_, err := db.ExpirePoints(ctx)
+			return err
+		})
+	}()
+
+	// recalculate loyalty tiers
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scheduler.Every(ctx, "recalculate tiers", tiersInterval, func(ctx context.Context) error {
+			_, err := db.RecalculateTiers(ctx, tiers)
+			return err
+		})
+	}()
+
+	// delete expired refresh tokens
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scheduler.Every(ctx, "delete expired refresh tokens", cleanupInterval, func(ctx context.Context) error {
+			_, err := db.DeleteExpiredRefreshTokens(ctx)
+			return err
+		})
+	}()
+
+	// delete stale login failures
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scheduler.Every(ctx, "delete stale login failures", cleanupInterval, func(ctx context.Context) error {
+			_, err := db.DeleteStaleLoginFailures(ctx)
+			return err
+		})
+	}()
+
+	// delete expired idempotency keys
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scheduler.Every(ctx, "delete expired idempotency keys", cleanupInterval, func(ctx context.Context) error {
+			_, err := db.DeleteExpiredIdempotencyKeys(ctx)
+			return err
+		})
+	}()
 
 	<-ctx.Done()
 
@@ -77,7 +247,9 @@ func run() error {
 		logger.Info("server shut down gracefully")
 	}
 
-	// todo close db
+	// wait for accrual workers and background jobs finish in-flight updates
+	wg.Wait()
+
 	db.Close()
 
 	return nil
@@ -3,12 +3,19 @@ package accrual
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/SerjRamone/gophermart/internal/models"
@@ -25,10 +32,57 @@ var (
 	ErrTooManyRequests = errors.New("too many requests")
 )
 
+const (
+	// defaultRetryAfter cooldown if accrual service didn't send Retry-After header
+	defaultRetryAfter = 60 * time.Second
+
+	// defaultWorkers default number of order processing workers
+	defaultWorkers = 1
+
+	// defaultLease default time an order is owned by the claiming instance
+	defaultLease = time.Minute
+
+	// pollInterval interval of claiming unprocessed orders from storage
+	pollInterval = 2 * time.Second
+
+	// updateTimeout timeout of storing order update
+	updateTimeout = 5 * time.Second
+
+	// backoffBase delay before the first recheck of an order without status change
+	backoffBase = 5 * time.Second
+
+	// backoffMax max delay between order checks
+	backoffMax = time.Hour
+
+	// reconcileInterval delay between rechecks of processed order within reconciliation window
+	reconcileInterval = 24 * time.Hour
+)
+
+// requestsLimitRe matches "No more than N requests per minute allowed" body
+var requestsLimitRe = regexp.MustCompile(`No more than (\d+) requests per minute`)
+
 // AccrualClient ...
 type AccrualClient struct {
 	httpClient *http.Client
 	url        string
+	limiter    *rateLimiter
+	workers    int
+	owner      string        // instance ID used for order leases
+	lease      time.Duration // order lease duration
+	reconcile  time.Duration // processed orders recheck window, 0 disables reconciliation
+	tiers      *models.TierRules
+}
+
+// Option ...
+type Option func(*AccrualClient)
+
+// WorkersOption return Option func for setting number of order processing workers
+func WorkersOption(workers int) Option {
+	return func(c *AccrualClient) {
+		if workers > 0 {
+			c.workers = workers
+		}
+	}
 }
 
 // HTTPError ...
@@ -41,6 +95,10 @@ func (e HTTPError) Error() string {
 	return fmt.Sprintf("%v (Code: %v)", e.Err, e.StatusCode)
 }
 
+func (e HTTPError) Unwrap() error {
+	return e.Err
+}
+
 func newHTTPError(e error, code int) error {
 	return &HTTPError{
 		Err:        e,
@@ -48,12 +106,133 @@ func newHTTPError(e error, code int) error {
 	}
 }
 
+// TooManyRequestsError is 429 response of accrual service
+type TooManyRequestsError struct {
+	RetryAfter time.Duration
+	Limit      int // allowed requests per minute, 0 if unknown
+}
+
+func (e TooManyRequestsError) Error() string {
+	return fmt.Sprintf("%v (retry after: %v, limit: %d rpm)", ErrTooManyRequests, e.RetryAfter, e.Limit)
+}
+
+func (e TooManyRequestsError) Unwrap() error {
+	return ErrTooManyRequests
+}
+
+// LeaseOption return Option func for setting order lease duration
+func LeaseOption(lease time.Duration) Option {
+	return func(c *AccrualClient) {
+		if lease > 0 {
+			c.lease = lease
+		}
+	}
+}
+
+// ReconcileOption return Option func for setting window processed orders are rechecked within
+func ReconcileOption(window time.Duration) Option {
+	return func(c *AccrualClient) {
+		if window > 0 {
+			c.reconcile = window
+		}
+	}
+}
+
+// TierRulesOption return Option func for setting loyalty tier rules applying accrual multipliers
+func TierRulesOption(rules models.TierRules) Option {
+	return func(c *AccrualClient) {
+		c.tiers = &rules
+	}
+}
+
 // NewAccrualClient constructor
-func NewAccrualClient(accrualURL string) *AccrualClient {
-	return &AccrualClient{
+func NewAccrualClient(accrualURL string, opts ...Option) *AccrualClient {
+	c := &AccrualClient{
 		httpClient: &http.Client{},
 		url:        accrualURL,
+		limiter:    newRateLimiter(),
+		workers:    defaultWorkers,
+		owner:      newOwnerID(),
+		lease:      defaultLease,
+	}
+
+	// apply options
+	for _, fn := range opts {
+		fn(c)
+	}
+
+	return c
+}
+
+// newOwnerID returns unique ID of gophermart instance
+func newOwnerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "gophermart"
+	}
+
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		logger.Error("owner ID random suffix error", zap.Error(err))
+	}
+
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// newTooManyRequestsError parses Retry-After header and requests limit from 429 response
+func newTooManyRequestsError(resp *http.Response) error {
+	e := &TooManyRequestsError{
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("reading 429 response body error", zap.Error(err))
+		return e
+	}
+	e.Limit = parseRequestsLimit(string(b))
+
+	return e
+}
+
+// parseRetryAfter parses Retry-After header value in seconds or HTTP-date form
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return defaultRetryAfter
+	}
+
+	// delay-seconds
+	if sec, err := strconv.Atoi(value); err == nil {
+		if sec < 0 {
+			return defaultRetryAfter
+		}
+		return time.Duration(sec) * time.Second
+	}
+
+	// HTTP-date
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+
+	return defaultRetryAfter
+}
+
+// parseRequestsLimit returns N from "No more than N requests per minute allowed" or 0
+func parseRequestsLimit(body string) int {
+	m := requestsLimitRe.FindStringSubmatch(body)
+	if m == nil {
+		return 0
+	}
+
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
 	}
+	return n
 }
 
 // getAccrualData do request to accrual service, returns order accrual data
@@ -84,9 +263,9 @@ func (c AccrualClient) getAccrualData(ctx context.Context, order *models.Order)
 	// check statuses
 	switch resp.StatusCode {
 	case http.StatusTooManyRequests: // 429
-		return nil, newHTTPError(errors.New("too many request status from accrual service"), resp.StatusCode)
+		return nil, newTooManyRequestsError(resp)
 	case http.StatusNoContent: // 204
-		return nil, newHTTPError(errors.New("order is not registered"), resp.StatusCode)
+		return nil, newHTTPError(ErrUnknownOrder, resp.StatusCode)
 	case http.StatusInternalServerError: // 500
 		return nil, newHTTPError(errors.New("accrual service internal error"), resp.StatusCode)
 	}
@@ -102,95 +281,233 @@ func (c AccrualClient) getAccrualData(ctx context.Context, order *models.Order)
 	if err := json.Unmarshal(rBody, &orderAccrual); err != nil {
 		return nil, fmt.Errorf("unmarshal response body error: %w", err)
 	}
+	orderAccrual.Raw = rBody
 
 	return &orderAccrual, nil
 }
 
-// WatchOrders starts order processing
+// WatchOrders starts order processing, blocks until ctx is done
+// and all workers have finished their in-flight updates
 func (c AccrualClient) WatchOrders(ctx context.Context, db handlers.Storage) {
-	logger.Info("accrual client: watch orders process started")
+	logger.Info("accrual client: watch orders process started",
+		zap.Int("workers", c.workers),
+		zap.String("owner", c.owner),
+	)
+
+	errCh := make(chan error, c.workers)
+	ordersCh := make(chan *models.Order, c.workers)
+
+	var wg sync.WaitGroup
 
-	ticker := time.NewTicker(2 * time.Second)
-	delayCh := make(chan int, 1)
-	errCh := make(chan error, 1)
-	ordersCh := make(chan *models.Order, 5) // @todo to config?
+	// claims new orders from storage and puts to chan
+	wg.Add(1)
+	go func(ctx context.Context, ordersCh chan<- *models.Order, errCh chan<- error) {
+		defer wg.Done()
+		defer close(ordersCh)
+
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
 
-	// gets new orders from storage and puts to chan
-	go func(ctx context.Context, ordersCh chan<- *models.Order, delayCh <-chan int, errCh chan<- error) {
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case timeout := <-delayCh:
-				time.Sleep(time.Duration(timeout) * time.Second)
 			case <-ticker.C:
 			}
 
-			// get unprocessed orders
-			orders, err := db.GetUnprocessedOrders(ctx)
+			// claim no more orders than chan can take, so leases don't expire in the queue
+			free := cap(ordersCh) - len(ordersCh)
+			if free == 0 {
+				continue
+			}
+
+			// claim unprocessed orders
+			orders, err := db.ClaimOrders(ctx, c.owner, free, c.lease)
 			if err != nil {
-				errCh <- fmt.Errorf("get unprocessed orders error: %w", err)
+				errCh <- fmt.Errorf("claim unprocessed orders error: %w", err)
 				continue
 			}
-			logger.Info("unprocessed orders found", zap.Int("count", len(orders)))
+			logger.Info("unprocessed orders claimed", zap.Int("count", len(orders)))
+
+			// recheck processed orders with the rest of free places
+			if free -= len(orders); c.reconcile > 0 && free > 0 {
+				processed, err := db.ClaimProcessedOrders(ctx, c.owner, free, c.lease, c.reconcile)
+				if err != nil {
+					errCh <- fmt.Errorf("claim processed orders error: %w", err)
+				} else {
+					logger.Info("processed orders claimed", zap.Int("count", len(processed)))
+					orders = append(orders, processed...)
+				}
+			}
 
 			// put orders to chan
 			for _, order := range orders {
 				ordersCh <- order
 			}
 		}
-	}(ctx, ordersCh, delayCh, errCh)
+	}(ctx, ordersCh, errCh)
 
 	// process orders from chan
-	go func(ctx context.Context, ordersCh <-chan *models.Order, delayCh chan<- int, errCh chan<- error) {
-		// process orders from chan
-		for order := range ordersCh {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func(ctx context.Context, ordersCh <-chan *models.Order, errCh chan<- error) {
+			defer wg.Done()
+			c.processOrders(ctx, db, ordersCh, errCh)
+		}(ctx, ordersCh, errCh)
+	}
 
-			// get order data from accrual service
-			orderAcc, err := c.getAccrualData(ctx, order)
-			if err != nil {
-				if errors.Is(err, ErrUnknownOrder) {
-					continue
-				}
-				if errors.Is(err, ErrTooManyRequests) {
-					// some cooldown
-					timeout, ok := 10, true // @todo
-					if ok {
-						delayCh <- timeout
-						time.Sleep(time.Duration(timeout) * time.Second)
-						continue
-					}
-				}
-				errCh <- fmt.Errorf("get accrual data error: %w", err)
-				continue
-			}
+	// close errors chan when all gorutines are done
+	go func() {
+		wg.Wait()
+		close(errCh)
+	}()
 
-			// set new status and accrual points
-			order.Status = orderAcc.Status
-			order.Accrual = orderAcc.Accrual
+	// log errors from gorutines
+	for err := range errCh {
+		logger.Error("run order error", zap.Error(err))
+	}
 
-			// store update
-			if err := db.UpdateOrder(ctx, order); err != nil {
-				errCh <- fmt.Errorf("update order error: %w", err)
-			}
+	// release orders left in the queue, so other instances can take them at once
+	relCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), updateTimeout)
+	defer cancel()
+	if err := db.ReleaseOrders(relCtx, c.owner); err != nil {
+		logger.Error("release orders error", zap.Error(err))
+	}
+
+	logger.Info("accrual client: watch orders process stopped")
+}
+
+// backoff returns delay before the next check of an order checked attempts times without status change
+func backoff(attempts int) time.Duration {
+	d := backoffBase
+	for i := 0; i < attempts && d < backoffMax; i++ {
+		d *= 2
+	}
+	if d > backoffMax {
+		d = backoffMax
+	}
+	return d
+}
+
+// scheduleNextCheck sets attempts counter and next check date of an order.
+// Processed order is rechecked for revision once per reconcile interval
+func scheduleNextCheck(order *models.Order, changed bool) {
+	if changed {
+		order.Attempts = 0
+	} else {
+		order.Attempts++
+	}
+
+	if order.Status == models.OrderStatusProcessed {
+		order.NextCheckAt = time.Now().Add(reconcileInterval)
+		return
+	}
+	order.NextCheckAt = time.Now().Add(backoff(order.Attempts))
+}
+
+// tierMultiplier returns accrual multiplier of user's loyalty tier, 1 if tiers aren't set
+func (c AccrualClient) tierMultiplier(ctx context.Context, db handlers.Storage, userID string) (float64, error) {
+	if c.tiers == nil {
+		return 1, nil
+	}
+
+	ut, err := db.GetUserTier(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	tier, _ := c.tiers.ForPoints(ut.Accrued)
+	return tier.Multiplier, nil
+}
+
+// processOrders is a worker: gets data from accrual service for orders from chan and stores it.
+// Lease of order that isn't stored is released, so it's rechecked without waiting for the lease expiration
+func (c AccrualClient) processOrders(ctx context.Context, db handlers.Storage, ordersCh <-chan *models.Order, errCh chan<- error) {
+	for order := range ordersCh {
+		select {
+		case <-ctx.Done():
+			return
+		default:
 		}
-	}(ctx, ordersCh, delayCh, errCh)
 
-	// errors chan watcher
-	// log errors from gorutines
-	go func(ctx context.Context, errCh <-chan error) {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case err := <-errCh:
-				logger.Error("run order error", zap.Error(err))
+		// wait for a free slot in accrual service quota
+		if err := c.limiter.Wait(ctx); err != nil {
+			return
+		}
+
+		err := c.processOrder(ctx, db, order)
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, models.ErrOrderLeaseLost):
+			// order is claimed by another instance, its update is dropped
+			logger.Warn("order lease lost", zap.String("order", order.Number), zap.String("owner", c.owner))
+			continue
+		case !errors.Is(err, ErrTooManyRequests):
+			errCh <- err
+		}
+
+		relCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), updateTimeout)
+		if err := db.ReleaseOrder(relCtx, c.owner, order.ID); err != nil {
+			errCh <- fmt.Errorf("release order error: %w", err)
+		}
+		cancel()
+	}
+}
+
+// processOrder gets data from accrual service for the order and stores it
+func (c AccrualClient) processOrder(ctx context.Context, db handlers.Storage, order *models.Order) error {
+	// processed order is rechecked for revision by accrual service
+	revision := order.Status == models.OrderStatusProcessed
+
+	// get order data from accrual service
+	orderAcc, err := c.getAccrualData(ctx, order)
+	var tmrErr *TooManyRequestsError
+	switch {
+	case errors.Is(err, ErrUnknownOrder):
+		// order is not registered in accrual service yet, check it later
+		scheduleNextCheck(order, false)
+	case errors.As(err, &tmrErr):
+		// pause all requests and adapt rate to the advertised limit
+		logger.Warn("accrual service requests limit exceeded",
+			zap.Duration("retry_after", tmrErr.RetryAfter),
+			zap.Int("limit", tmrErr.Limit),
+		)
+		c.limiter.Pause(tmrErr.RetryAfter)
+		c.limiter.SetLimit(tmrErr.Limit)
+		return err
+	case err != nil:
+		return fmt.Errorf("get accrual data error: %w", err)
+	default:
+		// processed order accrual is multiplied by user's loyalty tier multiplier
+		if !revision && orderAcc.Status == models.OrderAccrualStatusProcessed {
+			if order.Multiplier, err = c.tierMultiplier(ctx, db, order.UserID); err != nil {
+				return fmt.Errorf("get order %s tier multiplier error: %w", order.Number, err)
 			}
 		}
-	}(ctx, errCh)
+
+		// set new status and accrual points
+		apply := order.ApplyAccrual
+		if revision {
+			apply = order.ReviseAccrual
+		}
+		changed, err := apply(orderAcc)
+		if err != nil {
+			return fmt.Errorf("apply accrual data to order %s error: %w", order.Number, err)
+		}
+		scheduleNextCheck(order, changed)
+	}
+
+	// store update even if ctx is canceled meanwhile, so shutdown doesn't lose it
+	updCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), updateTimeout)
+	defer cancel()
+	update := db.UpdateOrder
+	if revision {
+		update = db.ReviseOrder
+	}
+	if err := update(updCtx, c.owner, order); err != nil {
+		return fmt.Errorf("update order error: %w", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,182 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 11, 20, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{
+			name:  "Test#1. Seconds",
+			value: "60",
+			want:  60 * time.Second,
+		},
+		{
+			name:  "Test#2. HTTP-date",
+			value: now.Add(90 * time.Second).Format(http.TimeFormat),
+			want:  90 * time.Second,
+		},
+		{
+			name:  "Test#3. HTTP-date in the past",
+			value: now.Add(-time.Minute).Format(http.TimeFormat),
+			want:  0,
+		},
+		{
+			name:  "Test#4. Empty",
+			value: "",
+			want:  defaultRetryAfter,
+		},
+		{
+			name:  "Test#5. Garbage",
+			value: "soon",
+			want:  defaultRetryAfter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, parseRetryAfter(tt.value, now))
+		})
+	}
+}
+
+func Test_getAccrualData(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/orders/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte("No more than 10 requests per minute allowed"))
+	})
+	mux.HandleFunc("/api/orders/2", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := NewAccrualClient(srv.URL)
+
+	// 429
+	_, err := c.getAccrualData(context.Background(), &models.Order{Number: "1"})
+	require.ErrorIs(t, err, ErrTooManyRequests)
+
+	var tmrErr *TooManyRequestsError
+	require.True(t, errors.As(err, &tmrErr))
+	require.Equal(t, 30*time.Second, tmrErr.RetryAfter)
+	require.Equal(t, 10, tmrErr.Limit)
+
+	// 204
+	_, err = c.getAccrualData(context.Background(), &models.Order{Number: "2"})
+	require.ErrorIs(t, err, ErrUnknownOrder)
+}
+
+func Test_rateLimiter(t *testing.T) {
+	l := newRateLimiter()
+
+	// no limit by default
+	require.NoError(t, l.Wait(context.Background()))
+	require.NoError(t, l.Wait(context.Background()))
+
+	// paused limiter waits for context
+	l.Pause(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, l.Wait(ctx), context.DeadlineExceeded)
+
+	// limit spreads requests over a minute
+	l = newRateLimiter()
+	l.SetLimit(600)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func Test_backoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		want     time.Duration
+	}{
+		{
+			name:     "Test#1. First check",
+			attempts: 0,
+			want:     backoffBase,
+		},
+		{
+			name:     "Test#2. Doubled",
+			attempts: 3,
+			want:     8 * backoffBase,
+		},
+		{
+			name:     "Test#3. Capped",
+			attempts: 100,
+			want:     backoffMax,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, backoff(tt.attempts))
+		})
+	}
+}
+
+func Test_scheduleNextCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		order        models.Order
+		changed      bool
+		wantAttempts int
+		wantDelay    time.Duration
+	}{
+		{
+			name:         "Test#1. Status changed",
+			order:        models.Order{Status: models.OrderStatusProcessing, Attempts: 3},
+			changed:      true,
+			wantAttempts: 0,
+			wantDelay:    backoffBase,
+		},
+		{
+			name:         "Test#2. Status isn't changed",
+			order:        models.Order{Status: models.OrderStatusProcessing, Attempts: 3},
+			wantAttempts: 4,
+			wantDelay:    backoff(4),
+		},
+		{
+			name:         "Test#3. Processed order is rechecked daily",
+			order:        models.Order{Status: models.OrderStatusProcessed},
+			changed:      true,
+			wantAttempts: 0,
+			wantDelay:    reconcileInterval,
+		},
+		{
+			name:         "Test#4. Revised order isn't rechecked more often",
+			order:        models.Order{Status: models.OrderStatusProcessed, Attempts: 10},
+			wantAttempts: 11,
+			wantDelay:    reconcileInterval,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := tt.order
+			start := time.Now()
+			scheduleNextCheck(&order, tt.changed)
+			require.Equal(t, tt.wantAttempts, order.Attempts)
+			require.WithinDuration(t, start.Add(tt.wantDelay), order.NextCheckAt, time.Second)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package accrual
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter shares accrual service requests quota between all workers
+type rateLimiter struct {
+	mu          sync.Mutex
+	interval    time.Duration // min interval between requests, zero means no limit
+	next        time.Time     // earliest time of the next request
+	pausedUntil time.Time     // all requests are paused until this time
+}
+
+// newRateLimiter constructor
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{}
+}
+
+// Wait blocks until request is allowed or context is done
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		var delay time.Duration
+		switch {
+		case now.Before(l.pausedUntil):
+			delay = l.pausedUntil.Sub(now)
+		case now.Before(l.next):
+			delay = l.next.Sub(now)
+		default:
+			// take the slot
+			l.next = now.Add(l.interval)
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		// sleep and check again, pause may be prolonged meanwhile
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Pause stops all requests for d
+func (l *rateLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// SetLimit sets max requests count per minute
+func (l *rateLimiter) SetLimit(perMinute int) {
+	if perMinute <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.interval = time.Minute / time.Duration(perMinute)
+}
@@ -9,29 +9,93 @@ import (
 )
 
 const (
-	defaultRunAddress           = "localhost:8080"
-	defaultDatabaseURI          = ""
-	defaultAccrualSystemAddress = "localhost:8088"
-	defaultLogLevel             = "error"
-	defaultSecretKey            = ""
-	defaultTokenExpiration      = 3600
+	defaultRunAddress             = "localhost:8080"
+	defaultDatabaseURI            = ""
+	defaultAccrualSystemAddress   = "localhost:8088"
+	defaultLogLevel               = "error"
+	defaultSecretKey              = ""
+	defaultJWTKeysDir             = ""
+	defaultTokenExpiration        = 900
+	defaultRefreshTokenExpiration = 2592000
+	defaultAccrualWorkers         = 3
+	defaultIdempotencyTTL         = 86400
+	defaultWithdrawalCancelWindow = 900
+	defaultReconcileWindow        = 604800
+	defaultPointsExpiry           = 12
+	defaultPointsExpiringSoon     = 30
+	defaultPointsHold             = 0
+	defaultTiersConfig            = ""
+	defaultReferralBonus          = "100"
+	defaultReferralMax            = 20
+	defaultTransferDailyLimit     = "1000"
+	defaultTransferDailyCount     = 10
+	defaultLoginGuard             = "postgres"
+	defaultLoginMaxAttempts       = 5
+	defaultLoginIPMaxAttempts     = 20
+	defaultLoginMaxLockout        = 900
+	defaultPasswordMinLength      = 8
+	defaultBreachedPasswords      = ""
+	defaultTrustedProxies         = ""
 
-	usageRunAddress           = "address and port for running app"
-	usageDatabaseURI          = "database URI"
-	usageAccrualSystemAddress = "address and port of accrual system"
-	usageLogLevel             = "log level (`error` by default)"
-	usageSecretKey            = "secret key for encoders"
-	usageTokenExpiration      = "authorization token expiration time (3600 sec by default)"
+	usageRunAddress             = "address and port for running app"
+	usageDatabaseURI            = "database URI"
+	usageAccrualSystemAddress   = "address and port of accrual system"
+	usageLogLevel               = "log level (`error` by default)"
+	usageSecretKey              = "HS256 secret of access tokens, verifies tokens without kid if keys directory is set"
+	usageJWTKeysDir             = "directory of <kid>.pem access tokens signing keys (RS256 or EdDSA)"
+	usageTokenExpiration        = "access token expiration time (900 sec by default)"
+	usageRefreshTokenExpiration = "refresh token expiration time (2592000 sec by default)"
+	usageAccrualWorkers         = "number of accrual system polling workers (3 by default)"
+	usageIdempotencyTTL         = "idempotency keys storing time (86400 sec by default)"
+	usageWithdrawalCancelWindow = "time withdrawal may be cancelled after creation (900 sec by default, 0 disables cancellation)"
+	usageReconcileWindow        = "time processed orders are rechecked in accrual system (604800 sec by default, 0 disables)"
+	usagePointsExpiry           = "accrued points lifetime in months (12 by default, 0 disables expiration)"
+	usagePointsExpiringSoon     = "days before expiration points are shown as expiring soon (30 by default)"
+	usagePointsHold             = "days accrued points are held before they can be spent (0 by default, hold is disabled)"
+	usageTiersConfig            = "loyalty tier rules JSON file (built-in rules by default)"
+	usageReferralBonus          = "points paid to referrer and referred user for the first processed order (100 by default, 0 disables bonus)"
+	usageReferralMax            = "max users referred by one user (20 by default, 0 means unlimited)"
+	usageTransferDailyLimit     = "max points transferred by one user within a day (1000 by default, 0 means unlimited)"
+	usageTransferDailyCount     = "max transfers made by one user within a day (10 by default, 0 means unlimited)"
+	usageLoginGuard             = "failed login attempts storage, `postgres` shared by replicas or `memory` for single node"
+	usageLoginMaxAttempts       = "failed attempts of one login before lockout (5 by default, 0 disables lockout)"
+	usageLoginIPMaxAttempts     = "failed login attempts from one IP before lockout (20 by default, 0 disables lockout)"
+	usageLoginMaxLockout        = "max login lockout, failures are forgotten after it (900 sec by default)"
+	usagePasswordMinLength      = "min password length in characters (8 by default)"
+	usageBreachedPasswords      = "breached passwords list file, one password per line (no list by default)"
+	usageTrustedProxies         = "comma separated CIDRs of proxies client IP is taken from X-Forwarded-For or X-Real-IP of, " +
+		"login attempts of other requests aren't limited by IP (no proxies by default)"
 )
 
 // Gophermart is a gophermart app config
 type Gophermart struct {
-	RunAddress           string `env:"RUN_ADDRESS"`
-	DatabaseURI          string `env:"DATABASE_URI"`
-	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
-	LogLevel             string `env:"LOG_LEVEL"`
-	SecretKey            string `env:"SECRET_KEY"`
-	TokenExpiration      int    `env:"TOKEN_EXPIRATION"`
+	RunAddress             string `env:"RUN_ADDRESS"`
+	DatabaseURI            string `env:"DATABASE_URI"`
+	AccrualSystemAddress   string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	LogLevel               string `env:"LOG_LEVEL"`
+	SecretKey              string `env:"SECRET_KEY"`
+	JWTKeysDir             string `env:"JWT_KEYS_DIR"`
+	TokenExpiration        int    `env:"TOKEN_EXPIRATION"`
+	RefreshTokenExpiration int    `env:"REFRESH_TOKEN_EXPIRATION"`
+	AccrualWorkers         int    `env:"ACCRUAL_WORKERS"`
+	IdempotencyTTL         int    `env:"IDEMPOTENCY_TTL"`
+	WithdrawalCancelWindow int    `env:"WITHDRAWAL_CANCEL_WINDOW"`
+	ReconcileWindow        int    `env:"ACCRUAL_RECONCILE_WINDOW"`
+	PointsExpiry           int    `env:"POINTS_EXPIRY_MONTHS"`
+	PointsExpiringSoon     int    `env:"POINTS_EXPIRING_SOON_DAYS"`
+	PointsHold             int    `env:"POINTS_HOLD_DAYS"`
+	TiersConfig            string `env:"TIERS_CONFIG"`
+	ReferralBonus          string `env:"REFERRAL_BONUS"`
+	ReferralMax            int    `env:"REFERRAL_MAX"`
+	TransferDailyLimit     string `env:"TRANSFER_DAILY_LIMIT"`
+	TransferDailyCount     int    `env:"TRANSFER_DAILY_COUNT"`
+	LoginGuard             string `env:"LOGIN_GUARD"`
+	LoginMaxAttempts       int    `env:"LOGIN_MAX_ATTEMPTS"`
+	LoginIPMaxAttempts     int    `env:"LOGIN_IP_MAX_ATTEMPTS"`
+	LoginMaxLockout        int    `env:"LOGIN_MAX_LOCKOUT"`
+	PasswordMinLength      int    `env:"PASSWORD_MIN_LENGTH"`
+	BreachedPasswords      string `env:"BREACHED_PASSWORDS_FILE"`
+	TrustedProxies         string `env:"TRUSTED_PROXIES"`
 }
 
 // NewGophermart constructor for gophermart config
@@ -48,7 +112,28 @@ func (g *Gophermart) parseFlags() {
 	flag.StringVar(&g.AccrualSystemAddress, "r", defaultAccrualSystemAddress, usageAccrualSystemAddress)
 	flag.StringVar(&g.LogLevel, "l", defaultLogLevel, usageLogLevel)
 	flag.StringVar(&g.SecretKey, "s", defaultSecretKey, usageSecretKey)
+	flag.StringVar(&g.JWTKeysDir, "k", defaultJWTKeysDir, usageJWTKeysDir)
 	flag.IntVar(&g.TokenExpiration, "e", defaultTokenExpiration, usageTokenExpiration)
+	flag.IntVar(&g.RefreshTokenExpiration, "E", defaultRefreshTokenExpiration, usageRefreshTokenExpiration)
+	flag.IntVar(&g.AccrualWorkers, "w", defaultAccrualWorkers, usageAccrualWorkers)
+	flag.IntVar(&g.IdempotencyTTL, "i", defaultIdempotencyTTL, usageIdempotencyTTL)
+	flag.IntVar(&g.WithdrawalCancelWindow, "c", defaultWithdrawalCancelWindow, usageWithdrawalCancelWindow)
+	flag.IntVar(&g.ReconcileWindow, "R", defaultReconcileWindow, usageReconcileWindow)
+	flag.IntVar(&g.PointsExpiry, "m", defaultPointsExpiry, usagePointsExpiry)
+	flag.IntVar(&g.PointsExpiringSoon, "n", defaultPointsExpiringSoon, usagePointsExpiringSoon)
+	flag.IntVar(&g.PointsHold, "p", defaultPointsHold, usagePointsHold)
+	flag.StringVar(&g.TiersConfig, "t", defaultTiersConfig, usageTiersConfig)
+	flag.StringVar(&g.ReferralBonus, "b", defaultReferralBonus, usageReferralBonus)
+	flag.IntVar(&g.ReferralMax, "f", defaultReferralMax, usageReferralMax)
+	flag.StringVar(&g.TransferDailyLimit, "x", defaultTransferDailyLimit, usageTransferDailyLimit)
+	flag.IntVar(&g.TransferDailyCount, "y", defaultTransferDailyCount, usageTransferDailyCount)
+	flag.StringVar(&g.LoginGuard, "g", defaultLoginGuard, usageLoginGuard)
+	flag.IntVar(&g.LoginMaxAttempts, "u", defaultLoginMaxAttempts, usageLoginMaxAttempts)
+	flag.IntVar(&g.LoginIPMaxAttempts, "j", defaultLoginIPMaxAttempts, usageLoginIPMaxAttempts)
+	flag.IntVar(&g.LoginMaxLockout, "o", defaultLoginMaxLockout, usageLoginMaxLockout)
+	flag.IntVar(&g.PasswordMinLength, "L", defaultPasswordMinLength, usagePasswordMinLength)
+	flag.StringVar(&g.BreachedPasswords, "B", defaultBreachedPasswords, usageBreachedPasswords)
+	flag.StringVar(&g.TrustedProxies, "P", defaultTrustedProxies, usageTrustedProxies)
 
 	flag.Parse()
 }
@@ -65,7 +150,28 @@ func (g *Gophermart) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("AccrualSystemAddress", g.AccrualSystemAddress)
 	enc.AddString("LogLevel", g.LogLevel)
 	enc.AddString("SecretKey", g.SecretKey)
+	enc.AddString("JWTKeysDir", g.JWTKeysDir)
 	enc.AddInt("TokenExpiration", g.TokenExpiration)
+	enc.AddInt("RefreshTokenExpiration", g.RefreshTokenExpiration)
+	enc.AddInt("AccrualWorkers", g.AccrualWorkers)
+	enc.AddInt("IdempotencyTTL", g.IdempotencyTTL)
+	enc.AddInt("WithdrawalCancelWindow", g.WithdrawalCancelWindow)
+	enc.AddInt("ReconcileWindow", g.ReconcileWindow)
+	enc.AddInt("PointsExpiry", g.PointsExpiry)
+	enc.AddInt("PointsExpiringSoon", g.PointsExpiringSoon)
+	enc.AddInt("PointsHold", g.PointsHold)
+	enc.AddString("TiersConfig", g.TiersConfig)
+	enc.AddString("ReferralBonus", g.ReferralBonus)
+	enc.AddInt("ReferralMax", g.ReferralMax)
+	enc.AddString("TransferDailyLimit", g.TransferDailyLimit)
+	enc.AddInt("TransferDailyCount", g.TransferDailyCount)
+	enc.AddString("LoginGuard", g.LoginGuard)
+	enc.AddInt("LoginMaxAttempts", g.LoginMaxAttempts)
+	enc.AddInt("LoginIPMaxAttempts", g.LoginIPMaxAttempts)
+	enc.AddInt("LoginMaxLockout", g.LoginMaxLockout)
+	enc.AddInt("PasswordMinLength", g.PasswordMinLength)
+	enc.AddString("BreachedPasswords", g.BreachedPasswords)
+	enc.AddString("TrustedProxies", g.TrustedProxies)
 
 	return nil
 }
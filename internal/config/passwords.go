@@ -0,0 +1,37 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadBreachedPasswords reads breached passwords list, one password per line.
+// Empty lines and lines starting with `#` are skipped, returns nil if path is empty
+func LoadBreachedPasswords(path string) (map[string]struct{}, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open breached passwords file error: %w", err)
+	}
+	defer f.Close()
+
+	passwords := map[string]struct{}{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		p := strings.TrimRight(s.Text(), "\r")
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		passwords[p] = struct{}{}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read breached passwords file error: %w", err)
+	}
+
+	return passwords, nil
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadBreachedPasswords(t *testing.T) {
+	// no list
+	passwords, err := LoadBreachedPasswords("")
+	require.NoError(t, err)
+	require.Nil(t, passwords)
+
+	// comments and empty lines are skipped
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# top passwords\n123456\r\n\npassword1\n"), 0o600))
+	passwords, err = LoadBreachedPasswords(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]struct{}{"123456": {}, "password1": {}}, passwords)
+
+	// missing file
+	_, err = LoadBreachedPasswords(filepath.Join(t.TempDir(), "missing.txt"))
+	require.Error(t, err)
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	// no proxies
+	proxies, err := ParseTrustedProxies("")
+	require.NoError(t, err)
+	require.Nil(t, proxies)
+
+	// CIDRs and single IPs
+	proxies, err = ParseTrustedProxies("10.0.0.0/8, 192.168.1.10,fd00::/8")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.10/32"),
+		netip.MustParsePrefix("fd00::/8"),
+	}, proxies)
+
+	// invalid proxy
+	_, err = ParseTrustedProxies("10.0.0.0/8,proxy")
+	require.Error(t, err)
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+)
+
+// LoadTierRules reads loyalty tier rules from JSON file, returns default rules if path is empty
+func LoadTierRules(path string) (models.TierRules, error) {
+	if path == "" {
+		return models.DefaultTierRules(), nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return models.TierRules{}, fmt.Errorf("read tier rules file error: %w", err)
+	}
+
+	var rules models.TierRules
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return models.TierRules{}, fmt.Errorf("unmarshal tier rules error: %w", err)
+	}
+
+	if err := rules.Validate(); err != nil {
+		return models.TierRules{}, err
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTierRules(t *testing.T) {
+	// repository example file
+	rules, err := LoadTierRules(filepath.Join("..", "..", "configs", "tiers.json"))
+	require.NoError(t, err)
+	require.Equal(t, models.DefaultTierRules(), rules)
+
+	// default rules
+	rules, err = LoadTierRules("")
+	require.NoError(t, err)
+	require.Equal(t, models.DefaultTierRules(), rules)
+
+	// invalid rules
+	path := filepath.Join(t.TempDir(), "tiers.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"period_months": 12, "tiers": []}`), 0o600))
+	_, err = LoadTierRules(path)
+	require.ErrorIs(t, err, models.ErrInvalidTierRules)
+}
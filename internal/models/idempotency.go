@@ -0,0 +1,18 @@
+package models
+
+import "errors"
+
+var (
+	// ErrIdempotencyKeyInProgress request with the same key is not finished yet error
+	ErrIdempotencyKeyInProgress = errors.New("request with idempotency key is in progress")
+
+	// ErrIdempotencyKeyMismatch key is reused with another request error
+	ErrIdempotencyKeyMismatch = errors.New("idempotency key is used for another request")
+)
+
+// IdempotentResponse stored response of the first request with idempotency key
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
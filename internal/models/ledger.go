@@ -0,0 +1,50 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// ledger entry kinds
+const (
+	LedgerEntryAccrual    = "ACCRUAL"
+	LedgerEntryWithdrawal = "WITHDRAWAL"
+	LedgerEntryReversal   = "REVERSAL"
+	LedgerEntryAdjustment = "ADJUSTMENT"
+	LedgerEntryExpiration = "EXPIRATION"
+	LedgerEntryBonus      = "BONUS"
+	LedgerEntryTransfer   = "TRANSFER"
+)
+
+// system ledger accounts
+const (
+	AccountAccrual    = "system:accrual"    // source of accrued points
+	AccountWithdrawal = "system:withdrawal" // sink of withdrawn points
+	AccountAdjustment = "system:adjustment" // manual corrections
+	AccountExpiration = "system:expiration" // sink of expired points
+	AccountReferral   = "system:referral"   // source of referral bonuses
+
+	userAccountPrefix = "user:"
+)
+
+// LedgerEntry moves points amount from one ledger account to another
+type LedgerEntry struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Amount    Points    `json:"amount"`
+	Reference string    `json:"reference"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserAccount returns ledger account of the user
+func UserAccount(userID string) string {
+	return userAccountPrefix + userID
+}
+
+// AccountUserID returns user ID of the user ledger account, false for system accounts
+func AccountUserID(account string) (string, bool) {
+	return strings.CutPrefix(account, userAccountPrefix)
+}
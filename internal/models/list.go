@@ -0,0 +1,54 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidCursor malformed pagination cursor error
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// ListQuery list filtering, sorting and pagination params
+type ListQuery struct {
+	Statuses []string  // any of statuses, empty means all
+	From     time.Time // inclusive lower date bound, zero means unbounded
+	To       time.Time // exclusive upper date bound, zero means unbounded
+	Desc     bool      // sort from the newest to the oldest
+	After    *Cursor   // return items after cursor
+	Limit    int       // max items count, zero means unlimited
+}
+
+// Cursor is a keyset pagination position: date and ID of the last returned item
+type Cursor struct {
+	At time.Time
+	ID string
+}
+
+// Encode returns opaque cursor string
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.At.Format(time.RFC3339Nano) + "|" + c.ID))
+}
+
+// DecodeCursor parses opaque cursor string
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	at, id, ok := strings.Cut(string(b), "|")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{At: t, ID: id}, nil
+}
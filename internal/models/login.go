@@ -0,0 +1,69 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// loginKeyPrefix prefix of login failures counter key
+	loginKeyPrefix = "login:"
+
+	// ipKeyPrefix prefix of client IP failures counter key
+	ipKeyPrefix = "ip:"
+)
+
+// LoginPolicy login brute-force protection policy. Failures of a login or a client IP
+// over the max attempts lock it for the delay doubled by each next failure up to the max lockout.
+// Failures are forgotten after the max lockout since the last one
+type LoginPolicy struct {
+	MaxAttempts   int           // failures of one login allowed without lockout
+	IPMaxAttempts int           // failures from one IP allowed without lockout
+	Delay         time.Duration // first lockout
+	MaxLockout    time.Duration // max lockout
+}
+
+// DefaultLoginPolicy returns policy used if it isn't configured
+func DefaultLoginPolicy() LoginPolicy {
+	return LoginPolicy{
+		MaxAttempts:   5,
+		IPMaxAttempts: 20,
+		Delay:         time.Second,
+		MaxLockout:    15 * time.Minute,
+	}
+}
+
+// LoginGuardKey returns key of login failures counter
+func LoginGuardKey(login string) string {
+	return loginKeyPrefix + login
+}
+
+// IPGuardKey returns key of client IP failures counter
+func IPGuardKey(ip string) string {
+	return ipKeyPrefix + ip
+}
+
+// LoginGuardKeys returns keys of failures counters of the login and the client IP, unknown IP is skipped
+func LoginGuardKeys(login, ip string) []string {
+	if ip == "" {
+		return []string{LoginGuardKey(login)}
+	}
+	return []string{LoginGuardKey(login), IPGuardKey(ip)}
+}
+
+// Lockout returns lockout caused by failures of the key, zero if failures are within max attempts
+func (p LoginPolicy) Lockout(key string, failures int) time.Duration {
+	maxAttempts := p.MaxAttempts
+	if strings.HasPrefix(key, ipKeyPrefix) {
+		maxAttempts = p.IPMaxAttempts
+	}
+	if maxAttempts <= 0 || failures <= maxAttempts {
+		return 0
+	}
+
+	lockout := p.Delay
+	for i := maxAttempts + 1; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, p.MaxLockout)
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginPolicy_Lockout(t *testing.T) {
+	p := LoginPolicy{MaxAttempts: 3, IPMaxAttempts: 10, Delay: time.Second, MaxLockout: 5 * time.Second}
+
+	tests := []struct {
+		name     string
+		key      string
+		failures int
+		want     time.Duration
+	}{
+		{
+			name:     "Test#1. Login within max attempts",
+			key:      LoginGuardKey("user"),
+			failures: 3,
+			want:     0,
+		},
+		{
+			name:     "Test#2. Login first lockout",
+			key:      LoginGuardKey("user"),
+			failures: 4,
+			want:     time.Second,
+		},
+		{
+			name:     "Test#3. Login lockout is doubled",
+			key:      LoginGuardKey("user"),
+			failures: 6,
+			want:     4 * time.Second,
+		},
+		{
+			name:     "Test#4. Login max lockout",
+			key:      LoginGuardKey("user"),
+			failures: 100,
+			want:     5 * time.Second,
+		},
+		{
+			name:     "Test#5. IP within max attempts",
+			key:      IPGuardKey("127.0.0.1"),
+			failures: 10,
+			want:     0,
+		},
+		{
+			name:     "Test#6. IP first lockout",
+			key:      IPGuardKey("127.0.0.1"),
+			failures: 11,
+			want:     time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, p.Lockout(tt.key, tt.failures))
+		})
+	}
+
+	// zero max attempts disables lockout
+	require.Zero(t, LoginPolicy{}.Lockout(LoginGuardKey("user"), 100))
+}
+
+func TestLoginGuardKeys(t *testing.T) {
+	require.Equal(t, []string{"login:user", "ip:127.0.0.1"}, LoginGuardKeys("user", "127.0.0.1"))
+
+	// unknown IP isn't limited
+	require.Equal(t, []string{"login:user"}, LoginGuardKeys("user", ""))
+}
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"strconv"
 	"time"
 )
@@ -9,6 +11,18 @@ import (
 var (
 	// ErrOrderAlreadyExists is not unique order number error
 	ErrOrderAlreadyExists = errors.New("order is already exists")
+
+	// ErrOrderNotExists order not found error
+	ErrOrderNotExists = errors.New("order is not exists")
+
+	// ErrInvalidOrderTransition not allowed order status change error
+	ErrInvalidOrderTransition = errors.New("invalid order status transition")
+
+	// ErrOrderLeaseLost order lease expired and may be claimed by another instance error
+	ErrOrderLeaseLost = errors.New("order lease is lost")
+
+	// ErrUnknownAccrualStatus unexpected status from accrual service error
+	ErrUnknownAccrualStatus = errors.New("unknown accrual status")
 )
 
 // order statuses
@@ -24,6 +38,17 @@ const (
 	OrderAccrualStatusProcessed  = "PROCESSED"
 )
 
+// orderTransitions allowed order status transitions, INVALID and PROCESSED are final
+var orderTransitions = map[string][]string{
+	OrderStatusNew:        {OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed},
+	OrderStatusProcessing: {OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed},
+}
+
+// orderRevisions allowed status changes of processed order revised by accrual service
+var orderRevisions = map[string][]string{
+	OrderStatusProcessed: {OrderStatusProcessed, OrderStatusInvalid},
+}
+
 // OrderForm data object from request
 type OrderForm struct {
 	UserID string `json:"user_id"`
@@ -32,27 +57,141 @@ type OrderForm struct {
 
 // OrderAccrual data oject from accrual service
 type OrderAccrual struct {
-	OrderNumber string  `json:"order"`
-	Status      string  `json:"status"`
-	Accrual     float64 `json:"accrual"`
+	OrderNumber string          `json:"order"`
+	Status      string          `json:"status"`
+	Accrual     Points          `json:"accrual"`
+	Raw         json.RawMessage `json:"-"` // response body as is
 }
 
 // Order data object from storage
 type Order struct {
-	ID         string    `json:"order_id"`
-	UserID     string    `json:"user_id,omitempty"`
-	Status     string    `json:"status"`
-	Number     string    `json:"number"`
-	Accrual    float64   `json:"accrual"`
-	UploadedAt time.Time `json:"uploaded_at"`
+	ID          string    `json:"order_id"`
+	UserID      string    `json:"user_id,omitempty"`
+	Status      string    `json:"status"`
+	Number      string    `json:"number"`
+	Accrual     Points    `json:"accrual"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	Attempts    int       `json:"-"` // accrual checks count without status change
+	NextCheckAt time.Time `json:"-"` // next accrual check date
+	Multiplier  float64   `json:"-"` // loyalty tier accrual multiplier, 0 means no multiplier
+
+	AccrualResponse json.RawMessage `json:"-"` // last accrual service response
+}
+
+// OrderStatusChange order status history record
+type OrderStatusChange struct {
+	OrderID         string          `json:"-"`
+	From            string          `json:"from,omitempty"`
+	To              string          `json:"status"`
+	Accrual         Points          `json:"accrual"`
+	AccrualResponse json.RawMessage `json:"accrual_response,omitempty"`
+	CreatedAt       time.Time       `json:"changed_at"`
+}
+
+// OrderStatusFromAccrual maps accrual service status to order status
+func OrderStatusFromAccrual(accrualStatus string) (string, error) {
+	switch accrualStatus {
+	case OrderAccrualStatusRegistered, OrderAccrualStatusProcessing:
+		return OrderStatusProcessing, nil
+	case OrderAccrualStatusInvalid:
+		return OrderStatusInvalid, nil
+	case OrderAccrualStatusProcessed:
+		return OrderStatusProcessed, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownAccrualStatus, accrualStatus)
+}
+
+// IsAllowedOrderTransition returns true if order status may be changed from one to another
+func IsAllowedOrderTransition(from, to string) bool {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAllowedOrderRevision returns true if processed order status may be revised from one to another
+func IsAllowedOrderRevision(from, to string) bool {
+	for _, s := range orderRevisions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyAccrual sets order status and accrual from accrual service data.
+// Returns true if order status was changed
+func (o *Order) ApplyAccrual(acc *OrderAccrual) (bool, error) {
+	status, err := OrderStatusFromAccrual(acc.Status)
+	if err != nil {
+		return false, err
+	}
+
+	if !IsAllowedOrderTransition(o.Status, status) {
+		return false, fmt.Errorf("%w: %s -> %s", ErrInvalidOrderTransition, o.Status, status)
+	}
+
+	changed := o.Status != status
+	o.Status = status
+	o.Accrual = o.multiply(acc.Accrual)
+	o.AccrualResponse = acc.Raw
+
+	return changed, nil
 }
 
-// IsValidNumber returns true if OrderForm.Number checked by Luhn algorithm
+// ReviseAccrual sets processed order status and accrual from accrual service data.
+// Invalidated order has no accrual. Returns true if order status or accrual was changed
+func (o *Order) ReviseAccrual(acc *OrderAccrual) (bool, error) {
+	status, err := OrderStatusFromAccrual(acc.Status)
+	if err != nil {
+		return false, err
+	}
+
+	// order may be back in processing, wait for its final status
+	if status == OrderStatusProcessing {
+		return false, nil
+	}
+
+	if !IsAllowedOrderRevision(o.Status, status) {
+		return false, fmt.Errorf("%w: %s -> %s", ErrInvalidOrderTransition, o.Status, status)
+	}
+
+	accrual := o.multiply(acc.Accrual)
+	if status == OrderStatusInvalid {
+		accrual = 0
+	}
+
+	changed := o.Status != status || o.Accrual != accrual
+	o.Status = status
+	o.Accrual = accrual
+	o.AccrualResponse = acc.Raw
+
+	return changed, nil
+}
+
+// multiply applies order's loyalty tier multiplier to accrual
+func (o *Order) multiply(accrual Points) Points {
+	if o.Multiplier == 0 {
+		return accrual
+	}
+	return accrual.Mul(o.Multiplier)
+}
+
+// IsValidNumber returns true if OrderForm.Number consists of digits and is checked by Luhn algorithm
 func (of OrderForm) IsValidNumber() bool {
+	if of.Number == "" {
+		return false
+	}
+
 	sum := 0
 	double := false
 	for i := len(of.Number) - 1; i >= 0; i-- {
-		digit, _ := strconv.Atoi(string(of.Number[i]))
+		digit, err := strconv.Atoi(string(of.Number[i]))
+		if err != nil {
+			return false
+		}
 		if double {
 			digit *= 2
 			if digit > 9 {
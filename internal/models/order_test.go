@@ -0,0 +1,174 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrder_ApplyAccrual(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		acc         OrderAccrual
+		wantStatus  string
+		wantChanged bool
+		wantErr     error
+	}{
+		{
+			name:        "Test#1. Registered order is processing",
+			status:      OrderStatusNew,
+			acc:         OrderAccrual{Status: OrderAccrualStatusRegistered},
+			wantStatus:  OrderStatusProcessing,
+			wantChanged: true,
+		},
+		{
+			name:        "Test#2. Still processing",
+			status:      OrderStatusProcessing,
+			acc:         OrderAccrual{Status: OrderAccrualStatusProcessing},
+			wantStatus:  OrderStatusProcessing,
+			wantChanged: false,
+		},
+		{
+			name:        "Test#3. Processed",
+			status:      OrderStatusProcessing,
+			acc:         OrderAccrual{Status: OrderAccrualStatusProcessed, Accrual: 50000},
+			wantStatus:  OrderStatusProcessed,
+			wantChanged: true,
+		},
+		{
+			name:        "Test#4. Invalid",
+			status:      OrderStatusNew,
+			acc:         OrderAccrual{Status: OrderAccrualStatusInvalid},
+			wantStatus:  OrderStatusInvalid,
+			wantChanged: true,
+		},
+		{
+			name:       "Test#5. Processed is final",
+			status:     OrderStatusProcessed,
+			acc:        OrderAccrual{Status: OrderAccrualStatusProcessing},
+			wantStatus: OrderStatusProcessed,
+			wantErr:    ErrInvalidOrderTransition,
+		},
+		{
+			name:       "Test#6. Unknown accrual status",
+			status:     OrderStatusNew,
+			acc:        OrderAccrual{Status: "CANCELLED"},
+			wantStatus: OrderStatusNew,
+			wantErr:    ErrUnknownAccrualStatus,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := Order{Status: tt.status}
+			changed, err := o.ApplyAccrual(&tt.acc)
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.wantChanged, changed)
+			require.Equal(t, tt.wantStatus, o.Status)
+		})
+	}
+}
+
+func TestOrder_ReviseAccrual(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		accrual     Points
+		acc         OrderAccrual
+		wantStatus  string
+		wantAccrual Points
+		wantChanged bool
+		wantErr     error
+	}{
+		{
+			name:        "Test#1. Not changed",
+			status:      OrderStatusProcessed,
+			accrual:     50000,
+			acc:         OrderAccrual{Status: OrderAccrualStatusProcessed, Accrual: 50000},
+			wantStatus:  OrderStatusProcessed,
+			wantAccrual: 50000,
+		},
+		{
+			name:        "Test#2. Accrual decreased",
+			status:      OrderStatusProcessed,
+			accrual:     50000,
+			acc:         OrderAccrual{Status: OrderAccrualStatusProcessed, Accrual: 20000},
+			wantStatus:  OrderStatusProcessed,
+			wantAccrual: 20000,
+			wantChanged: true,
+		},
+		{
+			name:        "Test#3. Invalidated",
+			status:      OrderStatusProcessed,
+			accrual:     50000,
+			acc:         OrderAccrual{Status: OrderAccrualStatusInvalid, Accrual: 50000},
+			wantStatus:  OrderStatusInvalid,
+			wantAccrual: 0,
+			wantChanged: true,
+		},
+		{
+			name:        "Test#4. Back in processing",
+			status:      OrderStatusProcessed,
+			accrual:     50000,
+			acc:         OrderAccrual{Status: OrderAccrualStatusProcessing},
+			wantStatus:  OrderStatusProcessed,
+			wantAccrual: 50000,
+		},
+		{
+			name:        "Test#5. Invalid order is final",
+			status:      OrderStatusInvalid,
+			acc:         OrderAccrual{Status: OrderAccrualStatusProcessed, Accrual: 50000},
+			wantStatus:  OrderStatusInvalid,
+			wantAccrual: 0,
+			wantErr:     ErrInvalidOrderTransition,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := Order{Status: tt.status, Accrual: tt.accrual}
+			changed, err := o.ReviseAccrual(&tt.acc)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantChanged, changed)
+			require.Equal(t, tt.wantStatus, o.Status)
+			require.Equal(t, tt.wantAccrual, o.Accrual)
+		})
+	}
+}
+
+func TestOrder_ApplyAccrual_Multiplier(t *testing.T) {
+	o := Order{Status: OrderStatusProcessing, Multiplier: 1.05}
+	_, err := o.ApplyAccrual(&OrderAccrual{Status: OrderAccrualStatusProcessed, Accrual: 72999})
+	require.NoError(t, err)
+	require.Equal(t, Points(76649), o.Accrual)
+
+	// the same accrual isn't a revision
+	changed, err := o.ReviseAccrual(&OrderAccrual{Status: OrderAccrualStatusProcessed, Accrual: 72999})
+	require.NoError(t, err)
+	require.False(t, changed)
+}
+
+func TestOrderForm_IsValidNumber(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		want   bool
+	}{
+		{name: "Test#1. Valid number", number: "7305748056314637", want: true},
+		{name: "Test#2. Wrong checksum", number: "7305748056314638", want: false},
+		{name: "Test#3. Empty number", number: "", want: false},
+		{name: "Test#4. Letters", number: "abc", want: false},
+		{name: "Test#5. Zeros with letters", number: "00a0", want: false},
+		{name: "Test#6. Quoted number", number: `"7305748056314637"`, want: false},
+		{name: "Test#7. Space inside", number: "7305 748056314637", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, OrderForm{Number: tt.number}.IsValidNumber())
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxPasswordLength max password length in bytes, bcrypt ignores the rest
+const MaxPasswordLength = 72
+
+var (
+	// ErrInvalidLogin login format error
+	ErrInvalidLogin = errors.New("invalid login")
+
+	// ErrWeakPassword password doesn't match password policy error
+	ErrWeakPassword = errors.New("weak password")
+)
+
+// loginFormat 3-64 letters, digits and `.`, `_`, `-`, `@` starting from letter or digit
+var loginFormat = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{2,63}$`)
+
+// ValidateLogin checks login format
+func ValidateLogin(login string) error {
+	if !loginFormat.MatchString(login) {
+		return fmt.Errorf("%w: %q", ErrInvalidLogin, login)
+	}
+	return nil
+}
+
+// PasswordPolicy password rules checked on registration and password change
+type PasswordPolicy struct {
+	MinLength int                 // min password length in characters
+	Breached  map[string]struct{} // known leaked passwords
+}
+
+// DefaultPasswordPolicy returns policy used if it isn't configured
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinLength: 8}
+}
+
+// Validate checks password of the login matches the policy
+func (p PasswordPolicy) Validate(login, password string) error {
+	switch {
+	case utf8.RuneCountInString(password) < p.MinLength:
+		return fmt.Errorf("%w: shorter than %d characters", ErrWeakPassword, p.MinLength)
+	case len(password) > MaxPasswordLength:
+		return fmt.Errorf("%w: longer than %d bytes", ErrWeakPassword, MaxPasswordLength)
+	case strings.EqualFold(password, login):
+		return fmt.Errorf("%w: equal to login", ErrWeakPassword)
+	}
+	if _, ok := p.Breached[password]; ok {
+		return fmt.Errorf("%w: found in breached passwords list", ErrWeakPassword)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLogin(t *testing.T) {
+	for _, login := range []string{"user", "user.name", "user_1", "user-1", "user@example.com"} {
+		require.NoError(t, ValidateLogin(login), login)
+	}
+	for _, login := range []string{"", "us", "us er", ".user", "user\n", strings.Repeat("u", 65)} {
+		require.ErrorIs(t, ValidateLogin(login), ErrInvalidLogin, login)
+	}
+}
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	p := PasswordPolicy{MinLength: 8, Breached: map[string]struct{}{"password1": {}}}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{
+			name:     "Test#1. Valid password",
+			password: "correct horse",
+		},
+		{
+			name:     "Test#2. Empty password",
+			password: "",
+			wantErr:  true,
+		},
+		{
+			name:     "Test#3. Short password",
+			password: "short",
+			wantErr:  true,
+		},
+		{
+			name:     "Test#4. Length in characters",
+			password: "пароль№1",
+		},
+		{
+			name:     "Test#5. Longer than bcrypt limit",
+			password: strings.Repeat("p", MaxPasswordLength+1),
+			wantErr:  true,
+		},
+		{
+			name:     "Test#6. Equal to login",
+			password: "Username",
+			wantErr:  true,
+		},
+		{
+			name:     "Test#7. Breached password",
+			password: "password1",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Validate("username", tt.password)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrWeakPassword)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
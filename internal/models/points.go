@@ -0,0 +1,86 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// pointsScale number of minor units in one point
+const pointsScale = 100
+
+var (
+	// ErrInvalidPoints malformed or out of range points amount error
+	ErrInvalidPoints = errors.New("invalid points amount")
+)
+
+// Points is loyalty points amount stored as integer hundredths of a point.
+// It's marshaled to JSON as a decimal number, e.g. Points(72998) is 729.98
+type Points int64
+
+// String returns decimal representation of points without trailing zeros
+func (p Points) String() string {
+	sign := ""
+	u := uint64(p)
+	if p < 0 {
+		sign = "-"
+		u = uint64(-p)
+	}
+
+	whole, frac := u/pointsScale, u%pointsScale
+	if frac == 0 {
+		return sign + strconv.FormatUint(whole, 10)
+	}
+	if frac%10 == 0 {
+		return fmt.Sprintf("%s%d.%d", sign, whole, frac/10)
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, whole, frac)
+}
+
+// Mul returns points multiplied by factor, rounded half away from zero
+func (p Points) Mul(factor float64) Points {
+	return Points(math.Round(float64(p) * factor))
+}
+
+// MarshalJSON json.Marshaler implementation
+func (p Points) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalJSON json.Unmarshaler implementation, accepts JSON numbers.
+// Fractions smaller than hundredth are rounded half away from zero
+func (p *Points) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+
+	v, err := ParsePoints(s)
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
+
+// ParsePoints parses decimal number string exactly, without float conversion
+func ParsePoints(s string) (Points, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidPoints, s)
+	}
+	r.Mul(r, big.NewRat(pointsScale, 1))
+
+	// round half away from zero
+	n, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if rem.Abs(rem).Lsh(rem, 1).Cmp(r.Denom()) >= 0 {
+		n.Add(n, big.NewInt(int64(r.Sign())))
+	}
+
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidPoints, s)
+	}
+	return Points(n.Int64()), nil
+}
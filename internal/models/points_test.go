@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoints_JSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		want     Points
+		wantJSON string
+		wantErr  bool
+	}{
+		{
+			name:     "Test#1. Integer",
+			json:     "500",
+			want:     50000,
+			wantJSON: "500",
+		},
+		{
+			name:     "Test#2. Hundredths",
+			json:     "729.98",
+			want:     72998,
+			wantJSON: "729.98",
+		},
+		{
+			name:     "Test#3. Tenths",
+			json:     "0.1",
+			want:     10,
+			wantJSON: "0.1",
+		},
+		{
+			name:     "Test#4. Negative",
+			json:     "-0.05",
+			want:     -5,
+			wantJSON: "-0.05",
+		},
+		{
+			name:     "Test#5. Rounding",
+			json:     "0.125",
+			want:     13,
+			wantJSON: "0.13",
+		},
+		{
+			name:     "Test#6. Exponent",
+			json:     "1.5e2",
+			want:     15000,
+			wantJSON: "150",
+		},
+		{
+			name:    "Test#7. String",
+			json:    `"500"`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Points
+			err := json.Unmarshal([]byte(tt.json), &p)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, p)
+
+			b, err := json.Marshal(p)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantJSON, string(b))
+		})
+	}
+}
+
+func TestPoints_Sum(t *testing.T) {
+	// the sum that float64 gets wrong
+	var a, b Points
+	require.NoError(t, json.Unmarshal([]byte("729.97"), &a))
+	require.NoError(t, json.Unmarshal([]byte("0.01"), &b))
+	require.Equal(t, "729.98", (a + b).String())
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrReferralNotExists unknown referral code error
+	ErrReferralNotExists = errors.New("referral code is not exists")
+
+	// ErrReferralLimitReached referrer has max number of referred users error
+	ErrReferralLimitReached = errors.New("referrals limit is reached")
+)
+
+// referral bonus statuses
+const (
+	ReferralStatusPending = "PENDING"
+	ReferralStatusPaid    = "PAID"
+)
+
+const (
+	// referralCodeAlphabet referral code symbols without similar looking ones
+	referralCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+	// referralCodeLen referral code length
+	referralCodeLen = 10
+)
+
+// Referral user registered by referral code
+type Referral struct {
+	Login     string     `json:"login"`
+	Status    string     `json:"status"`
+	Bonus     Points     `json:"bonus"`
+	CreatedAt time.Time  `json:"registered_at"`
+	PaidAt    *time.Time `json:"paid_at,omitempty"`
+}
+
+// Referrals user's referral code and users registered by it
+type Referrals struct {
+	Code      string      `json:"code"`
+	Referrals []*Referral `json:"referrals"`
+}
+
+// NewReferralCode returns random referral code
+func NewReferralCode() (string, error) {
+	b := make([]byte, referralCodeLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("referral code generation error: %w", err)
+	}
+
+	for i := range b {
+		b[i] = referralCodeAlphabet[int(b[i])%len(referralCodeAlphabet)]
+	}
+	return string(b), nil
+}
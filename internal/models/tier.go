@@ -0,0 +1,122 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrInvalidTierRules malformed loyalty tier rules error
+	ErrInvalidTierRules = errors.New("invalid tier rules")
+)
+
+// Tier loyalty tier, users with accrued points not less than threshold get accrual multiplied
+type Tier struct {
+	Name       string  `json:"name"`
+	Threshold  Points  `json:"threshold"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// TierRules loyalty tiers sorted by threshold, tier is based on points accrued within the period
+type TierRules struct {
+	PeriodMonths int    `json:"period_months"`
+	Tiers        []Tier `json:"tiers"`
+}
+
+// UserTier user's loyalty tier recalculated by background job
+type UserTier struct {
+	UserID    string
+	Tier      string
+	Accrued   Points // points accrued within the rules period
+	UpdatedAt time.Time
+}
+
+// TierProgress user's loyalty tier and progress to the next one
+type TierProgress struct {
+	Tier          string     `json:"tier"`
+	Multiplier    float64    `json:"multiplier"`
+	Accrued       Points     `json:"accrued"`
+	NextTier      string     `json:"next_tier,omitempty"`
+	NextThreshold *Points    `json:"next_threshold,omitempty"`
+	Remaining     *Points    `json:"remaining,omitempty"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+// DefaultTierRules returns rules used if tiers config file isn't set
+func DefaultTierRules() TierRules {
+	return TierRules{
+		PeriodMonths: 12,
+		Tiers: []Tier{
+			{Name: "Basic", Threshold: 0, Multiplier: 1},
+			{Name: "Silver", Threshold: 100000, Multiplier: 1.05},
+			{Name: "Gold", Threshold: 500000, Multiplier: 1.1},
+			{Name: "Platinum", Threshold: 1500000, Multiplier: 1.2},
+		},
+	}
+}
+
+// Validate checks the rules start from zero threshold and thresholds grow
+func (r TierRules) Validate() error {
+	if r.PeriodMonths <= 0 {
+		return fmt.Errorf("%w: period must be positive", ErrInvalidTierRules)
+	}
+	if len(r.Tiers) == 0 || r.Tiers[0].Threshold != 0 {
+		return fmt.Errorf("%w: the first tier must have zero threshold", ErrInvalidTierRules)
+	}
+
+	names := make(map[string]bool, len(r.Tiers))
+	for i, t := range r.Tiers {
+		if t.Name == "" || names[t.Name] {
+			return fmt.Errorf("%w: empty or duplicated tier name %q", ErrInvalidTierRules, t.Name)
+		}
+		names[t.Name] = true
+
+		if t.Multiplier <= 0 {
+			return fmt.Errorf("%w: tier %q multiplier must be positive", ErrInvalidTierRules, t.Name)
+		}
+		if i > 0 && t.Threshold <= r.Tiers[i-1].Threshold {
+			return fmt.Errorf("%w: tier %q threshold must be greater than previous one", ErrInvalidTierRules, t.Name)
+		}
+	}
+
+	return nil
+}
+
+// ForPoints returns tier for accrued points and the next tier, nil for the top tier
+func (r TierRules) ForPoints(accrued Points) (Tier, *Tier) {
+	i := 0
+	for i+1 < len(r.Tiers) && accrued >= r.Tiers[i+1].Threshold {
+		i++
+	}
+
+	var next *Tier
+	if i+1 < len(r.Tiers) {
+		next = &r.Tiers[i+1]
+	}
+	return r.Tiers[i], next
+}
+
+// Progress returns user's tier and progress to the next tier
+func (r TierRules) Progress(ut UserTier) TierProgress {
+	tier, next := r.ForPoints(ut.Accrued)
+	p := TierProgress{
+		Tier:       tier.Name,
+		Multiplier: tier.Multiplier,
+		Accrued:    ut.Accrued,
+	}
+	if !ut.UpdatedAt.IsZero() {
+		updatedAt := ut.UpdatedAt
+		p.UpdatedAt = &updatedAt
+	}
+
+	if next != nil {
+		threshold := next.Threshold
+		remaining := next.Threshold - ut.Accrued
+		p.NextTier = next.Name
+		p.NextThreshold = &threshold
+		p.Remaining = &remaining
+	}
+
+	return p
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTierRules_Progress(t *testing.T) {
+	rules := DefaultTierRules()
+	require.NoError(t, rules.Validate())
+
+	tests := []struct {
+		name          string
+		accrued       Points
+		wantTier      string
+		wantNext      string
+		wantRemaining Points
+	}{
+		{
+			name:          "Test#1. No points",
+			accrued:       0,
+			wantTier:      "Basic",
+			wantNext:      "Silver",
+			wantRemaining: 100000,
+		},
+		{
+			name:          "Test#2. Threshold reached",
+			accrued:       100000,
+			wantTier:      "Silver",
+			wantNext:      "Gold",
+			wantRemaining: 400000,
+		},
+		{
+			name:     "Test#3. Top tier",
+			accrued:  2000000,
+			wantTier: "Platinum",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := rules.Progress(UserTier{Accrued: tt.accrued})
+			require.Equal(t, tt.wantTier, p.Tier)
+			require.Equal(t, tt.wantNext, p.NextTier)
+			if tt.wantNext == "" {
+				require.Nil(t, p.Remaining)
+			} else {
+				require.Equal(t, tt.wantRemaining, *p.Remaining)
+			}
+		})
+	}
+}
+
+func TestTierRules_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules TierRules
+	}{
+		{
+			name:  "Test#1. No tiers",
+			rules: TierRules{PeriodMonths: 12},
+		},
+		{
+			name:  "Test#2. Non zero first threshold",
+			rules: TierRules{PeriodMonths: 12, Tiers: []Tier{{Name: "Silver", Threshold: 100, Multiplier: 1}}},
+		},
+		{
+			name: "Test#3. Unsorted thresholds",
+			rules: TierRules{PeriodMonths: 12, Tiers: []Tier{
+				{Name: "Basic", Multiplier: 1},
+				{Name: "Gold", Threshold: 500, Multiplier: 1.1},
+				{Name: "Silver", Threshold: 100, Multiplier: 1.05},
+			}},
+		},
+		{
+			name:  "Test#4. Zero multiplier",
+			rules: TierRules{PeriodMonths: 12, Tiers: []Tier{{Name: "Basic"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.ErrorIs(t, tt.rules.Validate(), ErrInvalidTierRules)
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrRefreshTokenInvalid unknown, expired or revoked refresh token error
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+
+	// ErrRefreshTokenReused already rotated refresh token is used again error
+	ErrRefreshTokenReused = errors.New("refresh token is reused")
+)
+
+// refreshTokenLen refresh token random bytes length
+const refreshTokenLen = 32
+
+// NewRefreshToken returns random opaque refresh token
+func NewRefreshToken() (string, error) {
+	b := make([]byte, refreshTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("refresh token generation error: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashRefreshToken returns hash refresh token is stored by
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrRecipientNotExists unknown transfer recipient login error
+	ErrRecipientNotExists = errors.New("recipient is not exists")
+
+	// ErrTransferToSelf sender and recipient are the same user error
+	ErrTransferToSelf = errors.New("points can't be transferred to yourself")
+
+	// ErrTransferLimitExceeded sender's daily transfers limit error
+	ErrTransferLimitExceeded = errors.New("daily transfers limit is exceeded")
+)
+
+// transfer directions relative to the user
+const (
+	TransferDirectionIn  = "IN"
+	TransferDirectionOut = "OUT"
+)
+
+// Transfer points transfer between users
+type Transfer struct {
+	ID        string    `json:"-"`
+	Direction string    `json:"direction"`
+	Login     string    `json:"login"` // recipient of outgoing or sender of incoming transfer
+	Amount    Points    `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
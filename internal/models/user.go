@@ -16,12 +16,22 @@ var (
 
 	// ErrNotEnoughPoints too small points balance error
 	ErrNotEnoughPoints = errors.New("not enough points")
+
+	// ErrWithdrawalAlreadyExists not unique withdrawal order number error
+	ErrWithdrawalAlreadyExists = errors.New("withdrawal is already exists")
+
+	// ErrWithdrawalNotExists withdrawal not found error
+	ErrWithdrawalNotExists = errors.New("withdrawal is not exists")
+
+	// ErrWithdrawalNotCancellable withdrawal is completed or cancelled already error
+	ErrWithdrawalNotCancellable = errors.New("withdrawal can't be cancelled")
 )
 
 // UserForm data object from request
 type UserForm struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
+	Referral string `json:"referral,omitempty"` // referral code of the user invited the new one
 }
 
 // User data object from storage
@@ -30,18 +40,44 @@ type User struct {
 	// ID           uuid.UUID `json:"id"`
 	Login        string `json:"login"`
 	PasswordHash string `json:"password"`
+	ReferralCode string `json:"referral_code"`
+	// PasswordChangedAt last password change in milliseconds since epoch, zero if it has never been changed
+	PasswordChangedAt int64 `json:"-"`
 	// CreatedAt
 }
 
-// UserBalance current accrualed balance and total withdrawned
+// UserBalance current spendable balance, held accruals, total withdrawned and transferred
 type UserBalance struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current        Points           `json:"current"`
+	Pending        Points           `json:"pending"`
+	Withdrawn      Points           `json:"withdrawn"`
+	TransferredIn  Points           `json:"transferred_in"`
+	TransferredOut Points           `json:"transferred_out"`
+	ExpiringSoon   []ExpiringPoints `json:"expiring_soon,omitempty"`
 }
 
+// ExpiringPoints points amount expiring at the date
+type ExpiringPoints struct {
+	Amount    Points    `json:"amount"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+const (
+	// WithdrawalStatusPending withdrawal may be cancelled yet
+	WithdrawalStatusPending = "PENDING"
+	// WithdrawalStatusCompleted withdrawal is final
+	WithdrawalStatusCompleted = "COMPLETED"
+	// WithdrawalStatusCancelled withdrawal is cancelled and points are refunded
+	WithdrawalStatusCancelled = "CANCELLED"
+)
+
 // Withdrawal some point withdrawal operation
 type Withdrawal struct {
-	OrderNumber string    `json:"order"`
-	Total       float64   `json:"sum"`
-	CreatedAt   time.Time `json:"processed_at"`
+	ID          string     `json:"-"`
+	UserID      string     `json:"-"`
+	OrderNumber string     `json:"order"`
+	Total       Points     `json:"sum"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"processed_at"`
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
 }
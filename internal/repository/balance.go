@@ -2,52 +2,83 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
-// GetUserBalance ...
+// GetUserBalance returns user's balance maintained by ledger postings, transfers totals and points expiring soon
 func (db *DB) GetUserBalance(ctx context.Context, userID string) (*models.UserBalance, error) {
-	query := `
-    SELECT
-    	current_sum - withdrawn_sum AS current,
-    	withdrawn_sum AS withdrawn
-	FROM (
-    	SELECT COALESCE(SUM(o.accrual), 0) AS current_sum
-    	FROM "order" o 
-    	WHERE o.user_id = $1
-	) AS orders,
-	(
-    	SELECT COALESCE(SUM(w.total), 0) AS withdrawn_sum
-    	FROM withdrawal w
-    	WHERE w.user_id = $1
-	) AS withdrawals;`
-	row := db.pool.QueryRow(ctx, query, userID)
+	row := db.pool.QueryRow(ctx, `SELECT current, pending, withdrawn FROM balance WHERE user_id = $1;`, userID)
 	ub := models.UserBalance{}
-	err := row.Scan(&ub.Current, &ub.Withdrawn)
+	if err := row.Scan(&ub.Current, &ub.Pending, &ub.Withdrawn); err != nil {
+		// every user has balance since registration
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrUserNotExists
+		}
+		return nil, fmt.Errorf("row scan error: %w", err)
+	}
+
+	// incoming and outgoing transfers totals
+	row = db.pool.QueryRow(
+		ctx,
+		`SELECT
+			COALESCE(SUM(amount) FILTER (WHERE recipient_id = $1), 0)::BIGINT,
+			COALESCE(SUM(amount) FILTER (WHERE sender_id = $1), 0)::BIGINT
+		FROM transfer WHERE sender_id = $1 OR recipient_id = $1;`,
+		userID,
+	)
+	if err := row.Scan(&ub.TransferredIn, &ub.TransferredOut); err != nil {
+		return nil, fmt.Errorf("row scan error: %w", err)
+	}
+
+	// expiring soon points lots grouped by expiration date
+	rows, err := db.pool.Query(
+		ctx,
+		`SELECT SUM(remaining)::BIGINT, expires_at FROM points_lot
+		WHERE user_id = $1 AND NOT held AND remaining > 0 AND expires_at <= NOW() + $2 * INTERVAL '1 millisecond'
+		GROUP BY expires_at
+		ORDER BY expires_at ASC;`,
+		userID,
+		db.expiringSoon.Milliseconds(),
+	)
 	if err != nil {
-		if err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("expiring points query error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ep models.ExpiringPoints
+		if err := rows.Scan(&ep.Amount, &ep.ExpiresAt); err != nil {
 			return nil, fmt.Errorf("row scan error: %w", err)
 		}
+		ub.ExpiringSoon = append(ub.ExpiringSoon, ep)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows scan error: %w", err)
 	}
 
 	return &ub, nil
 }
 
-// CreateWithdrawal ...
-// @todo check not unique number column value error
-func (db *DB) CreateWithdrawal(ctx context.Context, userID string, number string, total float64) error {
-	// check points balance
-	row := db.pool.QueryRow(
-		ctx,
-		`SELECT COALESCE(SUM(accrual), 0) AS current FROM "order" WHERE user_id = $1 GROUP BY user_id;`,
-		userID,
-	)
-	ub := models.UserBalance{}
-	if err := row.Scan(&ub.Current); err != nil {
-		return fmt.Errorf("row scan error: %w", err)
+// CreateWithdrawal checks user's points balance, creates withdrawal and posts it to the ledger atomically.
+// Concurrent withdrawals of the same user are serialized by the balance row lock
+func (db *DB) CreateWithdrawal(ctx context.Context, userID string, number string, total models.Points) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	// lock and check points balance
+	ub, err := lockBalance(ctx, tx, userID)
+	if err != nil {
+		return err
 	}
 	// to small points balance
 	if ub.Current < total {
@@ -55,7 +86,7 @@ func (db *DB) CreateWithdrawal(ctx context.Context, userID string, number string
 	}
 
 	// balance is ok
-	_, err := db.pool.Exec(
+	_, err = tx.Exec(
 		ctx,
 		`INSERT INTO withdrawal (user_id, number, total) VALUES ($1, $2, $3);`,
 		userID,
@@ -63,18 +94,60 @@ func (db *DB) CreateWithdrawal(ctx context.Context, userID string, number string
 		total,
 	)
 	if err != nil {
+		var pgErr *pgconn.PgError
+		// check pg error for detect `duplicated number` error
+		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) && pgErr.ConstraintName == "withdrawal_number_key" {
+			return models.ErrWithdrawalAlreadyExists
+		}
 		return fmt.Errorf("withdrawal insert error: %w", err)
 	}
+
+	// move points from user to withdrawal account
+	err = db.postEntry(ctx, tx, models.LedgerEntry{
+		Kind:      models.LedgerEntryWithdrawal,
+		From:      models.UserAccount(userID),
+		To:        models.AccountWithdrawal,
+		Amount:    total,
+		Reference: number,
+		Reason:    "points withdrawal",
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction error: %w", err)
+	}
 	return nil
 }
 
-// GetWithdrawals ...
-func (db *DB) GetWithdrawals(ctx context.Context, userID string) ([]*models.Withdrawal, error) {
+// GetWithdrawal returns withdrawal by order number
+func (db *DB) GetWithdrawal(ctx context.Context, number string) (*models.Withdrawal, error) {
+	row := db.pool.QueryRow(
+		ctx,
+		`SELECT id::TEXT, user_id, total, number, status, created_at, cancelled_at FROM withdrawal WHERE number = $1;`,
+		number,
+	)
+	w := models.Withdrawal{}
+	if err := row.Scan(&w.ID, &w.UserID, &w.Total, &w.OrderNumber, &w.Status, &w.CreatedAt, &w.CancelledAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrWithdrawalNotExists
+		}
+		return nil, fmt.Errorf("row scan error: %w", err)
+	}
+
+	return &w, nil
+}
+
+// GetWithdrawals returns user's withdrawals filtered, sorted and paginated by list query.
+// Returns ErrUserNotExists if user doesn't exist
+func (db *DB) GetWithdrawals(ctx context.Context, userID string, q models.ListQuery) ([]*models.Withdrawal, error) {
 	var withdrwls []*models.Withdrawal
+	clauses, args := listQuerySQL(q, "created_at", "id", []any{userID})
 	rows, err := db.pool.Query(
 		ctx,
-		`SELECT total, number, created_at FROM withdrawal WHERE user_id = $1 ORDER BY created_at DESC;`,
-		userID,
+		`SELECT id::TEXT, total, number, status, created_at, cancelled_at FROM withdrawal WHERE user_id = $1`+clauses+`;`,
+		args...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("get withdrawals error: %w", err)
@@ -84,7 +157,7 @@ func (db *DB) GetWithdrawals(ctx context.Context, userID string) ([]*models.With
 	// scan query result
 	for rows.Next() {
 		var w models.Withdrawal
-		if err := rows.Scan(&w.Total, &w.OrderNumber, &w.CreatedAt); err != nil {
+		if err := rows.Scan(&w.ID, &w.Total, &w.OrderNumber, &w.Status, &w.CreatedAt, &w.CancelledAt); err != nil {
 			return nil, fmt.Errorf("rows scan error: %w", err)
 		}
 
@@ -94,5 +167,93 @@ func (db *DB) GetWithdrawals(ctx context.Context, userID string) ([]*models.With
 		return nil, fmt.Errorf("rows scan error: %w", err)
 	}
 
+	// empty list of deleted user isn't a valid result
+	if len(withdrwls) == 0 {
+		if err := db.checkUser(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+
 	return withdrwls, nil
 }
+
+// CancelWithdrawal cancels user's pending withdrawal created within the window and refunds its points atomically
+func (db *DB) CancelWithdrawal(ctx context.Context, userID, number string, window time.Duration) (*models.Withdrawal, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	// balance is locked first, like in CreateWithdrawal
+	if _, err := lockBalance(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	// lock withdrawal
+	row := tx.QueryRow(
+		ctx,
+		`SELECT id::TEXT, user_id, total, number, status, created_at, created_at >= NOW() - $3 * INTERVAL '1 millisecond'
+		FROM withdrawal WHERE user_id = $1 AND number = $2 FOR UPDATE;`,
+		userID,
+		number,
+		window.Milliseconds(),
+	)
+	w := models.Withdrawal{}
+	var inWindow bool
+	if err := row.Scan(&w.ID, &w.UserID, &w.Total, &w.OrderNumber, &w.Status, &w.CreatedAt, &inWindow); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrWithdrawalNotExists
+		}
+		return nil, fmt.Errorf("lock withdrawal error: %w", err)
+	}
+	if w.Status != models.WithdrawalStatusPending || !inWindow {
+		return nil, models.ErrWithdrawalNotCancellable
+	}
+
+	// cancel
+	row = tx.QueryRow(
+		ctx,
+		`UPDATE withdrawal SET status = $2, cancelled_at = NOW() WHERE id = $1 RETURNING status, cancelled_at;`,
+		w.ID,
+		models.WithdrawalStatusCancelled,
+	)
+	if err := row.Scan(&w.Status, &w.CancelledAt); err != nil {
+		return nil, fmt.Errorf("withdrawal update error: %w", err)
+	}
+
+	// move points back from withdrawal to user account
+	err = db.postEntry(ctx, tx, models.LedgerEntry{
+		Kind:      models.LedgerEntryReversal,
+		From:      models.AccountWithdrawal,
+		To:        models.UserAccount(userID),
+		Amount:    w.Total,
+		Reference: number,
+		Reason:    "withdrawal cancelled",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction error: %w", err)
+	}
+	return &w, nil
+}
+
+// CompleteWithdrawals completes pending withdrawals which are out of the cancellation window
+func (db *DB) CompleteWithdrawals(ctx context.Context, window time.Duration) (int64, error) {
+	tag, err := db.pool.Exec(
+		ctx,
+		`UPDATE withdrawal SET status = $1
+		WHERE status = $2 AND created_at < NOW() - $3 * INTERVAL '1 millisecond';`,
+		models.WithdrawalStatusCompleted,
+		models.WithdrawalStatusPending,
+		window.Milliseconds(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("complete withdrawals error: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_CreateWithdrawal_Concurrent(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	u := newTestUser(t, db)
+
+	// user has 100 points
+	o, err := db.CreateOrder(ctx, models.OrderForm{UserID: u.ID, Number: strconv.FormatInt(base, 10)})
+	require.NoError(t, err)
+	o.Status = models.OrderStatusProcessed
+	o.Accrual = 10000
+	require.NoError(t, db.UpdateOrder(ctx, testOwner, leaseOrder(t, db, o)))
+
+	// 50 concurrent withdrawals of 10 points, only 10 of them fit the balance
+	const workers = 50
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		created  int
+		rejected int
+	)
+	for i := 1; i <= workers; i++ {
+		wg.Add(1)
+		go func(number string) {
+			defer wg.Done()
+
+			err := db.CreateWithdrawal(ctx, u.ID, number, 1000)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				created++
+			case errors.Is(err, models.ErrNotEnoughPoints):
+				rejected++
+			default:
+				t.Error(err)
+			}
+		}(strconv.FormatInt(base+int64(i), 10))
+	}
+	wg.Wait()
+
+	require.Equal(t, 10, created)
+	require.Equal(t, workers-10, rejected)
+
+	// balance never goes negative
+	ub, err := db.GetUserBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Points(0), ub.Current)
+	require.Equal(t, models.Points(10000), ub.Withdrawn)
+
+	// points can't be withdrawn twice
+	err = db.CreateWithdrawal(ctx, u.ID, strconv.FormatInt(base+workers+1, 10), 1000)
+	require.ErrorIs(t, err, models.ErrNotEnoughPoints)
+}
+
+func TestDB_CancelWithdrawal(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	u := newTestUser(t, db)
+
+	// user has 100 points
+	o, err := db.CreateOrder(ctx, models.OrderForm{UserID: u.ID, Number: strconv.FormatInt(base, 10)})
+	require.NoError(t, err)
+	o.Status = models.OrderStatusProcessed
+	o.Accrual = 10000
+	require.NoError(t, db.UpdateOrder(ctx, testOwner, leaseOrder(t, db, o)))
+
+	number := strconv.FormatInt(base+1, 10)
+	require.NoError(t, db.CreateWithdrawal(ctx, u.ID, number, 4000))
+
+	// points are refunded
+	w, err := db.CancelWithdrawal(ctx, u.ID, number, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, models.WithdrawalStatusCancelled, w.Status)
+	require.NotNil(t, w.CancelledAt)
+
+	ub, err := db.GetUserBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Points(10000), ub.Current)
+	require.Equal(t, models.Points(0), ub.Withdrawn)
+
+	// withdrawal can't be cancelled twice
+	_, err = db.CancelWithdrawal(ctx, u.ID, number, time.Hour)
+	require.ErrorIs(t, err, models.ErrWithdrawalNotCancellable)
+
+	// completed withdrawal can't be cancelled
+	number = strconv.FormatInt(base+2, 10)
+	require.NoError(t, db.CreateWithdrawal(ctx, u.ID, number, 4000))
+	_, err = db.CompleteWithdrawals(ctx, 0)
+	require.NoError(t, err)
+	_, err = db.CancelWithdrawal(ctx, u.ID, number, time.Hour)
+	require.ErrorIs(t, err, models.ErrWithdrawalNotCancellable)
+}
@@ -4,28 +4,107 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io/fs"
 	"time"
 
+	"github.com/SerjRamone/gophermart/internal/models"
 	"github.com/SerjRamone/gophermart/internal/server/handlers"
 	"github.com/SerjRamone/gophermart/migrations"
 	"github.com/SerjRamone/gophermart/pkg/logger"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
 
 	"github.com/pressly/goose/v3"
 )
 
-var _ handlers.Storage = (*DB)(nil)
+var (
+	_ handlers.Storage    = (*DB)(nil)
+	_ handlers.LoginGuard = (*DB)(nil)
+)
+
+// defaultExpiringSoon default period points are reported as expiring soon
+const defaultExpiringSoon = 30 * 24 * time.Hour
 
 // DB ...
 type DB struct {
-	pool *pgxpool.Pool
+	pool          *pgxpool.Pool
+	expiryMonths  int           // credited points lifetime, 0 means points never expire
+	expiringSoon  time.Duration // period points are reported as expiring soon
+	holdDays      int           // accrued points hold period, 0 means points are spendable at once
+	referralBonus models.Points // bonus paid to both referrer and referred user, 0 disables bonuses
+	maxReferrals  int           // max users referred by one user, 0 means unlimited
+	transferLimit models.Points // max points transferred by user within a day, 0 means unlimited
+	transferCount int           // max transfers made by user within a day, 0 means unlimited
+	loginPolicy   models.LoginPolicy
+}
+
+// Option ...
+type Option func(*DB)
+
+// PointsExpiryOption return Option func for setting credited points lifetime in months
+func PointsExpiryOption(months int) Option {
+	return func(db *DB) {
+		if months > 0 {
+			db.expiryMonths = months
+		}
+	}
+}
+
+// PointsHoldOption return Option func for setting accrued points hold period in days
+func PointsHoldOption(days int) Option {
+	return func(db *DB) {
+		if days > 0 {
+			db.holdDays = days
+		}
+	}
+}
+
+// TransferLimitOption return Option func for setting daily transfers limits of one user
+func TransferLimitOption(amount models.Points, count int) Option {
+	return func(db *DB) {
+		if amount > 0 {
+			db.transferLimit = amount
+		}
+		if count > 0 {
+			db.transferCount = count
+		}
+	}
+}
+
+// ReferralOption return Option func for setting referral bonus and max users referred by one user
+func ReferralOption(bonus models.Points, maxReferrals int) Option {
+	return func(db *DB) {
+		if bonus > 0 {
+			db.referralBonus = bonus
+		}
+		if maxReferrals > 0 {
+			db.maxReferrals = maxReferrals
+		}
+	}
+}
+
+// LoginPolicyOption return Option func for setting login brute-force protection policy
+func LoginPolicyOption(policy models.LoginPolicy) Option {
+	return func(db *DB) {
+		db.loginPolicy = policy
+	}
+}
+
+// ExpiringSoonOption return Option func for setting period points are reported as expiring soon
+func ExpiringSoonOption(period time.Duration) Option {
+	return func(db *DB) {
+		if period > 0 {
+			db.expiringSoon = period
+		}
+	}
 }
 
 // NewDB creates DB instance
-func NewDB(ctx context.Context, dsn string) (*DB, error) {
+func NewDB(ctx context.Context, dsn string, opts ...Option) (*DB, error) {
 	pool, err := pgxpool.New(ctx, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to created pool: %w", err)
@@ -53,9 +132,18 @@ func NewDB(ctx context.Context, dsn string) (*DB, error) {
 	}
 	defer db.Close()
 
-	return &DB{
-		pool: pool,
-	}, nil
+	d := &DB{
+		pool:         pool,
+		expiringSoon: defaultExpiringSoon,
+		loginPolicy:  models.DefaultLoginPolicy(),
+	}
+
+	// apply options
+	for _, fn := range opts {
+		fn(d)
+	}
+
+	return d, nil
 }
 
 // Close closes all connections in the pool
@@ -64,6 +152,13 @@ func (db *DB) Close() {
 	db.pool.Close()
 }
 
+// rollback rollbacks transaction if it wasn't committed
+func rollback(ctx context.Context, tx pgx.Tx) {
+	if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+		logger.Error("transaction rollback error", zap.Error(err))
+	}
+}
+
 // applyMigrations applies DB migrations (goose.Up())
 func applyMigrations(db *sql.DB, fsys fs.FS) error {
 	goose.SetBaseFS(fsys)
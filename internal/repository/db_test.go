@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDB connects to DB from TEST_DATABASE_URI env or skips the test
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	db, err := NewDB(context.Background(), dsn)
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+
+	return db
+}
+
+// testOwner order lease owner of tests
+const testOwner = "test"
+
+// leaseOrder leases the order to the test owner as ClaimOrders does
+func leaseOrder(t *testing.T, db *DB, o *models.Order) *models.Order {
+	t.Helper()
+
+	_, err := db.pool.Exec(
+		context.Background(),
+		`UPDATE "order" SET locked_by = $2, locked_until = NOW() + INTERVAL '1 minute' WHERE id = $1;`,
+		o.ID,
+		testOwner,
+	)
+	require.NoError(t, err)
+
+	return o
+}
+
+// testUsers number of users created by the test run
+var testUsers atomic.Int64
+
+// newTestUser creates user with login unique for every test run
+func newTestUser(t *testing.T, db *DB) *models.User {
+	t.Helper()
+
+	u, err := db.CreateUser(context.Background(), models.UserForm{
+		Login:    fmt.Sprintf("user-%d-%d", time.Now().UnixNano()/1000, testUsers.Add(1)),
+		Password: "hash",
+	})
+	require.NoError(t, err)
+
+	return u
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+)
+
+// expireBatch max users processed by one ExpirePoints or ReleaseHeldPoints call
+const expireBatch = 100
+
+// ExpirePoints writes off remaining points of expired lots, returns number of users whose points expired
+func (db *DB) ExpirePoints(ctx context.Context) (int, error) {
+	users, err := db.lotUsers(ctx, `NOT held AND remaining > 0 AND expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, userID := range users {
+		if err := db.expireUserPoints(ctx, userID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(users), nil
+}
+
+// lotUsers returns up to expireBatch users having points lots matching the condition
+func (db *DB) lotUsers(ctx context.Context, where string) ([]string, error) {
+	rows, err := db.pool.Query(
+		ctx,
+		`SELECT DISTINCT user_id FROM points_lot WHERE `+where+` LIMIT $1;`,
+		expireBatch,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("points lots users query error: %w", err)
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("row scan error: %w", err)
+		}
+		users = append(users, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows scan error: %w", err)
+	}
+
+	return users, nil
+}
+
+// expireUserPoints writes off remaining points of user's expired lots atomically
+func (db *DB) expireUserPoints(ctx context.Context, userID string) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	// balance is locked before lots, like in postEntry and ReviseOrder
+	ub, err := lockBalance(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	// empty expired lots
+	rows, err := tx.Query(
+		ctx,
+		`UPDATE points_lot l SET remaining = 0
+		FROM (
+			SELECT id, remaining FROM points_lot
+			WHERE user_id = $1 AND NOT held AND remaining > 0 AND expires_at <= NOW()
+			FOR UPDATE
+		) e
+		WHERE l.id = e.id
+		RETURNING l.reference, e.remaining;`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("expired points lots update error: %w", err)
+	}
+
+	type lot struct {
+		reference string
+		remaining models.Points
+	}
+	var lots []lot
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.reference, &l.remaining); err != nil {
+			rows.Close()
+			return fmt.Errorf("row scan error: %w", err)
+		}
+		lots = append(lots, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows scan error: %w", err)
+	}
+
+	// write off no more than user has
+	available := ub.Current
+	for _, l := range lots {
+		amount := min(l.remaining, available)
+		if amount <= 0 {
+			continue
+		}
+		available -= amount
+
+		err = db.postEntry(ctx, tx, models.LedgerEntry{
+			Kind:      models.LedgerEntryExpiration,
+			From:      models.UserAccount(userID),
+			To:        models.AccountExpiration,
+			Amount:    amount,
+			Reference: l.reference,
+			Reason:    "points expired",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ExpirePoints(t *testing.T) {
+	db := newTestDB(t)
+	db.expiryMonths = 12
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	u := newTestUser(t, db)
+
+	// two accruals of 100 points
+	for i := int64(0); i < 2; i++ {
+		o, err := db.CreateOrder(ctx, models.OrderForm{UserID: u.ID, Number: strconv.FormatInt(base+i, 10)})
+		require.NoError(t, err)
+		o.Status = models.OrderStatusProcessed
+		o.Accrual = 10000
+		require.NoError(t, db.UpdateOrder(ctx, testOwner, leaseOrder(t, db, o)))
+	}
+
+	// the first accrual expires soon, 60 of its points are spent
+	_, err := db.pool.Exec(ctx,
+		`UPDATE points_lot SET expires_at = NOW() + INTERVAL '1 day' WHERE user_id = $1 AND reference = $2;`,
+		u.ID, strconv.FormatInt(base, 10))
+	require.NoError(t, err)
+	require.NoError(t, db.CreateWithdrawal(ctx, u.ID, strconv.FormatInt(base+2, 10), 6000))
+
+	ub, err := db.GetUserBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Len(t, ub.ExpiringSoon, 1)
+	require.Equal(t, models.Points(4000), ub.ExpiringSoon[0].Amount)
+
+	// the first accrual expires
+	_, err = db.pool.Exec(ctx,
+		`UPDATE points_lot SET expires_at = NOW() - INTERVAL '1 second' WHERE user_id = $1 AND reference = $2;`,
+		u.ID, strconv.FormatInt(base, 10))
+	require.NoError(t, err)
+	_, err = db.ExpirePoints(ctx)
+	require.NoError(t, err)
+
+	ub, err = db.GetUserBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Points(10000), ub.Current)
+	require.Empty(t, ub.ExpiringSoon)
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// ReleaseHeldPoints makes held points spendable after the hold period, returns number of users whose points released
+func (db *DB) ReleaseHeldPoints(ctx context.Context) (int, error) {
+	users, err := db.lotUsers(ctx, `held AND available_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, userID := range users {
+		if err := db.releaseUserPoints(ctx, userID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(users), nil
+}
+
+// releaseUserPoints moves points of user's released lots from pending to current balance atomically
+func (db *DB) releaseUserPoints(ctx context.Context, userID string) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	// balance is locked before lots, like in postEntry and ReviseOrder
+	if _, err := lockBalance(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	lots, err := lockLots(ctx, tx, `user_id = $1 AND held AND available_at <= NOW()`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range lots {
+		current, err := updateBalance(ctx, tx, userID, l.remaining, -l.remaining, 0)
+		if err != nil {
+			return err
+		}
+
+		// negative balance is paid off first
+		remaining := l.remaining
+		if remaining > current {
+			remaining = max(current, 0)
+		}
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE points_lot SET held = FALSE, remaining = $2 WHERE id = $1;`,
+			l.id,
+			remaining,
+		)
+		if err != nil {
+			return fmt.Errorf("points lot update error: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction error: %w", err)
+	}
+	return nil
+}
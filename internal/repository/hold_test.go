@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ReleaseHeldPoints(t *testing.T) {
+	db := newTestDB(t)
+	db.holdDays = 14
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	u := newTestUser(t, db)
+
+	// 100 points are held
+	o, err := db.CreateOrder(ctx, models.OrderForm{UserID: u.ID, Number: strconv.FormatInt(base, 10)})
+	require.NoError(t, err)
+	o.Status = models.OrderStatusProcessed
+	o.Accrual = 10000
+	require.NoError(t, db.UpdateOrder(ctx, testOwner, leaseOrder(t, db, o)))
+
+	ub, err := db.GetUserBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Points(0), ub.Current)
+	require.Equal(t, models.Points(10000), ub.Pending)
+
+	// held points can't be spent
+	err = db.CreateWithdrawal(ctx, u.ID, strconv.FormatInt(base+1, 10), 1000)
+	require.ErrorIs(t, err, models.ErrNotEnoughPoints)
+
+	// accrual is decreased while it's held
+	o.Accrual = 6000
+	require.NoError(t, db.ReviseOrder(ctx, testOwner, leaseOrder(t, db, o)))
+
+	ub, err = db.GetUserBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Points(0), ub.Current)
+	require.Equal(t, models.Points(6000), ub.Pending)
+
+	// hold period is over
+	_, err = db.pool.Exec(ctx, `UPDATE points_lot SET available_at = NOW() WHERE user_id = $1;`, u.ID)
+	require.NoError(t, err)
+	_, err = db.ReleaseHeldPoints(ctx)
+	require.NoError(t, err)
+
+	ub, err = db.GetUserBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Points(6000), ub.Current)
+	require.Equal(t, models.Points(0), ub.Pending)
+	require.NoError(t, db.CreateWithdrawal(ctx, u.ID, strconv.FormatInt(base+1, 10), 1000))
+}
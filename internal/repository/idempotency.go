@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+)
+
+// StartIdempotentRequest stores idempotency key of the request in progress, the request holds the key for the lock time.
+// Returns nil response if the request is the first one with the key, or stored response of the first request.
+// Expired keys and keys of the same request crashed in progress are taken over by the request
+func (db *DB) StartIdempotentRequest(ctx context.Context, userID, key, requestHash string, ttl, lock time.Duration) (*models.IdempotentResponse, error) {
+	// the first request with the key
+	tag, err := db.pool.Exec(
+		ctx,
+		`INSERT INTO idempotency_key (user_id, key, request_hash, locked_until, expires_at)
+		VALUES ($1, $2, $3, NOW() + $5 * INTERVAL '1 millisecond', NOW() + $4 * INTERVAL '1 millisecond')
+		ON CONFLICT (user_id, key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash,
+			status = NULL,
+			content_type = NULL,
+			body = NULL,
+			created_at = NOW(),
+			locked_until = EXCLUDED.locked_until,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_key.expires_at < NOW()
+			OR (idempotency_key.status IS NULL
+				AND idempotency_key.locked_until < NOW()
+				AND idempotency_key.request_hash = EXCLUDED.request_hash);`,
+		userID,
+		key,
+		requestHash,
+		ttl.Milliseconds(),
+		lock.Milliseconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("idempotency key insert error: %w", err)
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	// retry of the request
+	var (
+		hash        string
+		status      *int
+		contentType *string
+		body        []byte
+	)
+	row := db.pool.QueryRow(
+		ctx,
+		`SELECT request_hash, status, content_type, body FROM idempotency_key WHERE user_id = $1 AND key = $2;`,
+		userID,
+		key,
+	)
+	if err := row.Scan(&hash, &status, &contentType, &body); err != nil {
+		return nil, fmt.Errorf("row scan error: %w", err)
+	}
+
+	if hash != requestHash {
+		return nil, models.ErrIdempotencyKeyMismatch
+	}
+	if status == nil {
+		return nil, models.ErrIdempotencyKeyInProgress
+	}
+
+	resp := models.IdempotentResponse{
+		Status: *status,
+		Body:   body,
+	}
+	if contentType != nil {
+		resp.ContentType = *contentType
+	}
+
+	return &resp, nil
+}
+
+// FinishIdempotentRequest stores response of the request with idempotency key
+func (db *DB) FinishIdempotentRequest(ctx context.Context, userID, key string, resp models.IdempotentResponse) error {
+	_, err := db.pool.Exec(
+		ctx,
+		`UPDATE idempotency_key SET status = $3, content_type = $4, body = $5 WHERE user_id = $1 AND key = $2;`,
+		userID,
+		key,
+		resp.Status,
+		resp.ContentType,
+		resp.Body,
+	)
+	if err != nil {
+		return fmt.Errorf("idempotency key update error: %w", err)
+	}
+
+	return nil
+}
+
+// CancelIdempotentRequest forgets idempotency key, so the request may be retried
+func (db *DB) CancelIdempotentRequest(ctx context.Context, userID, key string) error {
+	_, err := db.pool.Exec(
+		ctx,
+		`DELETE FROM idempotency_key WHERE user_id = $1 AND key = $2;`,
+		userID,
+		key,
+	)
+	if err != nil {
+		return fmt.Errorf("idempotency key delete error: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes expired idempotency keys, their responses aren't replayed anymore
+func (db *DB) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	tag, err := db.pool.Exec(ctx, `DELETE FROM idempotency_key WHERE expires_at < NOW();`)
+	if err != nil {
+		return 0, fmt.Errorf("expired idempotency keys delete error: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
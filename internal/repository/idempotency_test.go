@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_StartIdempotentRequest(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+
+	// the first request holds the key
+	resp, err := db.StartIdempotentRequest(ctx, u.ID, "key", "hash", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	// retries wait while the lock is held
+	_, err = db.StartIdempotentRequest(ctx, u.ID, "key", "hash", time.Hour, time.Minute)
+	require.ErrorIs(t, err, models.ErrIdempotencyKeyInProgress)
+
+	// the first request crashed, its lock is expired
+	_, err = db.pool.Exec(ctx, `UPDATE idempotency_key SET locked_until = NOW() - INTERVAL '1 second' WHERE user_id = $1;`, u.ID)
+	require.NoError(t, err)
+
+	// another request can't take over the key
+	_, err = db.StartIdempotentRequest(ctx, u.ID, "key", "other", time.Hour, time.Minute)
+	require.ErrorIs(t, err, models.ErrIdempotencyKeyMismatch)
+
+	// retry takes over the key and its response is replayed
+	resp, err = db.StartIdempotentRequest(ctx, u.ID, "key", "hash", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	stored := models.IdempotentResponse{Status: 200, ContentType: "application/json", Body: []byte(`{}`)}
+	require.NoError(t, db.FinishIdempotentRequest(ctx, u.ID, "key", stored))
+
+	resp, err = db.StartIdempotentRequest(ctx, u.ID, "key", "hash", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, &stored, resp)
+
+	// expired key is deleted
+	_, err = db.pool.Exec(ctx, `UPDATE idempotency_key SET expires_at = NOW() - INTERVAL '1 second' WHERE user_id = $1;`, u.ID)
+	require.NoError(t, err)
+
+	deleted, err := db.DeleteExpiredIdempotencyKeys(ctx)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, deleted, int64(1))
+
+	resp, err = db.StartIdempotentRequest(ctx, u.ID, "key", "other", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
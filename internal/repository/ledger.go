@@ -0,0 +1,337 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// postEntry appends entry to the ledger and updates balances and points lots of its user accounts.
+// Must be called inside the transaction of the operation caused the points movement
+func (db *DB) postEntry(ctx context.Context, tx pgx.Tx, e models.LedgerEntry) error {
+	var entryID int64
+	row := tx.QueryRow(
+		ctx,
+		`INSERT INTO ledger_entry (kind, from_account, to_account, amount, reference, reason)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`,
+		e.Kind,
+		e.From,
+		e.To,
+		e.Amount,
+		e.Reference,
+		e.Reason,
+	)
+	if err := row.Scan(&entryID); err != nil {
+		return fmt.Errorf("ledger entry insert error: %w", err)
+	}
+
+	// points leave user account
+	if userID, ok := models.AccountUserID(e.From); ok {
+		// reversed accrual is taken from its held lot first
+		reversal := e.Kind == models.LedgerEntryReversal && e.To == models.AccountAccrual
+		var fromHeld models.Points
+		if reversal {
+			// balance is locked before lots, like in the hold and expiry jobs
+			if _, err := lockBalance(ctx, tx, userID); err != nil {
+				return err
+			}
+			var err error
+			if fromHeld, err = consumeOrderLots(ctx, tx, userID, entryID, e.Reference, true, e.Amount); err != nil {
+				return err
+			}
+		}
+		spent := e.Amount - fromHeld
+
+		var withdrawn models.Points
+		if e.To == models.AccountWithdrawal {
+			withdrawn = e.Amount
+		}
+		if _, err := updateBalance(ctx, tx, userID, -spent, -fromHeld, withdrawn); err != nil {
+			return err
+		}
+
+		// expired lots are emptied by expiration itself
+		if e.Kind != models.LedgerEntryExpiration {
+			// reversed accrual is taken from its released lot before the others
+			rest := spent
+			if reversal {
+				taken, err := consumeOrderLots(ctx, tx, userID, entryID, e.Reference, false, rest)
+				if err != nil {
+					return err
+				}
+				rest -= taken
+			}
+			if err := consumeLots(ctx, tx, userID, entryID, rest); err != nil {
+				return err
+			}
+		}
+	}
+
+	// points come to user account
+	if userID, ok := models.AccountUserID(e.To); ok {
+		// accrued points are held before they become spendable
+		if e.Kind == models.LedgerEntryAccrual && db.holdDays > 0 {
+			if _, err := updateBalance(ctx, tx, userID, 0, e.Amount, 0); err != nil {
+				return err
+			}
+			return db.addLot(ctx, tx, userID, e.Reference, e.Amount, true)
+		}
+
+		var withdrawn models.Points
+		if e.From == models.AccountWithdrawal {
+			withdrawn = -e.Amount
+		}
+		current, err := updateBalance(ctx, tx, userID, e.Amount, 0, withdrawn)
+		if err != nil {
+			return err
+		}
+
+		// refunded points return to the lots they were taken from
+		credit := e.Amount
+		if e.From == models.AccountWithdrawal {
+			restored, err := restoreLots(ctx, tx, userID, e.Reference)
+			if err != nil {
+				return err
+			}
+			credit -= restored
+		}
+
+		// negative balance is paid off first
+		if credit > current {
+			credit = current
+		}
+
+		// transferred points keep expiration dates of the sender's lots
+		if e.Kind == models.LedgerEntryTransfer {
+			transferred, err := transferLots(ctx, tx, userID, entryID, e.Reference, credit)
+			if err != nil {
+				return err
+			}
+			credit -= transferred
+		}
+
+		if err := db.addLot(ctx, tx, userID, e.Reference, credit, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateBalance adds deltas to user's balance, returns the new current balance
+func updateBalance(ctx context.Context, tx pgx.Tx, userID string, current, pending, withdrawn models.Points) (models.Points, error) {
+	row := tx.QueryRow(
+		ctx,
+		`INSERT INTO balance (user_id, current, pending, withdrawn) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET current = balance.current + EXCLUDED.current,
+			pending = balance.pending + EXCLUDED.pending,
+			withdrawn = balance.withdrawn + EXCLUDED.withdrawn,
+			updated_at = NOW()
+		RETURNING current;`,
+		userID,
+		current,
+		pending,
+		withdrawn,
+	)
+	var newCurrent models.Points
+	if err := row.Scan(&newCurrent); err != nil {
+		return 0, fmt.Errorf("balance update error: %w", err)
+	}
+
+	return newCurrent, nil
+}
+
+// addLot credits points lot expiring after the configured period.
+// Held lot becomes spendable after the configured hold period
+func (db *DB) addLot(ctx context.Context, tx pgx.Tx, userID, reference string, amount models.Points, held bool) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO points_lot (user_id, reference, amount, remaining, expires_at, held, available_at)
+		VALUES (
+			$1, $2, $3, $3,
+			CASE WHEN $4::INT > 0 THEN NOW() + make_interval(months => $4::INT) END,
+			$5,
+			CASE WHEN $5 THEN NOW() + make_interval(days => $6::INT) ELSE NOW() END
+		);`,
+		userID,
+		reference,
+		amount,
+		db.expiryMonths,
+		held,
+		db.holdDays,
+	)
+	if err != nil {
+		return fmt.Errorf("points lot insert error: %w", err)
+	}
+
+	return nil
+}
+
+// consumeOrderLots takes points from user's held or spendable lots of the order, returns taken amount
+func consumeOrderLots(ctx context.Context, tx pgx.Tx, userID string, entryID int64, reference string, held bool, amount models.Points) (models.Points, error) {
+	lots, err := lockLots(ctx, tx, `user_id = $1 AND reference = $2 AND held = $3 AND remaining > 0`, userID, reference, held)
+	if err != nil {
+		return 0, err
+	}
+
+	return takeFromLots(ctx, tx, lots, entryID, amount)
+}
+
+// consumeLots takes points from user's spendable lots, the soonest expiring first.
+// Points above lots remaining make balance negative and aren't taken from anywhere
+func consumeLots(ctx context.Context, tx pgx.Tx, userID string, entryID int64, amount models.Points) error {
+	lots, err := lockLots(ctx, tx, `user_id = $1 AND NOT held AND remaining > 0`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = takeFromLots(ctx, tx, lots, entryID, amount)
+	return err
+}
+
+// lot points lot remaining
+type lot struct {
+	id        int64
+	remaining models.Points
+}
+
+// lockLots returns points lots matching the condition locked until the end of the transaction,
+// the soonest expiring first
+func lockLots(ctx context.Context, tx pgx.Tx, where string, args ...any) ([]lot, error) {
+	rows, err := tx.Query(
+		ctx,
+		`SELECT id, remaining FROM points_lot
+		WHERE `+where+`
+		ORDER BY expires_at ASC NULLS LAST, id ASC
+		FOR UPDATE;`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("points lots query error: %w", err)
+	}
+	defer rows.Close()
+
+	var lots []lot
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.id, &l.remaining); err != nil {
+			return nil, fmt.Errorf("row scan error: %w", err)
+		}
+		lots = append(lots, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows scan error: %w", err)
+	}
+
+	return lots, nil
+}
+
+// takeFromLots takes up to amount points from lots in order and allocates them to the ledger entry,
+// returns taken amount
+func takeFromLots(ctx context.Context, tx pgx.Tx, lots []lot, entryID int64, amount models.Points) (models.Points, error) {
+	var taken models.Points
+	for _, l := range lots {
+		if taken >= amount {
+			break
+		}
+		take := min(l.remaining, amount-taken)
+		taken += take
+
+		_, err := tx.Exec(ctx, `UPDATE points_lot SET remaining = remaining - $2 WHERE id = $1;`, l.id, take)
+		if err != nil {
+			return 0, fmt.Errorf("points lot update error: %w", err)
+		}
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO points_lot_allocation (entry_id, lot_id, amount) VALUES ($1, $2, $3);`,
+			entryID,
+			l.id,
+			take,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("points lot allocation insert error: %w", err)
+		}
+	}
+
+	return taken, nil
+}
+
+// restoreLots returns points of user's withdrawal to the lots they were taken from, returns restored amount
+func restoreLots(ctx context.Context, tx pgx.Tx, userID, reference string) (models.Points, error) {
+	row := tx.QueryRow(
+		ctx,
+		`WITH r AS (
+			UPDATE points_lot l SET remaining = l.remaining + a.amount
+			FROM points_lot_allocation a
+			JOIN ledger_entry e ON e.id = a.entry_id
+			WHERE a.lot_id = l.id AND e.kind = $1 AND e.from_account = $2 AND e.reference = $3
+			RETURNING a.amount
+		)
+		SELECT COALESCE(SUM(amount), 0)::BIGINT FROM r;`,
+		models.LedgerEntryWithdrawal,
+		models.UserAccount(userID),
+		reference,
+	)
+	var restored models.Points
+	if err := row.Scan(&restored); err != nil {
+		return 0, fmt.Errorf("points lots restore error: %w", err)
+	}
+
+	return restored, nil
+}
+
+// transferLots credits recipient with lots expiring as the sender's lots taken by the transfer entry,
+// the latest expiring first. Returns credited amount
+func transferLots(ctx context.Context, tx pgx.Tx, userID string, entryID int64, reference string, amount models.Points) (models.Points, error) {
+	if amount <= 0 {
+		return 0, nil
+	}
+
+	row := tx.QueryRow(
+		ctx,
+		`WITH a AS (
+			SELECT a.amount, l.expires_at,
+				SUM(a.amount) OVER (ORDER BY l.expires_at DESC NULLS FIRST, l.id ASC) - a.amount AS before
+			FROM points_lot_allocation a JOIN points_lot l ON l.id = a.lot_id
+			WHERE a.entry_id = $2
+		), l AS (
+			INSERT INTO points_lot (user_id, reference, amount, remaining, expires_at)
+			SELECT $1, $3, LEAST(amount, $4 - before), LEAST(amount, $4 - before), expires_at
+			FROM a WHERE before < $4
+			RETURNING amount
+		)
+		SELECT COALESCE(SUM(amount), 0)::BIGINT FROM l;`,
+		userID,
+		entryID,
+		reference,
+		amount,
+	)
+	var transferred models.Points
+	if err := row.Scan(&transferred); err != nil {
+		return 0, fmt.Errorf("points lots transfer error: %w", err)
+	}
+
+	return transferred, nil
+}
+
+// lockBalance returns user's balance locked until the end of the transaction
+func lockBalance(ctx context.Context, tx pgx.Tx, userID string) (*models.UserBalance, error) {
+	row := tx.QueryRow(ctx, `SELECT current, pending, withdrawn FROM balance WHERE user_id = $1 FOR UPDATE;`, userID)
+	ub := models.UserBalance{}
+	if err := row.Scan(&ub.Current, &ub.Pending, &ub.Withdrawn); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrUserNotExists
+		}
+		return nil, fmt.Errorf("lock balance error: %w", err)
+	}
+
+	return &ub, nil
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+)
+
+// listQuerySQL returns conditions, ORDER BY and LIMIT clauses of list query.
+// Conditions start with AND, so they follow the main WHERE clause.
+// Query args are appended to the given ones
+func listQuerySQL(q models.ListQuery, timeCol, idCol string, args []any) (string, []any) {
+	var sb strings.Builder
+
+	// filters
+	if len(q.Statuses) > 0 {
+		args = append(args, q.Statuses)
+		fmt.Fprintf(&sb, " AND status::TEXT = ANY($%d)", len(args))
+	}
+	if !q.From.IsZero() {
+		args = append(args, q.From)
+		fmt.Fprintf(&sb, " AND %s >= $%d", timeCol, len(args))
+	}
+	if !q.To.IsZero() {
+		args = append(args, q.To)
+		fmt.Fprintf(&sb, " AND %s < $%d", timeCol, len(args))
+	}
+
+	// keyset pagination
+	dir, cmp := "ASC", ">"
+	if q.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if q.After != nil {
+		args = append(args, q.After.At, q.After.ID)
+		fmt.Fprintf(&sb, " AND (%s, %s) %s ($%d, $%d)", timeCol, idCol, cmp, len(args)-1, len(args))
+	}
+
+	// sorting, IDs are compared in their column type
+	fmt.Fprintf(&sb, " ORDER BY %s %s, %s %s", timeCol, dir, idCol, dir)
+
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		fmt.Fprintf(&sb, " LIMIT $%d", len(args))
+	}
+
+	return sb.String(), args
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// loginFailureRetention time failed login attempts are kept in audit
+const loginFailureRetention = 90 * 24 * time.Hour
+
+// AttemptLogin counts attempt of the login and the IP before password is checked. The check
+// and the count are made by one UPSERT of each counter, so concurrent attempts of all replicas
+// can't pass the check together. Returns time left until they are unlocked if the attempt is rejected,
+// zero if it's allowed
+func (db *DB) AttemptLogin(ctx context.Context, login, ip string) (time.Duration, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	keys := models.LoginGuardKeys(login, ip)
+	for _, key := range keys {
+		// count attempt as failure until it succeeds, failures older than max lockout are forgotten.
+		// Locked out counter isn't updated
+		var failures int
+		row := tx.QueryRow(
+			ctx,
+			`INSERT INTO login_lock (key, failures, failed_at, locked_until) VALUES ($1, 1, NOW(), NOW())
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN login_lock.failed_at < NOW() - $2 * INTERVAL '1 millisecond'
+					THEN 1 ELSE login_lock.failures + 1 END,
+				failed_at = NOW()
+			WHERE login_lock.locked_until <= NOW()
+			RETURNING failures;`,
+			key,
+			db.loginPolicy.MaxLockout.Milliseconds(),
+		)
+		if err := row.Scan(&failures); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				// attempt is rejected, counts of other keys are rolled back
+				rollback(ctx, tx)
+				return db.loginWait(ctx, keys)
+			}
+			return 0, fmt.Errorf("login attempt count error: %w", err)
+		}
+
+		// lock out next attempts, the counter row is locked by the transaction
+		_, err := tx.Exec(
+			ctx,
+			`UPDATE login_lock SET locked_until = NOW() + $2 * INTERVAL '1 millisecond' WHERE key = $1;`,
+			key,
+			db.loginPolicy.Lockout(key, failures).Milliseconds(),
+		)
+		if err != nil {
+			return 0, fmt.Errorf("login lock update error: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit transaction error: %w", err)
+	}
+	return 0, nil
+}
+
+// FailLogin records failed attempt in audit, the attempt is already counted by AttemptLogin.
+// Returns lockout caused by the attempt
+func (db *DB) FailLogin(ctx context.Context, login, ip string) (time.Duration, error) {
+	var lockoutMs int64
+	row := db.pool.QueryRow(
+		ctx,
+		`INSERT INTO login_failure (login, ip, lockout_ms)
+		SELECT $1, $2, COALESCE(MAX(CEIL(EXTRACT(EPOCH FROM locked_until - NOW()) * 1000)), 0)::BIGINT
+		FROM login_lock WHERE key = ANY($3) AND locked_until > NOW()
+		RETURNING lockout_ms;`,
+		login,
+		ip,
+		models.LoginGuardKeys(login, ip),
+	)
+	if err := row.Scan(&lockoutMs); err != nil {
+		return 0, fmt.Errorf("login failure insert error: %w", err)
+	}
+
+	return time.Duration(lockoutMs) * time.Millisecond, nil
+}
+
+// SucceedLogin resets failures of the login and uncounts the attempt of the IP. Other failures
+// of the IP are kept, so one valid account doesn't unlock guessing passwords of others
+func (db *DB) SucceedLogin(ctx context.Context, login, ip string) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM login_lock WHERE key = $1;`, models.LoginGuardKey(login))
+	if err != nil {
+		return fmt.Errorf("login lock delete error: %w", err)
+	}
+
+	key := models.IPGuardKey(ip)
+	var failures int
+	row := tx.QueryRow(
+		ctx,
+		`UPDATE login_lock SET failures = failures - 1 WHERE key = $1 AND failures > 0 RETURNING failures;`,
+		key,
+	)
+	switch err := row.Scan(&failures); {
+	case errors.Is(err, pgx.ErrNoRows):
+		// IP has no failures
+	case err != nil:
+		return fmt.Errorf("login attempt uncount error: %w", err)
+	default:
+		// lockout caused by the attempt is lifted
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE login_lock SET locked_until = LEAST(locked_until, failed_at + $2 * INTERVAL '1 millisecond') WHERE key = $1;`,
+			key,
+			db.loginPolicy.Lockout(key, failures).Milliseconds(),
+		)
+		if err != nil {
+			return fmt.Errorf("login lock update error: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction error: %w", err)
+	}
+	return nil
+}
+
+// loginWait returns time left until the keys are unlocked
+func (db *DB) loginWait(ctx context.Context, keys []string) (time.Duration, error) {
+	var waitMs int64
+	row := db.pool.QueryRow(
+		ctx,
+		`SELECT COALESCE(MAX(CEIL(EXTRACT(EPOCH FROM locked_until - NOW()) * 1000)), 0)::BIGINT
+		FROM login_lock WHERE key = ANY($1) AND locked_until > NOW();`,
+		keys,
+	)
+	if err := row.Scan(&waitMs); err != nil {
+		return 0, fmt.Errorf("row scan error: %w", err)
+	}
+
+	return time.Duration(waitMs) * time.Millisecond, nil
+}
+
+// DeleteStaleLoginFailures deletes forgotten failures counters and old failed attempts audit,
+// returns number of deleted audit records
+func (db *DB) DeleteStaleLoginFailures(ctx context.Context) (int64, error) {
+	_, err := db.pool.Exec(
+		ctx,
+		`DELETE FROM login_lock WHERE failed_at < NOW() - $1 * INTERVAL '1 millisecond' AND locked_until < NOW();`,
+		db.loginPolicy.MaxLockout.Milliseconds(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("login locks delete error: %w", err)
+	}
+
+	tag, err := db.pool.Exec(
+		ctx,
+		`DELETE FROM login_failure WHERE created_at < NOW() - $1 * INTERVAL '1 millisecond';`,
+		loginFailureRetention.Milliseconds(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("login failures delete error: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
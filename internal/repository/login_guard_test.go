@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_LoginGuard(t *testing.T) {
+	db := newTestDB(t)
+	db.loginPolicy = models.LoginPolicy{MaxAttempts: 1, IPMaxAttempts: 10, Delay: time.Minute, MaxLockout: time.Hour}
+	ctx := context.Background()
+
+	// unique login and IP for every test run
+	base := time.Now().UnixNano() / 1000
+	login := fmt.Sprintf("guard-%d", base)
+	ip := fmt.Sprintf("ip-%d", base)
+
+	// attempt fails
+	fail := func() time.Duration {
+		wait, err := db.AttemptLogin(ctx, login, ip)
+		require.NoError(t, err)
+		require.Zero(t, wait)
+		lockout, err := db.FailLogin(ctx, login, ip)
+		require.NoError(t, err)
+		return lockout
+	}
+
+	// failures within max attempts
+	require.Zero(t, fail())
+
+	// second failure locks the login out
+	require.InDelta(t, time.Minute, fail(), float64(5*time.Second))
+	wait, err := db.AttemptLogin(ctx, login, ip)
+	require.NoError(t, err)
+	require.InDelta(t, time.Minute, wait, float64(5*time.Second))
+
+	// lockout is over, failures are still counted
+	_, err = db.pool.Exec(ctx, `UPDATE login_lock SET locked_until = NOW() WHERE key = $1;`, models.LoginGuardKey(login))
+	require.NoError(t, err)
+	require.InDelta(t, 2*time.Minute, fail(), float64(5*time.Second))
+
+	// audit of failed attempts, rejected attempt isn't counted
+	var failures int
+	row := db.pool.QueryRow(ctx, `SELECT COUNT(*) FROM login_failure WHERE login = $1 AND ip = $2;`, login, ip)
+	require.NoError(t, row.Scan(&failures))
+	require.Equal(t, 3, failures)
+	row = db.pool.QueryRow(ctx, `SELECT failures FROM login_lock WHERE key = $1;`, models.IPGuardKey(ip))
+	require.NoError(t, row.Scan(&failures))
+	require.Equal(t, 3, failures)
+
+	// success resets the login and uncounts the attempt of the IP
+	_, err = db.pool.Exec(ctx, `UPDATE login_lock SET locked_until = NOW() WHERE key = $1;`, models.LoginGuardKey(login))
+	require.NoError(t, err)
+	wait, err = db.AttemptLogin(ctx, login, ip)
+	require.NoError(t, err)
+	require.Zero(t, wait)
+	require.NoError(t, db.SucceedLogin(ctx, login, ip))
+	require.Zero(t, fail())
+
+	row = db.pool.QueryRow(ctx, `SELECT failures FROM login_lock WHERE key = $1;`, models.IPGuardKey(ip))
+	require.NoError(t, row.Scan(&failures))
+	require.Equal(t, 4, failures)
+}
+
+func TestDB_LoginGuard_Concurrent(t *testing.T) {
+	db := newTestDB(t)
+	db.loginPolicy = models.LoginPolicy{MaxAttempts: 1, IPMaxAttempts: 10, Delay: time.Minute, MaxLockout: time.Hour}
+	ctx := context.Background()
+
+	// unique login and IP for every test run
+	base := time.Now().UnixNano() / 1000
+	login := fmt.Sprintf("guard-%d", base)
+	ip := fmt.Sprintf("ip-%d", base)
+
+	// concurrent attempts pass as many as sequential ones
+	const workers = 20
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		allowed  int
+		rejected int
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			wait, err := db.AttemptLogin(ctx, login, ip)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				t.Error(err)
+			case wait == 0:
+				allowed++
+			default:
+				rejected++
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, 2, allowed)
+	require.Equal(t, workers-2, rejected)
+}
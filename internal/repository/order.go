@@ -7,18 +7,30 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/pkg/logger"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
 )
 
 // CreateOrder ...
 func (db *DB) CreateOrder(ctx context.Context, form models.OrderForm) (*models.Order, error) {
 	// @todo set accrual as 0 by default. maybe use DEFAULT on CREATE TABLE query?
+	// new order is the first record of its status history
 	row := db.pool.QueryRow(
 		ctx,
-		`INSERT INTO "order" (user_id, number, status, accrual) VALUES ($1, $2, $3, 0) RETURNING id, user_id, number, accrual, status, uploaded_at;`,
+		`WITH o AS (
+			INSERT INTO "order" (user_id, number, status, accrual) VALUES ($1, $2, $3, 0)
+			RETURNING id, user_id, number, accrual, status, uploaded_at
+		), h AS (
+			INSERT INTO order_status_history (order_id, status_to, accrual, created_at)
+			SELECT id, status, accrual, uploaded_at FROM o
+		)
+		SELECT id, user_id, number, accrual, status, uploaded_at FROM o;`,
 		form.UserID,
 		form.Number,
 		models.OrderStatusNew,
@@ -31,6 +43,10 @@ func (db *DB) CreateOrder(ctx context.Context, form models.OrderForm) (*models.O
 			if pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) && pgErr.ConstraintName == "order_number_key" {
 				return nil, models.ErrOrderAlreadyExists
 			}
+			// user is deleted
+			if pgErr.Code == pgerrcode.ForeignKeyViolation {
+				return nil, models.ErrUserNotExists
+			}
 			return nil, fmt.Errorf("order with same number is already exists: %w", err)
 		}
 		return nil, fmt.Errorf("row scan error: %w", err)
@@ -48,18 +64,23 @@ func (db *DB) GetOrder(ctx context.Context, form models.OrderForm) (*models.Orde
 	)
 	o := models.Order{}
 	if err := row.Scan(&o.ID, &o.UserID, &o.Number, &o.Status, &o.Accrual, &o.UploadedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrOrderNotExists
+		}
 		return nil, fmt.Errorf("row scan error: %w", err)
 	}
 
 	return &o, nil
 }
 
-// GetUserOrders ...
-func (db *DB) GetUserOrders(ctx context.Context, u *models.User) ([]*models.Order, error) {
+// GetUserOrders returns user's orders filtered, sorted and paginated by list query.
+// Returns ErrUserNotExists if user doesn't exist
+func (db *DB) GetUserOrders(ctx context.Context, userID string, q models.ListQuery) ([]*models.Order, error) {
+	clauses, args := listQuerySQL(q, "uploaded_at", "id", []any{userID})
 	rows, err := db.pool.Query(
 		ctx,
-		`SELECT id, number, accrual, status, uploaded_at FROM "order" WHERE user_id = $1 ORDER BY uploaded_at ASC;`,
-		u.ID,
+		`SELECT id, number, accrual, status, uploaded_at FROM "order" WHERE user_id = $1`+clauses+`;`,
+		args...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("get data from storrage error: %w", err)
@@ -78,51 +99,391 @@ func (db *DB) GetUserOrders(ctx context.Context, u *models.User) ([]*models.Orde
 		return nil, fmt.Errorf("rows scan error: %w", err)
 	}
 
+	// empty list of deleted user isn't a valid result
+	if len(orders) == 0 {
+		if err := db.checkUser(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+
 	return orders, nil
 }
 
-// GetUnprocessedOrders ...
-func (db *DB) GetUnprocessedOrders(ctx context.Context) ([]*models.Order, error) {
+// GetOrderHistory returns order status changes from the oldest to the newest
+func (db *DB) GetOrderHistory(ctx context.Context, orderID string) ([]*models.OrderStatusChange, error) {
+	rows, err := db.pool.Query(
+		ctx,
+		`SELECT order_id, COALESCE(status_from::TEXT, ''), status_to, accrual, accrual_response, created_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY created_at ASC, id ASC;`,
+		orderID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	var history []*models.OrderStatusChange
+	for rows.Next() {
+		var c models.OrderStatusChange
+		if err := rows.Scan(&c.OrderID, &c.From, &c.To, &c.Accrual, &c.AccrualResponse, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("row scan error: %w", err)
+		}
+		history = append(history, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows scan error: %w", err)
+	}
+
+	return history, nil
+}
+
+// ClaimOrders leases up to limit unprocessed orders due to be checked to the owner.
+// Orders leased by another owner are skipped until their lease expires
+func (db *DB) ClaimOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]*models.Order, error) {
 	// do query
 	rows, err := db.pool.Query(
 		ctx,
-		`SELECT id, user_id, number, accrual, status, uploaded_at FROM "order" WHERE status IN ($1, $2);`,
+		`UPDATE "order" SET locked_by = $1, locked_until = NOW() + $2 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM "order"
+			WHERE status IN ($3, $4)
+				AND next_check_at <= NOW()
+				AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY next_check_at ASC
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, number, accrual, status, uploaded_at, attempts, next_check_at, accrual_multiplier;`,
+		owner,
+		lease.Milliseconds(),
 		models.OrderStatusNew,
 		models.OrderStatusProcessing,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	return scanOrders(rows)
+}
+
+// ClaimProcessedOrders leases up to limit orders processed within the window and due to be rechecked to the owner
+func (db *DB) ClaimProcessedOrders(ctx context.Context, owner string, limit int, lease, window time.Duration) ([]*models.Order, error) {
+	// do query
+	rows, err := db.pool.Query(
+		ctx,
+		`UPDATE "order" SET locked_by = $1, locked_until = NOW() + $2 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM "order"
+			WHERE status = $3
+				AND processed_at >= NOW() - $4 * INTERVAL '1 millisecond'
+				AND next_check_at <= NOW()
+				AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY next_check_at ASC
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, number, accrual, status, uploaded_at, attempts, next_check_at, accrual_multiplier;`,
+		owner,
+		lease.Milliseconds(),
+		models.OrderStatusProcessed,
+		window.Milliseconds(),
+		limit,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("query error: %w", err)
 	}
+
+	return scanOrders(rows)
+}
+
+// scanOrders scans claimed orders rows and closes them
+func scanOrders(rows pgx.Rows) ([]*models.Order, error) {
 	defer rows.Close()
 
-	// scan rows into slice
 	var orders []*models.Order
 	for rows.Next() {
 		var o models.Order
-		if err := rows.Scan(&o.ID, &o.UserID, &o.Number, &o.Accrual, &o.Status, &o.UploadedAt); err != nil {
+		if err := rows.Scan(&o.ID, &o.UserID, &o.Number, &o.Accrual, &o.Status, &o.UploadedAt, &o.Attempts, &o.NextCheckAt, &o.Multiplier); err != nil {
 			return nil, fmt.Errorf("row scan error: %w", err)
 		}
 		orders = append(orders, &o)
 	}
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows scan error: %w", err)
 	}
 
 	return orders, nil
 }
 
-// UpdateOrder ...
-func (db *DB) UpdateOrder(ctx context.Context, order *models.Order) error {
+// ReleaseOrder releases the order lease of the owner, so the order can be claimed again at once
+func (db *DB) ReleaseOrder(ctx context.Context, owner, orderID string) error {
+	_, err := db.pool.Exec(
+		ctx,
+		`UPDATE "order" SET locked_by = NULL, locked_until = NULL WHERE id = $1 AND locked_by = $2;`,
+		orderID,
+		owner,
+	)
+	if err != nil {
+		return fmt.Errorf("order release error: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseOrders releases all order leases of the owner
+func (db *DB) ReleaseOrders(ctx context.Context, owner string) error {
 	_, err := db.pool.Exec(
 		ctx,
-		`UPDATE "order" SET accrual = $2, status = $3 WHERE id = $1;`,
+		`UPDATE "order" SET locked_by = NULL, locked_until = NULL WHERE locked_by = $1;`,
+		owner,
+	)
+	if err != nil {
+		return fmt.Errorf("orders release error: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateOrder updates order leased by the owner, records its status change to history, posts accrued points
+// to the ledger and releases the order lease. Order claimed by another instance after the lease expired
+// isn't updated
+func (db *DB) UpdateOrder(ctx context.Context, owner string, order *models.Order) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	// lock order leased by the owner and get its current status
+	var current, userID, number string
+	row := tx.QueryRow(
+		ctx,
+		`SELECT status, user_id, number::TEXT FROM "order" WHERE id = $1 AND locked_by = $2 FOR UPDATE;`,
+		order.ID,
+		owner,
+	)
+	if err := row.Scan(&current, &userID, &number); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrOrderLeaseLost
+		}
+		return fmt.Errorf("row scan error: %w", err)
+	}
+
+	// unchanged status only reschedules the next check
+	if current != order.Status && !models.IsAllowedOrderTransition(current, order.Status) {
+		return fmt.Errorf("%w: %s -> %s", models.ErrInvalidOrderTransition, current, order.Status)
+	}
+
+	tag, err := tx.Exec(
+		ctx,
+		`UPDATE "order"
+		SET accrual = $2, status = $3, attempts = $4, next_check_at = $5, locked_by = NULL, locked_until = NULL,
+			processed_at = CASE WHEN $3 = 'PROCESSED' AND status <> 'PROCESSED' THEN NOW() ELSE processed_at END,
+			accrual_multiplier = COALESCE(NULLIF($6::NUMERIC, 0), 1)
+		WHERE id = $1 AND locked_by = $7;`,
 		order.ID,
 		order.Accrual,
 		order.Status,
+		order.Attempts,
+		order.NextCheckAt,
+		order.Multiplier,
+		owner,
 	)
 	if err != nil {
 		return fmt.Errorf("order update error: %w", err)
 	}
+	if tag.RowsAffected() == 0 {
+		return models.ErrOrderLeaseLost
+	}
+
+	// record status change
+	if current != order.Status {
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO order_status_history (order_id, status_from, status_to, accrual, accrual_response)
+			VALUES ($1, $2, $3, $4, $5);`,
+			order.ID,
+			current,
+			order.Status,
+			order.Accrual,
+			[]byte(order.AccrualResponse),
+		)
+		if err != nil {
+			return fmt.Errorf("order status history insert error: %w", err)
+		}
+	}
+
+	// credit accrued points to user
+	if current != order.Status && order.Status == models.OrderStatusProcessed && order.Accrual > 0 {
+		err = db.postEntry(ctx, tx, models.LedgerEntry{
+			Kind:      models.LedgerEntryAccrual,
+			From:      models.AccountAccrual,
+			To:        models.UserAccount(userID),
+			Amount:    order.Accrual,
+			Reference: number,
+			Reason:    "order processed",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// the first processed order of referred user brings bonuses
+	if current != order.Status && order.Status == models.OrderStatusProcessed && db.referralBonus > 0 {
+		if err := db.payReferralBonus(ctx, tx, userID, number); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction error: %w", err)
+	}
+
+	return nil
+}
+
+// ReviseOrder updates processed order leased by the owner and revised by accrual service, records the revision
+// to history, posts the accrual difference to the ledger and releases the order lease.
+// Balance driven negative by reversal is flagged for review
+func (db *DB) ReviseOrder(ctx context.Context, owner string, order *models.Order) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	// lock order and get its current status and accrual
+	var (
+		current, userID, number string
+		accrual                 models.Points
+	)
+	row := tx.QueryRow(
+		ctx,
+		`SELECT status, user_id, number::TEXT, accrual FROM "order" WHERE id = $1 AND locked_by = $2 FOR UPDATE;`,
+		order.ID,
+		owner,
+	)
+	if err := row.Scan(&current, &userID, &number, &accrual); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrOrderLeaseLost
+		}
+		return fmt.Errorf("row scan error: %w", err)
+	}
+
+	// order may be revised by another instance meanwhile
+	if !models.IsAllowedOrderRevision(current, order.Status) {
+		return fmt.Errorf("%w: %s -> %s", models.ErrInvalidOrderTransition, current, order.Status)
+	}
+
+	// balance is locked before points lots are, like in the hold and expiry jobs
+	if _, err := lockBalance(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(
+		ctx,
+		`UPDATE "order"
+		SET accrual = $2, status = $3, attempts = $4, next_check_at = $5, locked_by = NULL, locked_until = NULL
+		WHERE id = $1 AND locked_by = $6;`,
+		order.ID,
+		order.Accrual,
+		order.Status,
+		order.Attempts,
+		order.NextCheckAt,
+		owner,
+	)
+	if err != nil {
+		return fmt.Errorf("order update error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return models.ErrOrderLeaseLost
+	}
+
+	// nothing is revised
+	if current == order.Status && accrual == order.Accrual {
+		if err := tx.Commit(ctx); err != nil {
+			return fmt.Errorf("commit transaction error: %w", err)
+		}
+		return nil
+	}
+
+	// record revision
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO order_status_history (order_id, status_from, status_to, accrual, accrual_response)
+		VALUES ($1, $2, $3, $4, $5);`,
+		order.ID,
+		current,
+		order.Status,
+		order.Accrual,
+		[]byte(order.AccrualResponse),
+	)
+	if err != nil {
+		return fmt.Errorf("order status history insert error: %w", err)
+	}
+
+	reason := "order accrual revised"
+	if order.Status == models.OrderStatusInvalid {
+		reason = "order invalidated"
+	}
+
+	// post accrual difference
+	if diff := order.Accrual - accrual; diff > 0 {
+		err = db.postEntry(ctx, tx, models.LedgerEntry{
+			Kind:      models.LedgerEntryAccrual,
+			From:      models.AccountAccrual,
+			To:        models.UserAccount(userID),
+			Amount:    diff,
+			Reference: number,
+			Reason:    reason,
+		})
+		if err != nil {
+			return err
+		}
+	} else if diff < 0 {
+		err = db.postEntry(ctx, tx, models.LedgerEntry{
+			Kind:      models.LedgerEntryReversal,
+			From:      models.UserAccount(userID),
+			To:        models.AccountAccrual,
+			Amount:    -diff,
+			Reference: number,
+			Reason:    reason,
+		})
+		if err != nil {
+			return err
+		}
+
+		// points may be spent already, balance is locked above
+		var balance models.Points
+		if err := tx.QueryRow(ctx, `SELECT current FROM balance WHERE user_id = $1;`, userID).Scan(&balance); err != nil {
+			return fmt.Errorf("row scan error: %w", err)
+		}
+		if balance < 0 {
+			_, err = tx.Exec(
+				ctx,
+				`INSERT INTO balance_review (user_id, reference, balance, reason) VALUES ($1, $2, $3, $4);`,
+				userID,
+				number,
+				balance,
+				"negative balance after "+reason,
+			)
+			if err != nil {
+				return fmt.Errorf("balance review insert error: %w", err)
+			}
+			logger.Warn("balance is negative after accrual reversal",
+				zap.String("user_id", userID),
+				zap.String("order", number),
+				zap.Stringer("balance", balance),
+			)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction error: %w", err)
+	}
 
 	return nil
 }
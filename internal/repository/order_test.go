@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_UpdateOrder_LeaseLost(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	u := newTestUser(t, db)
+
+	o, err := db.CreateOrder(ctx, models.OrderForm{UserID: u.ID, Number: strconv.FormatInt(base, 10)})
+	require.NoError(t, err)
+	o.Status = models.OrderStatusProcessed
+	o.Accrual = 10000
+
+	// order isn't leased
+	require.ErrorIs(t, db.UpdateOrder(ctx, testOwner, o), models.ErrOrderLeaseLost)
+
+	// order is claimed by another instance after the lease expired
+	leaseOrder(t, db, o)
+	_, err = db.pool.Exec(ctx, `UPDATE "order" SET locked_by = 'other' WHERE id = $1;`, o.ID)
+	require.NoError(t, err)
+	require.ErrorIs(t, db.UpdateOrder(ctx, testOwner, o), models.ErrOrderLeaseLost)
+
+	// nothing is credited
+	ub, err := db.GetUserBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Points(0), ub.Current)
+
+	// lease of another owner isn't released
+	require.NoError(t, db.ReleaseOrder(ctx, testOwner, o.ID))
+	var owner string
+	require.NoError(t, db.pool.QueryRow(ctx, `SELECT locked_by FROM "order" WHERE id = $1;`, o.ID).Scan(&owner))
+	require.Equal(t, "other", owner)
+}
+
+func TestDB_ReviseOrder_Reversal(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	u := newTestUser(t, db)
+
+	// user has 100 points and spends 80 of them
+	o, err := db.CreateOrder(ctx, models.OrderForm{UserID: u.ID, Number: strconv.FormatInt(base, 10)})
+	require.NoError(t, err)
+	o.Status = models.OrderStatusProcessed
+	o.Accrual = 10000
+	require.NoError(t, db.UpdateOrder(ctx, testOwner, leaseOrder(t, db, o)))
+	require.NoError(t, db.CreateWithdrawal(ctx, u.ID, strconv.FormatInt(base+1, 10), 8000))
+
+	// accrual is decreased to 50 points
+	o.Accrual = 5000
+	require.NoError(t, db.ReviseOrder(ctx, testOwner, leaseOrder(t, db, o)))
+
+	ub, err := db.GetUserBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Points(-3000), ub.Current)
+
+	// order is invalidated
+	o.Status = models.OrderStatusInvalid
+	o.Accrual = 0
+	require.NoError(t, db.ReviseOrder(ctx, testOwner, leaseOrder(t, db, o)))
+
+	ub, err = db.GetUserBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Points(-8000), ub.Current)
+
+	// negative balance blocks withdrawals
+	err = db.CreateWithdrawal(ctx, u.ID, strconv.FormatInt(base+2, 10), 100)
+	require.ErrorIs(t, err, models.ErrNotEnoughPoints)
+
+	// invalid order is final
+	o.Status = models.OrderStatusProcessed
+	require.ErrorIs(t, db.ReviseOrder(ctx, testOwner, leaseOrder(t, db, o)), models.ErrInvalidOrderTransition)
+}
+
+func TestDB_ReviseOrder_ReversalLot(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	u := newTestUser(t, db)
+
+	// two accruals of 100 points, the first lot is the first to spend
+	var orders []*models.Order
+	for i := int64(0); i < 2; i++ {
+		o, err := db.CreateOrder(ctx, models.OrderForm{UserID: u.ID, Number: strconv.FormatInt(base+i, 10)})
+		require.NoError(t, err)
+		o.Status = models.OrderStatusProcessed
+		o.Accrual = 10000
+		require.NoError(t, db.UpdateOrder(ctx, testOwner, leaseOrder(t, db, o)))
+		orders = append(orders, o)
+	}
+
+	// the second order is invalidated
+	o := orders[1]
+	o.Status = models.OrderStatusInvalid
+	o.Accrual = 0
+	require.NoError(t, db.ReviseOrder(ctx, testOwner, leaseOrder(t, db, o)))
+
+	// points are taken from the lot of the reversed order
+	remaining := map[string]models.Points{}
+	rows, err := db.pool.Query(ctx, `SELECT reference, remaining FROM points_lot WHERE user_id = $1;`, u.ID)
+	require.NoError(t, err)
+	for rows.Next() {
+		var (
+			reference string
+			points    models.Points
+		)
+		require.NoError(t, rows.Scan(&reference, &points))
+		remaining[reference] = points
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, models.Points(10000), remaining[orders[0].Number])
+	require.Equal(t, models.Points(0), remaining[orders[1].Number])
+}
+
+func TestDB_ReviseOrder_ConcurrentRelease(t *testing.T) {
+	db := newTestDB(t)
+	db.holdDays = 14
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	u := newTestUser(t, db)
+
+	// 10 accruals of 100 points are held, hold period is over
+	const workers = 10
+	var orders []*models.Order
+	for i := int64(0); i < workers; i++ {
+		o, err := db.CreateOrder(ctx, models.OrderForm{UserID: u.ID, Number: strconv.FormatInt(base+i, 10)})
+		require.NoError(t, err)
+		o.Status = models.OrderStatusProcessed
+		o.Accrual = 10000
+		require.NoError(t, db.UpdateOrder(ctx, testOwner, leaseOrder(t, db, o)))
+		orders = append(orders, o)
+	}
+	_, err := db.pool.Exec(ctx, `UPDATE points_lot SET available_at = NOW() WHERE user_id = $1;`, u.ID)
+	require.NoError(t, err)
+
+	for _, o := range orders {
+		o.Accrual = 4000
+		leaseOrder(t, db, o)
+	}
+
+	// accruals are reversed while held points are released, balance and lots are locked in the same order
+	var wg sync.WaitGroup
+	for _, o := range orders {
+		wg.Add(2)
+		go func(o *models.Order) {
+			defer wg.Done()
+			if err := db.ReviseOrder(ctx, testOwner, o); err != nil {
+				t.Error(err)
+			}
+		}(o)
+		go func() {
+			defer wg.Done()
+			if _, err := db.ReleaseHeldPoints(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, err = db.ReleaseHeldPoints(ctx)
+	require.NoError(t, err)
+
+	ub, err := db.GetUserBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Points(workers*4000), ub.Current)
+	require.Equal(t, models.Points(0), ub.Pending)
+}
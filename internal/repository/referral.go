@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// GetReferrals returns user's referral code and users registered by it
+func (db *DB) GetReferrals(ctx context.Context, userID string) (*models.Referrals, error) {
+	rs := models.Referrals{Referrals: []*models.Referral{}}
+	row := db.pool.QueryRow(ctx, `SELECT referral_code FROM "user" WHERE id = $1;`, userID)
+	if err := row.Scan(&rs.Code); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrUserNotExists
+		}
+		return nil, fmt.Errorf("row scan error: %w", err)
+	}
+
+	rows, err := db.pool.Query(
+		ctx,
+		`SELECT u.login, r.bonus, r.created_at, r.paid_at
+		FROM referral r JOIN "user" u ON u.id = r.referred_id
+		WHERE r.referrer_id = $1
+		ORDER BY r.created_at ASC;`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := models.Referral{Status: models.ReferralStatusPending}
+		if err := rows.Scan(&r.Login, &r.Bonus, &r.CreatedAt, &r.PaidAt); err != nil {
+			return nil, fmt.Errorf("row scan error: %w", err)
+		}
+		if r.PaidAt != nil {
+			r.Status = models.ReferralStatusPaid
+		}
+		rs.Referrals = append(rs.Referrals, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return &rs, nil
+}
+
+// payReferralBonus posts bonus to the referred user and the referrer
+// if it isn't paid yet. It's called when the user's order is processed
+func (db *DB) payReferralBonus(ctx context.Context, tx pgx.Tx, userID, number string) error {
+	var referrerID string
+	row := tx.QueryRow(ctx, `SELECT referrer_id FROM referral WHERE referred_id = $1 AND paid_at IS NULL FOR UPDATE;`, userID)
+	if err := row.Scan(&referrerID); err != nil {
+		// user isn't referred or bonus is already paid
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("row scan error: %w", err)
+	}
+
+	for _, id := range []string{userID, referrerID} {
+		err := db.postEntry(ctx, tx, models.LedgerEntry{
+			Kind:      models.LedgerEntryBonus,
+			From:      models.AccountReferral,
+			To:        models.UserAccount(id),
+			Amount:    db.referralBonus,
+			Reference: number,
+			Reason:    "referral bonus",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(
+		ctx,
+		`UPDATE referral SET bonus = $2, order_number = $3, paid_at = $4 WHERE referred_id = $1;`,
+		userID,
+		db.referralBonus,
+		number,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("referral update error: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ReferralBonus(t *testing.T) {
+	db := newTestDB(t)
+	db.referralBonus = 5000
+	db.maxReferrals = 1
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	referrer := newTestUser(t, db)
+
+	// unknown code
+	_, err := db.CreateUser(ctx, models.UserForm{
+		Login:    fmt.Sprintf("referred-%d", base),
+		Password: "hash",
+		Referral: "UNKNOWN",
+	})
+	require.ErrorIs(t, err, models.ErrReferralNotExists)
+
+	u, err := db.CreateUser(ctx, models.UserForm{
+		Login:    fmt.Sprintf("referred-%d", base),
+		Password: "hash",
+		Referral: referrer.ReferralCode,
+	})
+	require.NoError(t, err)
+
+	// referrer can't invite more users
+	_, err = db.CreateUser(ctx, models.UserForm{
+		Login:    fmt.Sprintf("referred-%d", base+1),
+		Password: "hash",
+		Referral: referrer.ReferralCode,
+	})
+	require.ErrorIs(t, err, models.ErrReferralLimitReached)
+
+	rs, err := db.GetReferrals(ctx, referrer.ID)
+	require.NoError(t, err)
+	require.Len(t, rs.Referrals, 1)
+	require.Equal(t, models.ReferralStatusPending, rs.Referrals[0].Status)
+
+	// two processed orders bring bonus once
+	for i := int64(0); i < 2; i++ {
+		o, err := db.CreateOrder(ctx, models.OrderForm{UserID: u.ID, Number: strconv.FormatInt(base+i, 10)})
+		require.NoError(t, err)
+		o.Status = models.OrderStatusProcessed
+		require.NoError(t, db.UpdateOrder(ctx, testOwner, leaseOrder(t, db, o)))
+	}
+
+	for _, id := range []string{referrer.ID, u.ID} {
+		ub, err := db.GetUserBalance(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, models.Points(5000), ub.Current)
+	}
+
+	rs, err = db.GetReferrals(ctx, referrer.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.ReferralStatusPaid, rs.Referrals[0].Status)
+	require.Equal(t, models.Points(5000), rs.Referrals[0].Bonus)
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// GetUserTier returns user's loyalty tier, empty tier if it isn't calculated yet.
+// Returns ErrUserNotExists if user doesn't exist
+func (db *DB) GetUserTier(ctx context.Context, userID string) (*models.UserTier, error) {
+	row := db.pool.QueryRow(ctx, `SELECT user_id, tier, accrued, updated_at FROM user_tier WHERE user_id = $1;`, userID)
+	ut := models.UserTier{UserID: userID}
+	if err := row.Scan(&ut.UserID, &ut.Tier, &ut.Accrued, &ut.UpdatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			if err := db.checkUser(ctx, userID); err != nil {
+				return nil, err
+			}
+			return &ut, nil
+		}
+		return nil, fmt.Errorf("row scan error: %w", err)
+	}
+
+	return &ut, nil
+}
+
+// RecalculateTiers sets users' loyalty tiers by points accrued within the rules period,
+// returns number of updated users
+func (db *DB) RecalculateTiers(ctx context.Context, rules models.TierRules) (int, error) {
+	// tiers stamped before the start are reset, the start is taken from the DB clock as the stamps are
+	var started time.Time
+	if err := db.pool.QueryRow(ctx, `SELECT NOW();`).Scan(&started); err != nil {
+		return 0, fmt.Errorf("get DB time error: %w", err)
+	}
+
+	// accruals minus their reversals within the period
+	rows, err := db.pool.Query(
+		ctx,
+		`SELECT user_id, SUM(amount)::BIGINT FROM (
+			SELECT substring(to_account FROM $1) AS user_id, amount FROM ledger_entry
+			WHERE kind = $2 AND from_account = $3 AND created_at >= NOW() - make_interval(months => $5::INT)
+			UNION ALL
+			SELECT substring(from_account FROM $1) AS user_id, -amount FROM ledger_entry
+			WHERE kind = $4 AND to_account = $3 AND created_at >= NOW() - make_interval(months => $5::INT)
+		) a
+		GROUP BY user_id;`,
+		len(models.UserAccount(""))+1,
+		models.LedgerEntryAccrual,
+		models.AccountAccrual,
+		models.LedgerEntryReversal,
+		rules.PeriodMonths,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("accrued points query error: %w", err)
+	}
+
+	batch := &pgx.Batch{}
+	for rows.Next() {
+		var (
+			userID  string
+			accrued models.Points
+		)
+		if err := rows.Scan(&userID, &accrued); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("row scan error: %w", err)
+		}
+
+		tier, _ := rules.ForPoints(accrued)
+		batch.Queue(
+			`INSERT INTO user_tier (user_id, tier, accrued) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id) DO UPDATE SET tier = EXCLUDED.tier, accrued = EXCLUDED.accrued, updated_at = NOW();`,
+			userID,
+			tier.Name,
+			accrued,
+		)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("rows scan error: %w", err)
+	}
+
+	// users without accruals within the period are back to the first tier
+	batch.Queue(
+		`UPDATE user_tier SET tier = $1, accrued = 0, updated_at = NOW() WHERE updated_at < $2;`,
+		rules.Tiers[0].Name,
+		started,
+	)
+
+	if err := db.pool.SendBatch(ctx, batch).Close(); err != nil {
+		return 0, fmt.Errorf("user tiers update error: %w", err)
+	}
+
+	return batch.Len() - 1, nil
+}
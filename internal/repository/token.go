@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/pkg/logger"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// CreateRefreshToken stores hash of refresh token starting a new tokens family
+func (db *DB) CreateRefreshToken(ctx context.Context, userID, tokenHash string, ttl time.Duration) error {
+	_, err := db.pool.Exec(
+		ctx,
+		`INSERT INTO refresh_token (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, GEN_RANDOM_UUID(), $2, NOW() + $3 * INTERVAL '1 millisecond');`,
+		userID,
+		tokenHash,
+		ttl.Milliseconds(),
+	)
+	if err != nil {
+		return fmt.Errorf("refresh token insert error: %w", err)
+	}
+
+	return nil
+}
+
+// RotateRefreshToken marks refresh token as used and stores its successor in the same family,
+// returns the token owner. Reuse of already rotated token revokes the whole family
+func (db *DB) RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash string, ttl time.Duration) (*models.User, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	// lock token
+	var (
+		familyID string
+		used     bool
+		valid    bool
+		u        models.User
+	)
+	row := tx.QueryRow(
+		ctx,
+		`SELECT t.family_id, t.used_at IS NOT NULL, t.revoked_at IS NULL AND t.expires_at > NOW(), u.id, u.login, `+passwordChangedAtMs+`
+		FROM refresh_token t JOIN "user" u ON u.id = t.user_id
+		WHERE t.token_hash = $1
+		FOR UPDATE OF t;`,
+		tokenHash,
+	)
+	if err := row.Scan(&familyID, &used, &valid, &u.ID, &u.Login, &u.PasswordChangedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrRefreshTokenInvalid
+		}
+		return nil, fmt.Errorf("row scan error: %w", err)
+	}
+
+	// token is stolen or the client has retried, the family isn't trusted anymore
+	if used {
+		tag, err := tx.Exec(
+			ctx,
+			`UPDATE refresh_token SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL;`,
+			familyID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("refresh tokens revoke error: %w", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("commit transaction error: %w", err)
+		}
+		if tag.RowsAffected() > 0 {
+			logger.Warn("refresh token reuse, tokens family is revoked",
+				zap.String("user_id", u.ID), zap.String("family_id", familyID))
+		}
+		return nil, models.ErrRefreshTokenReused
+	}
+	if !valid {
+		return nil, models.ErrRefreshTokenInvalid
+	}
+
+	// rotate
+	_, err = tx.Exec(ctx, `UPDATE refresh_token SET used_at = NOW() WHERE token_hash = $1;`, tokenHash)
+	if err != nil {
+		return nil, fmt.Errorf("refresh token update error: %w", err)
+	}
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO refresh_token (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 millisecond');`,
+		u.ID,
+		familyID,
+		newTokenHash,
+		ttl.Milliseconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("refresh token insert error: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction error: %w", err)
+	}
+	return &u, nil
+}
+
+// RevokeRefreshToken revokes user's tokens family of the refresh token.
+// Returns ErrUserNotExists if user doesn't exist
+func (db *DB) RevokeRefreshToken(ctx context.Context, userID, tokenHash string) error {
+	tag, err := db.pool.Exec(
+		ctx,
+		`UPDATE refresh_token SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+			AND family_id = (SELECT family_id FROM refresh_token WHERE token_hash = $2);`,
+		userID,
+		tokenHash,
+	)
+	if err != nil {
+		return fmt.Errorf("refresh token revoke error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return db.checkUser(ctx, userID)
+	}
+
+	return nil
+}
+
+// RevokeRefreshTokens revokes all user's refresh tokens. Returns ErrUserNotExists if user doesn't exist
+func (db *DB) RevokeRefreshTokens(ctx context.Context, userID string) error {
+	tag, err := db.pool.Exec(ctx, `UPDATE refresh_token SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL;`, userID)
+	if err != nil {
+		return fmt.Errorf("refresh tokens revoke error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return db.checkUser(ctx, userID)
+	}
+
+	return nil
+}
+
+// DeleteExpiredRefreshTokens deletes expired refresh tokens, they can't be used or reused anymore
+func (db *DB) DeleteExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	tag, err := db.pool.Exec(ctx, `DELETE FROM refresh_token WHERE expires_at < NOW();`)
+	if err != nil {
+		return 0, fmt.Errorf("expired refresh tokens delete error: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
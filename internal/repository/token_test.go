@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_RotateRefreshToken(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	u := newTestUser(t, db)
+
+	first := fmt.Sprintf("first-%d", base)
+	second := fmt.Sprintf("second-%d", base)
+	third := fmt.Sprintf("third-%d", base)
+	require.NoError(t, db.CreateRefreshToken(ctx, u.ID, first, time.Hour))
+
+	// rotation
+	owner, err := db.RotateRefreshToken(ctx, first, second, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, u.Login, owner.Login)
+
+	// reuse of rotated token revokes the family
+	_, err = db.RotateRefreshToken(ctx, first, third, time.Hour)
+	require.ErrorIs(t, err, models.ErrRefreshTokenReused)
+	_, err = db.RotateRefreshToken(ctx, second, third, time.Hour)
+	require.ErrorIs(t, err, models.ErrRefreshTokenInvalid)
+
+	// logout from all sessions
+	require.NoError(t, db.CreateRefreshToken(ctx, u.ID, third, time.Hour))
+	require.NoError(t, db.RevokeRefreshTokens(ctx, u.ID))
+	_, err = db.RotateRefreshToken(ctx, third, fmt.Sprintf("fourth-%d", base), time.Hour)
+	require.ErrorIs(t, err, models.ErrRefreshTokenInvalid)
+}
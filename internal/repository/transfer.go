@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateTransfer checks sender's points balance and daily limits, moves points to the recipient
+// and records the transfer atomically. Both balance rows are locked in user ID order, so concurrent
+// transfers between the same users can't deadlock
+func (db *DB) CreateTransfer(ctx context.Context, senderID, recipientLogin string, amount models.Points) (*models.Transfer, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	// find recipient
+	var recipientID string
+	row := tx.QueryRow(ctx, `SELECT id FROM "user" WHERE login = $1;`, recipientLogin)
+	if err := row.Scan(&recipientID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrRecipientNotExists
+		}
+		return nil, fmt.Errorf("row scan error: %w", err)
+	}
+	if recipientID == senderID {
+		return nil, models.ErrTransferToSelf
+	}
+
+	// lock both balances
+	ids := []string{senderID, recipientID}
+	if recipientID < senderID {
+		ids[0], ids[1] = ids[1], ids[0]
+	}
+	balances := make(map[string]*models.UserBalance, len(ids))
+	for _, id := range ids {
+		if balances[id], err = lockBalance(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	// check sender's daily limits, sender's transfers are serialized by the balance lock
+	if err := db.checkTransferLimits(ctx, tx, senderID, amount); err != nil {
+		return nil, err
+	}
+
+	// to small points balance
+	if balances[senderID].Current < amount {
+		return nil, models.ErrNotEnoughPoints
+	}
+
+	t := models.Transfer{Direction: models.TransferDirectionOut, Login: recipientLogin, Amount: amount}
+	row = tx.QueryRow(
+		ctx,
+		`INSERT INTO transfer (sender_id, recipient_id, amount) VALUES ($1, $2, $3) RETURNING id::TEXT, created_at;`,
+		senderID,
+		recipientID,
+		amount,
+	)
+	if err := row.Scan(&t.ID, &t.CreatedAt); err != nil {
+		return nil, fmt.Errorf("transfer insert error: %w", err)
+	}
+
+	// move points from sender to recipient account
+	err = db.postEntry(ctx, tx, models.LedgerEntry{
+		Kind:      models.LedgerEntryTransfer,
+		From:      models.UserAccount(senderID),
+		To:        models.UserAccount(recipientID),
+		Amount:    amount,
+		Reference: t.ID,
+		Reason:    "points transfer",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction error: %w", err)
+	}
+	return &t, nil
+}
+
+// checkTransferLimits returns error if the transfer exceeds sender's limits within the last day
+func (db *DB) checkTransferLimits(ctx context.Context, tx pgx.Tx, senderID string, amount models.Points) error {
+	if db.transferLimit <= 0 && db.transferCount <= 0 {
+		return nil
+	}
+
+	var (
+		total models.Points
+		count int
+	)
+	row := tx.QueryRow(
+		ctx,
+		`SELECT COALESCE(SUM(amount), 0)::BIGINT, COUNT(*) FROM transfer
+		WHERE sender_id = $1 AND created_at > NOW() - INTERVAL '1 day';`,
+		senderID,
+	)
+	if err := row.Scan(&total, &count); err != nil {
+		return fmt.Errorf("row scan error: %w", err)
+	}
+
+	if db.transferLimit > 0 && total+amount > db.transferLimit {
+		return models.ErrTransferLimitExceeded
+	}
+	if db.transferCount > 0 && count >= db.transferCount {
+		return models.ErrTransferLimitExceeded
+	}
+
+	return nil
+}
+
+// GetTransfers returns user's incoming and outgoing transfers filtered, sorted and paginated by list query.
+// Transfer direction is filtered as list status. Returns ErrUserNotExists if user doesn't exist
+func (db *DB) GetTransfers(ctx context.Context, userID string, q models.ListQuery) ([]*models.Transfer, error) {
+	var transfers []*models.Transfer
+	clauses, args := listQuerySQL(q, "created_at", "id", []any{userID})
+	rows, err := db.pool.Query(
+		ctx,
+		`SELECT id::TEXT, status, login, amount, created_at FROM (
+			SELECT t.id,
+				CASE WHEN t.sender_id = $1 THEN 'OUT' ELSE 'IN' END AS status,
+				u.login, t.amount, t.created_at
+			FROM transfer t
+			JOIN "user" u ON u.id = CASE WHEN t.sender_id = $1 THEN t.recipient_id ELSE t.sender_id END
+			WHERE t.sender_id = $1 OR t.recipient_id = $1
+		) t WHERE TRUE`+clauses+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get transfers error: %w", err)
+	}
+	defer rows.Close()
+
+	// scan query result
+	for rows.Next() {
+		var t models.Transfer
+		if err := rows.Scan(&t.ID, &t.Direction, &t.Login, &t.Amount, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("rows scan error: %w", err)
+		}
+
+		transfers = append(transfers, &t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows scan error: %w", err)
+	}
+
+	// empty list of deleted user isn't a valid result
+	if len(transfers) == 0 {
+		if err := db.checkUser(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return transfers, nil
+}
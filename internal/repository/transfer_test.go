@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_CreateTransfer(t *testing.T) {
+	db := newTestDB(t)
+	db.transferLimit = 15000
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	sender := newTestUser(t, db)
+	recipient := newTestUser(t, db)
+
+	// sender has 100 points
+	o, err := db.CreateOrder(ctx, models.OrderForm{UserID: sender.ID, Number: strconv.FormatInt(base, 10)})
+	require.NoError(t, err)
+	o.Status = models.OrderStatusProcessed
+	o.Accrual = 10000
+	require.NoError(t, db.UpdateOrder(ctx, testOwner, leaseOrder(t, db, o)))
+
+	_, err = db.CreateTransfer(ctx, sender.ID, sender.Login, 1000)
+	require.ErrorIs(t, err, models.ErrTransferToSelf)
+	_, err = db.CreateTransfer(ctx, sender.ID, fmt.Sprintf("unknown-%d", base), 1000)
+	require.ErrorIs(t, err, models.ErrRecipientNotExists)
+
+	// concurrent transfers in both directions, only 10 of them fit the sender's balance
+	const workers = 20
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		created  int
+		rejected int
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			from, to := sender, recipient
+			if i%2 == 1 {
+				from, to = recipient, sender
+			}
+			_, err := db.CreateTransfer(ctx, from.ID, to.Login, 1000)
+			if from == recipient {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				created++
+			case errors.Is(err, models.ErrNotEnoughPoints), errors.Is(err, models.ErrTransferLimitExceeded):
+				rejected++
+			default:
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.Equal(t, workers/2, created+rejected)
+
+	// balances are never negative and points aren't lost
+	sb, err := db.GetUserBalance(ctx, sender.ID)
+	require.NoError(t, err)
+	rb, err := db.GetUserBalance(ctx, recipient.ID)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, sb.Current, models.Points(0))
+	require.GreaterOrEqual(t, rb.Current, models.Points(0))
+	require.Equal(t, models.Points(10000), sb.Current+rb.Current)
+	require.Equal(t, sb.TransferredOut, rb.TransferredIn)
+	require.Equal(t, sb.TransferredIn, rb.TransferredOut)
+
+	// daily limit
+	_, err = db.CreateTransfer(ctx, sender.ID, recipient.Login, 15000)
+	require.ErrorIs(t, err, models.ErrTransferLimitExceeded)
+
+	ts, err := db.GetTransfers(ctx, sender.ID, models.ListQuery{Statuses: []string{models.TransferDirectionOut}})
+	require.NoError(t, err)
+	require.Len(t, ts, created)
+
+	// pages of one transfer cover the whole list in the same order
+	all, err := db.GetTransfers(ctx, sender.ID, models.ListQuery{Desc: true})
+	require.NoError(t, err)
+
+	var paged []*models.Transfer
+	q := models.ListQuery{Desc: true, Limit: 1}
+	for {
+		page, err := db.GetTransfers(ctx, sender.ID, q)
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, page...)
+		last := page[len(page)-1]
+		q.After = &models.Cursor{At: last.CreatedAt, ID: last.ID}
+	}
+	require.Equal(t, all, paged)
+}
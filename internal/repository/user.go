@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/SerjRamone/gophermart/internal/models"
 	"github.com/jackc/pgerrcode"
@@ -13,16 +14,45 @@ import (
 
 const uniqueConstraintName = "user_login_key"
 
-// CreateUser ...
+// passwordChangedAtMs user's password change date in milliseconds since epoch, zero if it has never been changed
+const passwordChangedAtMs = `COALESCE(FLOOR(EXTRACT(EPOCH FROM password_changed_at) * 1000), 0)::BIGINT`
+
+// CreateUser creates user with empty balance, user registered by referral code is linked to the referrer
 func (db *DB) CreateUser(ctx context.Context, form models.UserForm) (*models.User, error) {
-	row := db.pool.QueryRow(
+	code, err := models.NewReferralCode()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	// referrer is locked, so concurrent registrations don't exceed referrals limit
+	var referrerID string
+	if form.Referral != "" {
+		if referrerID, err = db.lockReferrer(ctx, tx, form.Referral); err != nil {
+			return nil, err
+		}
+	}
+
+	// new user starts with empty balance
+	row := tx.QueryRow(
 		ctx,
-		`INSERT INTO "user" (login, password) VALUES ($1, $2) RETURNING id, login, password;`,
+		`WITH u AS (
+			INSERT INTO "user" (login, password, referral_code) VALUES ($1, $2, $3) RETURNING id, login, password, referral_code
+		), b AS (
+			INSERT INTO balance (user_id) SELECT id FROM u
+		)
+		SELECT id, login, password, referral_code FROM u;`,
 		form.Login,
 		form.Password,
+		code,
 	)
 	u := models.User{}
-	if err := row.Scan(&u.ID, &u.Login, &u.PasswordHash); err != nil {
+	if err := row.Scan(&u.ID, &u.Login, &u.PasswordHash, &u.ReferralCode); err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
 			// check pg error for detect `duplicated login` error
@@ -34,18 +64,91 @@ func (db *DB) CreateUser(ctx context.Context, form models.UserForm) (*models.Use
 		return nil, fmt.Errorf("row scan error: %w", err)
 	}
 
+	// link to referrer
+	if referrerID != "" {
+		_, err = tx.Exec(ctx, `INSERT INTO referral (referred_id, referrer_id) VALUES ($1, $2);`, u.ID, referrerID)
+		if err != nil {
+			return nil, fmt.Errorf("referral insert error: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction error: %w", err)
+	}
+
 	return &u, nil
 }
 
+// lockReferrer returns ID of referral code owner locked until the end of the transaction
+// if the owner hasn't reached referrals limit
+func (db *DB) lockReferrer(ctx context.Context, tx pgx.Tx, code string) (string, error) {
+	var referrerID string
+	row := tx.QueryRow(ctx, `SELECT id FROM "user" WHERE referral_code = $1 FOR UPDATE;`, strings.ToUpper(code))
+	if err := row.Scan(&referrerID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", models.ErrReferralNotExists
+		}
+		return "", fmt.Errorf("row scan error: %w", err)
+	}
+
+	if db.maxReferrals > 0 {
+		var count int
+		row = tx.QueryRow(ctx, `SELECT COUNT(*) FROM referral WHERE referrer_id = $1;`, referrerID)
+		if err := row.Scan(&count); err != nil {
+			return "", fmt.Errorf("row scan error: %w", err)
+		}
+		if count >= db.maxReferrals {
+			return "", models.ErrReferralLimitReached
+		}
+	}
+
+	return referrerID, nil
+}
+
+// ChangePassword sets user's password hash and revokes all user's refresh tokens atomically.
+// Returns the change date by DB clock in milliseconds since epoch, access tokens issued before it are revoked
+func (db *DB) ChangePassword(ctx context.Context, userID, passwordHash string) (int64, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer rollback(ctx, tx)
+
+	var changedAt int64
+	row := tx.QueryRow(
+		ctx,
+		`UPDATE "user" SET password = $2, password_changed_at = NOW() WHERE id = $1 RETURNING `+passwordChangedAtMs+`;`,
+		userID,
+		passwordHash,
+	)
+	if err := row.Scan(&changedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, models.ErrUserNotExists
+		}
+		return 0, fmt.Errorf("password update error: %w", err)
+	}
+
+	// log out from all sessions
+	_, err = tx.Exec(ctx, `UPDATE refresh_token SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL;`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("refresh tokens revoke error: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit transaction error: %w", err)
+	}
+	return changedAt, nil
+}
+
 // GetUser ...
 func (db *DB) GetUser(ctx context.Context, form models.UserForm) (*models.User, error) {
 	row := db.pool.QueryRow(
 		ctx,
-		`SELECT id, login, password FROM "user" WHERE login = $1;`,
+		`SELECT id, login, password, referral_code, `+passwordChangedAtMs+` FROM "user" WHERE login = $1;`,
 		form.Login,
 	)
 	u := models.User{}
-	if err := row.Scan(&u.ID, &u.Login, &u.PasswordHash); err != nil {
+	if err := row.Scan(&u.ID, &u.Login, &u.PasswordHash, &u.ReferralCode, &u.PasswordChangedAt); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, models.ErrUserNotExists
 		}
@@ -54,3 +157,32 @@ func (db *DB) GetUser(ctx context.Context, form models.UserForm) (*models.User,
 
 	return &u, nil
 }
+
+// GetPasswordChangedAt returns date user's password was changed in milliseconds since epoch,
+// zero if it has never been changed
+func (db *DB) GetPasswordChangedAt(ctx context.Context, userID string) (int64, error) {
+	var changedAt int64
+	row := db.pool.QueryRow(ctx, `SELECT `+passwordChangedAtMs+` FROM "user" WHERE id = $1;`, userID)
+	if err := row.Scan(&changedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, models.ErrUserNotExists
+		}
+		return 0, fmt.Errorf("row scan error: %w", err)
+	}
+
+	return changedAt, nil
+}
+
+// checkUser returns ErrUserNotExists if user doesn't exist, e.g. it's deleted after the token was issued
+func (db *DB) checkUser(ctx context.Context, userID string) error {
+	var exists bool
+	row := db.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM "user" WHERE id = $1);`, userID)
+	if err := row.Scan(&exists); err != nil {
+		return fmt.Errorf("user check error: %w", err)
+	}
+	if !exists {
+		return models.ErrUserNotExists
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ChangePassword(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// unique numbers for every test run
+	base := time.Now().UnixNano() / 1000
+
+	u := newTestUser(t, db)
+
+	token := fmt.Sprintf("token-%d", base)
+	require.NoError(t, db.CreateRefreshToken(ctx, u.ID, token, time.Hour))
+
+	changedAt, err := db.GetPasswordChangedAt(ctx, u.ID)
+	require.NoError(t, err)
+	require.Zero(t, changedAt)
+
+	// password is changed, sessions are revoked
+	changedAt, err = db.ChangePassword(ctx, u.ID, "new hash")
+	require.NoError(t, err)
+	require.Positive(t, changedAt)
+	got, err := db.GetUser(ctx, models.UserForm{Login: u.Login})
+	require.NoError(t, err)
+	require.Equal(t, "new hash", got.PasswordHash)
+	require.Equal(t, changedAt, got.PasswordChangedAt)
+	stored, err := db.GetPasswordChangedAt(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, changedAt, stored)
+	_, err = db.RotateRefreshToken(ctx, token, fmt.Sprintf("next-%d", base), time.Hour)
+	require.ErrorIs(t, err, models.ErrRefreshTokenInvalid)
+
+	// unknown user
+	_, err = db.ChangePassword(ctx, "00000000-0000-0000-0000-000000000000", "hash")
+	require.ErrorIs(t, err, models.ErrUserNotExists)
+	_, err = db.GetPasswordChangedAt(ctx, "00000000-0000-0000-0000-000000000000")
+	require.ErrorIs(t, err, models.ErrUserNotExists)
+}
+
+func TestDB_DeletedUser(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// existing user without data gets empty results
+	u := newTestUser(t, db)
+
+	orders, err := db.GetUserOrders(ctx, u.ID, models.ListQuery{})
+	require.NoError(t, err)
+	require.Empty(t, orders)
+	withdrawals, err := db.GetWithdrawals(ctx, u.ID, models.ListQuery{})
+	require.NoError(t, err)
+	require.Empty(t, withdrawals)
+	transfers, err := db.GetTransfers(ctx, u.ID, models.ListQuery{})
+	require.NoError(t, err)
+	require.Empty(t, transfers)
+	ut, err := db.GetUserTier(ctx, u.ID)
+	require.NoError(t, err)
+	require.Empty(t, ut.Tier)
+	require.NoError(t, db.RevokeRefreshToken(ctx, u.ID, "unknown"))
+	require.NoError(t, db.RevokeRefreshTokens(ctx, u.ID))
+
+	// unknown user
+	unknown := "00000000-0000-0000-0000-000000000000"
+
+	_, err = db.GetUserOrders(ctx, unknown, models.ListQuery{})
+	require.ErrorIs(t, err, models.ErrUserNotExists)
+	_, err = db.GetWithdrawals(ctx, unknown, models.ListQuery{})
+	require.ErrorIs(t, err, models.ErrUserNotExists)
+	_, err = db.GetTransfers(ctx, unknown, models.ListQuery{})
+	require.ErrorIs(t, err, models.ErrUserNotExists)
+	_, err = db.GetUserTier(ctx, unknown)
+	require.ErrorIs(t, err, models.ErrUserNotExists)
+	require.ErrorIs(t, db.RevokeRefreshToken(ctx, unknown, "unknown"), models.ErrUserNotExists)
+	require.ErrorIs(t, db.RevokeRefreshTokens(ctx, unknown), models.ErrUserNotExists)
+}
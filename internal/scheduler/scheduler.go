@@ -0,0 +1,30 @@
+// Package scheduler runs periodic background jobs
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/SerjRamone/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Job is a periodic background job
+type Job func(ctx context.Context) error
+
+// Every runs job every interval until ctx is done. Job errors are logged, the next run is not cancelled
+func Every(ctx context.Context, name string, interval time.Duration, job Job) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := job(ctx); err != nil && ctx.Err() == nil {
+				logger.Error("background job error", zap.String("job", name), zap.Error(err))
+			}
+		}
+	}
+}
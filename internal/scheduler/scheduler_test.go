@@ -0,0 +1,35 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var runs atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Every(ctx, "test", time.Millisecond, func(ctx context.Context) error {
+			// errors don't stop the job
+			if runs.Add(1) == 3 {
+				cancel()
+			}
+			return errors.New("job error")
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("job is not stopped")
+	}
+	require.Equal(t, int32(3), runs.Load())
+}
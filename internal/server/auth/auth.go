@@ -0,0 +1,27 @@
+// Package auth keeps the authenticated principal in request context
+package auth
+
+import "context"
+
+// Principal is the authenticated user resolved from access token
+type Principal struct {
+	UserID string
+	Login  string
+}
+
+// contextKey request context key of principal
+type contextKey struct{}
+
+// WithPrincipal returns context with the principal inside
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal from context, false if request isn't authenticated
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(Principal)
+	if !ok || p.UserID == "" {
+		return Principal{}, false
+	}
+	return p, true
+}
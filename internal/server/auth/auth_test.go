@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromContext(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want Principal
+		ok   bool
+	}{
+		{
+			name: "Test#1. Authenticated",
+			ctx:  WithPrincipal(context.Background(), Principal{UserID: "1", Login: "user1"}),
+			want: Principal{UserID: "1", Login: "user1"},
+			ok:   true,
+		},
+		{
+			name: "Test#2. Not authenticated",
+			ctx:  context.Background(),
+		},
+		{
+			name: "Test#3. Empty user ID",
+			ctx:  WithPrincipal(context.Background(), Principal{Login: "user1"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := FromContext(tt.ctx)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.want, p)
+		})
+	}
+}
@@ -3,24 +3,31 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/internal/server/auth"
 	"github.com/SerjRamone/gophermart/pkg/logger"
 	"go.uber.org/zap"
 )
 
 // Balance is "GET /api/user/balance" handler
 func (bHandler baseHandler) Balance(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	// get user from token
-	u, err := bHandler.getUserFromToken(r)
-	if err != nil {
-		logger.Error("get user from token error", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
 		return
 	}
 
-	balance, err := bHandler.storage.GetUserBalance(ctx, u.ID)
+	balance, err := bHandler.storage.GetUserBalance(ctx, u.UserID)
 	if err != nil {
+		// user is deleted after token was issued
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
 		logger.Error("get users's balance error", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -5,38 +5,98 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/netip"
+	"strings"
+	"time"
 
 	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/internal/server/auth"
 	"github.com/SerjRamone/gophermart/internal/server/middlewares"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/SerjRamone/gophermart/internal/server/security"
+	"github.com/SerjRamone/gophermart/pkg/logger"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultIdempotencyTTL default time idempotency keys are stored
+	defaultIdempotencyTTL = 24 * time.Hour
+
+	// defaultWithdrawalCancelWindow default time withdrawal may be cancelled after creation
+	defaultWithdrawalCancelWindow = 15 * time.Minute
+
+	// defaultRefreshTokenTTL default refresh token lifetime
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
 )
 
 // baseHandler base handler with storage inside
 type baseHandler struct {
-	secret    []byte
-	tokenExpr int
-	storage   Storage
-	hasher    Hasher
+	keys           *middlewares.KeySet
+	tokenExpr      int
+	refreshTTL     time.Duration
+	storage        Storage
+	hasher         Hasher
+	idempotencyTTL time.Duration
+	cancelWindow   time.Duration
+	tiers          models.TierRules
+	guard          LoginGuard
+	proxies        []netip.Prefix
+	passwords      models.PasswordPolicy
+	passwordCache  *passwordCache
 	// ... etc
 }
 
+// Option ...
+type Option func(*baseHandler)
+
+// IdempotencyTTLOption return Option func for setting time idempotency keys are stored
+func IdempotencyTTLOption(ttl time.Duration) Option {
+	return func(h *baseHandler) {
+		if ttl > 0 {
+			h.idempotencyTTL = ttl
+		}
+	}
+}
+
 // Storage ...
 type Storage interface {
 	CreateUser(context.Context, models.UserForm) (*models.User, error)
 	GetUser(context.Context, models.UserForm) (*models.User, error)
 	CreateOrder(context.Context, models.OrderForm) (*models.Order, error)
 	GetOrder(context.Context, models.OrderForm) (*models.Order, error)
-	GetUserOrders(ctx context.Context, order *models.User) ([]*models.Order, error)
+	GetUserOrders(ctx context.Context, userID string, q models.ListQuery) ([]*models.Order, error)
+	GetOrderHistory(ctx context.Context, orderID string) ([]*models.OrderStatusChange, error)
 	GetUserBalance(ctx context.Context, userID string) (*models.UserBalance, error)
-	CreateWithdrawal(ctx context.Context, userID string, number string, total float64) error
-	GetWithdrawals(ctx context.Context, userID string) ([]*models.Withdrawal, error)
-	GetUnprocessedOrders(ctx context.Context) ([]*models.Order, error)
-	UpdateOrder(ctx context.Context, order *models.Order) error
+	GetWithdrawal(ctx context.Context, number string) (*models.Withdrawal, error)
+	CancelWithdrawal(ctx context.Context, userID, number string, window time.Duration) (*models.Withdrawal, error)
+	CreateWithdrawal(ctx context.Context, userID string, number string, total models.Points) error
+	GetWithdrawals(ctx context.Context, userID string, q models.ListQuery) ([]*models.Withdrawal, error)
+	CreateTransfer(ctx context.Context, senderID, recipientLogin string, amount models.Points) (*models.Transfer, error)
+	GetTransfers(ctx context.Context, userID string, q models.ListQuery) ([]*models.Transfer, error)
+	GetUserTier(ctx context.Context, userID string) (*models.UserTier, error)
+	GetReferrals(ctx context.Context, userID string) (*models.Referrals, error)
+	ClaimOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]*models.Order, error)
+	ClaimProcessedOrders(ctx context.Context, owner string, limit int, lease, window time.Duration) ([]*models.Order, error)
+	ReleaseOrder(ctx context.Context, owner, orderID string) error
+	ReleaseOrders(ctx context.Context, owner string) error
+	ReviseOrder(ctx context.Context, owner string, order *models.Order) error
+	StartIdempotentRequest(ctx context.Context, userID, key, requestHash string, ttl, lock time.Duration) (*models.IdempotentResponse, error)
+	FinishIdempotentRequest(ctx context.Context, userID, key string, resp models.IdempotentResponse) error
+	CancelIdempotentRequest(ctx context.Context, userID, key string) error
+	UpdateOrder(ctx context.Context, owner string, order *models.Order) error
+	CreateRefreshToken(ctx context.Context, userID, tokenHash string, ttl time.Duration) error
+	RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash string, ttl time.Duration) (*models.User, error)
+	RevokeRefreshToken(ctx context.Context, userID, tokenHash string) error
+	RevokeRefreshTokens(ctx context.Context, userID string) error
+	ChangePassword(ctx context.Context, userID, passwordHash string) (int64, error)
+	GetPasswordChangedAt(ctx context.Context, userID string) (int64, error)
 }
 
 // Hasher ...
@@ -45,14 +105,92 @@ type Hasher interface {
 	CompareHashAndPass(hash, password string) bool
 }
 
+// LoginGuard tracks failed login attempts by login and client IP.
+// Implementations must be safe for concurrent use
+type LoginGuard interface {
+	// AttemptLogin atomically checks and counts attempt of the login and the IP before password is checked.
+	// Returns time left until they are unlocked if the attempt is rejected, zero if it's allowed
+	AttemptLogin(ctx context.Context, login, ip string) (time.Duration, error)
+	// FailLogin records failed attempt, returns lockout it causes
+	FailLogin(ctx context.Context, login, ip string) (time.Duration, error)
+	// SucceedLogin resets failures of the login and uncounts the attempt
+	SucceedLogin(ctx context.Context, login, ip string) error
+}
+
+// LoginGuardOption return Option func for setting login brute-force protection
+func LoginGuardOption(guard LoginGuard) Option {
+	return func(h *baseHandler) {
+		if guard != nil {
+			h.guard = guard
+		}
+	}
+}
+
+// TrustedProxiesOption return Option func for setting proxies client IP of login attempts is taken from
+func TrustedProxiesOption(proxies []netip.Prefix) Option {
+	return func(h *baseHandler) {
+		h.proxies = proxies
+	}
+}
+
+// PasswordPolicyOption return Option func for setting password rules, default min length is used if it isn't set
+func PasswordPolicyOption(policy models.PasswordPolicy) Option {
+	return func(h *baseHandler) {
+		if policy.MinLength <= 0 {
+			policy.MinLength = models.DefaultPasswordPolicy().MinLength
+		}
+		h.passwords = policy
+	}
+}
+
+// WithdrawalCancelWindowOption return Option func for setting time withdrawal may be cancelled after creation,
+// zero window disables cancellation
+func WithdrawalCancelWindowOption(window time.Duration) Option {
+	return func(h *baseHandler) {
+		if window >= 0 {
+			h.cancelWindow = window
+		}
+	}
+}
+
+// RefreshTokenTTLOption return Option func for setting refresh token lifetime
+func RefreshTokenTTLOption(ttl time.Duration) Option {
+	return func(h *baseHandler) {
+		if ttl > 0 {
+			h.refreshTTL = ttl
+		}
+	}
+}
+
+// TierRulesOption return Option func for setting loyalty tier rules
+func TierRulesOption(rules models.TierRules) Option {
+	return func(h *baseHandler) {
+		h.tiers = rules
+	}
+}
+
 // NewBaseHandler creates new baseHandler
-func NewBaseHandler(secret []byte, tokenExpr int, storage Storage, hasher Hasher) baseHandler {
-	return baseHandler{
-		secret:    secret,
-		tokenExpr: tokenExpr,
-		storage:   storage,
-		hasher:    hasher,
+func NewBaseHandler(keys *middlewares.KeySet, tokenExpr int, storage Storage, hasher Hasher, opts ...Option) baseHandler {
+	h := baseHandler{
+		keys:           keys,
+		tokenExpr:      tokenExpr,
+		refreshTTL:     defaultRefreshTokenTTL,
+		storage:        storage,
+		hasher:         hasher,
+		idempotencyTTL: defaultIdempotencyTTL,
+		cancelWindow:   defaultWithdrawalCancelWindow,
+		tiers:          models.DefaultTierRules(),
+		guard:          security.NewLoginGuard(models.DefaultLoginPolicy()),
+		passwords:      models.DefaultPasswordPolicy(),
+		passwordCache:  newPasswordCache(defaultPasswordCacheTTL),
 	}
+
+	// apply options
+	for _, fn := range opts {
+		fn(&h)
+	}
+
+	return h
 }
 
 // getCredentials return UserFrom model from request or error
@@ -81,84 +219,105 @@ func (bHandler baseHandler) getCredentials(w http.ResponseWriter, r *http.Reques
 	return &u, nil
 }
 
-// getUserFromToken ...
-func (bHandler *baseHandler) getUserFromToken(r *http.Request) (*models.User, error) {
-	token := r.Header.Get("Authorization")
-	claims := &middlewares.Claims{}
+// validateCredentials checks login format and password policy of the new user
+func (bHandler baseHandler) validateCredentials(uf *models.UserForm) error {
+	if err := models.ValidateLogin(uf.Login); err != nil {
+		return err
+	}
+	return bHandler.passwords.Validate(uf.Login, uf.Password)
+}
 
-	keyFunc := func(t *jwt.Token) (interface{}, error) {
-		return bHandler.secret, nil
+// getUserOrder returns order by number from URL if it belongs to the user or error.
+// Unknown orders and orders of other users are both not found for the user
+func (bHandler baseHandler) getUserOrder(ctx context.Context, w http.ResponseWriter, r *http.Request, userID string) (*models.Order, error) {
+	// validate order number
+	of := models.OrderForm{Number: chi.URLParam(r, "number")}
+	if !of.IsValidNumber() {
+		w.WriteHeader(http.StatusNotFound) // 404
+		return nil, models.ErrOrderNotExists
 	}
 
-	_, err := jwt.ParseWithClaims(token, claims, keyFunc)
+	// get order from storage
+	o, err := bHandler.storage.GetOrder(ctx, of)
 	if err != nil {
-		return nil, fmt.Errorf("parsing token error: %w", err)
+		if errors.Is(err, models.ErrOrderNotExists) {
+			w.WriteHeader(http.StatusNotFound) // 404
+			return nil, err
+		}
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return nil, fmt.Errorf("order get error: %w", err)
 	}
 
-	uf := models.UserForm{
-		Login: claims.Login,
+	// order of another user
+	if o.UserID != userID {
+		w.WriteHeader(http.StatusNotFound) // 404
+		return nil, models.ErrOrderNotExists
 	}
 
-	u, err := bHandler.storage.GetUser(r.Context(), uf)
-	if err != nil {
-		return nil, fmt.Errorf("get user from token error: %w", err)
-	}
+	return o, nil
+}
 
-	return u, nil
+// etag returns strong ETag of response body
+func etag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
 }
 
-// store user in context
-// type key string
-// const userContextKey key = "userKey"
+// isETagMatched returns true if If-None-Match header value matches ETag
+func isETagMatched(ifNoneMatch, tag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
 
-// JwtMiddleware ...
+// JWTMiddleware checks access token and puts the authenticated user to request context.
+// The principal is resolved from token claims, storage is only asked for user's password change date
+// once per password cache TTL, so tokens issued before password change are revoked
 func (bHandler baseHandler) JWTMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
+		claims, err := middlewares.ParseJWT(bHandler.keys, r.Header.Get("Authorization"))
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
 
-		isAuthorized, err := isValid(token, bHandler.secret)
+		changedAt, err := bHandler.passwordChangedAt(r.Context(), claims.Subject)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			// user is deleted after token was issued
+			if errors.Is(err, models.ErrUserNotExists) {
+				w.WriteHeader(http.StatusUnauthorized) // 401
+				return
+			}
+			logger.Error("get password change date error", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError) // 500
 			return
 		}
-		if !isAuthorized {
-			w.WriteHeader(http.StatusUnauthorized)
+		if claims.RevokedBy(changedAt) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
 			return
 		}
 
 		// store user in context
-		// u, err := bHandler.getUserFromToken(r)
-		// if err != nil {
-		// 	w.WriteHeader(http.StatusUnauthorized)
-		// 	logger.Error("failed to get user from token", zap.Error(err))
-		// 	return
-		// }
-		// ctx := context.WithValue(r.Context(), userContextKey, u)
-		// next.ServeHTTP(w, r.WithContext(ctx))
-
-		next.ServeHTTP(w, r)
+		ctx := auth.WithPrincipal(r.Context(), auth.Principal{UserID: claims.Subject, Login: claims.Login})
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func isValid(token string, secret []byte) (bool, error) {
-	claims := &middlewares.Claims{}
-	// check token algo method
-	keyFunc := func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("invalid signing alg method: %v", t.Header["alg"])
-		}
-		return secret, nil
+// passwordChangedAt returns user's password change date from cache, the storage is asked if it isn't cached
+func (bHandler baseHandler) passwordChangedAt(ctx context.Context, userID string) (int64, error) {
+	if changedAt, ok := bHandler.passwordCache.get(userID); ok {
+		return changedAt, nil
 	}
 
-	// parse claims to struct
-	t, err := jwt.ParseWithClaims(token, claims, keyFunc)
+	changedAt, err := bHandler.storage.GetPasswordChangedAt(ctx, userID)
 	if err != nil {
-		return false, fmt.Errorf("token parse error: %w", err)
-	}
-
-	if !t.Valid {
-		return false, fmt.Errorf("token is not valid: %w", err)
+		return 0, err
 	}
+	bHandler.passwordCache.set(userID, changedAt)
 
-	return true, nil
+	return changedAt, nil
 }
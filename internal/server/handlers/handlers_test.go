@@ -3,30 +3,31 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/SerjRamone/gophermart/internal/models"
 	"github.com/SerjRamone/gophermart/internal/server/handlers/mocks"
+	"github.com/SerjRamone/gophermart/internal/server/middlewares"
+	"github.com/SerjRamone/gophermart/internal/server/security"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 )
 
 func Test_Login(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
-
-	mockStorage := mocks.NewMockStorage(ctrl)
-	mockHasher := mocks.NewMockHasher(ctrl)
-
-	secret := []byte("supersecret")
+	ts := newTestServer(t)
 
 	validUserForm := models.UserForm{
 		Login:    "user",
@@ -43,24 +44,10 @@ func Test_Login(t *testing.T) {
 		PasswordHash: "valid",
 	}
 
-	mockHasher.EXPECT().CompareHashAndPass(validUser.PasswordHash, validUserForm.Password).Return(true)
-
-	storageRecorder := mockStorage.EXPECT()
-	storageRecorder.GetUser(gomock.Any(), validUserForm).Return(&validUser, nil)
-	storageRecorder.GetUser(gomock.Any(), invalidUserForm).Return(nil, models.ErrUserNotExists)
-
-	bHandler := NewBaseHandler(
-		secret,
-		3600,
-		mockStorage,
-		mockHasher,
-	)
+	ts.hasher.CompareHashAndPass(validUser.PasswordHash, validUserForm.Password).Return(true)
 
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		bHandler.Login(r.Context(), w, r)
-	})
-	srv := httptest.NewServer(handler)
-	defer srv.Close()
+	ts.storage.GetUser(gomock.Any(), validUserForm).Return(&validUser, nil)
+	ts.storage.GetUser(gomock.Any(), invalidUserForm).Return(nil, models.ErrUserNotExists)
 
 	tests := []struct {
 		name     string
@@ -93,7 +80,7 @@ func Test_Login(t *testing.T) {
 
 			resp, _ := testRequest(
 				t,
-				srv,
+				ts.Server,
 				tt.method,
 				tt.url,
 				"",
@@ -109,30 +96,319 @@ func Test_Login(t *testing.T) {
 	}
 }
 
-func Test_Register(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
+func Test_LoginLockout(t *testing.T) {
+	ts := newTestServer(t,
+		LoginGuardOption(security.NewLoginGuard(models.LoginPolicy{
+			MaxAttempts:   2,
+			IPMaxAttempts: 4,
+			Delay:         time.Minute,
+			MaxLockout:    time.Hour,
+		})),
+		TrustedProxiesOption([]netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}),
+	)
 
-	mockStorage := mocks.NewMockStorage(ctrl)
-	mockHasher := mocks.NewMockHasher(ctrl)
+	user := models.User{ID: "1", Login: "user", PasswordHash: "valid"}
+
+	ts.hasher.CompareHashAndPass("valid", "valid").AnyTimes().Return(true)
+	ts.hasher.CompareHashAndPass("valid", "invalid").AnyTimes().Return(false)
+
+	// password isn't checked for locked out login
+	ts.storage.GetUser(gomock.Any(), models.UserForm{Login: "user", Password: "invalid"}).Times(3).Return(&user, nil)
+	ts.storage.GetUser(gomock.Any(), models.UserForm{Login: "other", Password: "invalid"}).Times(2).Return(nil, models.ErrUserNotExists)
+	ts.storage.GetUser(gomock.Any(), models.UserForm{Login: "another", Password: "invalid"}).Times(1).Return(nil, models.ErrUserNotExists)
+
+	// attempts are made in order, all from the same client behind the proxy
+	tests := []struct {
+		name       string
+		userForm   models.UserForm
+		clientIP   string
+		status     int
+		retryAfter string
+	}{
+		{
+			name:     "Test#1. First failure",
+			userForm: models.UserForm{Login: "user", Password: "invalid"},
+			status:   http.StatusUnauthorized,
+		},
+		{
+			name:     "Test#2. Max attempts failure",
+			userForm: models.UserForm{Login: "user", Password: "invalid"},
+			status:   http.StatusUnauthorized,
+		},
+		{
+			name:     "Test#3. Failure over max attempts locks login out",
+			userForm: models.UserForm{Login: "user", Password: "invalid"},
+			status:   http.StatusUnauthorized,
+		},
+		{
+			name:       "Test#4. Locked out login",
+			userForm:   models.UserForm{Login: "user", Password: "valid"},
+			status:     http.StatusTooManyRequests,
+			retryAfter: "60",
+		},
+		{
+			name:     "Test#5. Another login from the same IP",
+			userForm: models.UserForm{Login: "other", Password: "invalid"},
+			status:   http.StatusUnauthorized,
+		},
+		{
+			name:     "Test#6. IP failure over max attempts locks IP out",
+			userForm: models.UserForm{Login: "other", Password: "invalid"},
+			status:   http.StatusUnauthorized,
+		},
+		{
+			name:       "Test#7. Locked out IP",
+			userForm:   models.UserForm{Login: "another", Password: "invalid"},
+			status:     http.StatusTooManyRequests,
+			retryAfter: "60",
+		},
+		{
+			name:     "Test#8. Another client behind the same proxy",
+			userForm: models.UserForm{Login: "another", Password: "invalid"},
+			clientIP: "203.0.113.2",
+			status:   http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientIP := tt.clientIP
+			if clientIP == "" {
+				clientIP = "203.0.113.1"
+			}
+
+			resp := loginRequest(t, ts.Server, tt.userForm, map[string]string{"X-Forwarded-For": clientIP})
+			require.Equal(t, tt.status, resp.StatusCode)
+			require.Equal(t, tt.retryAfter, resp.Header.Get("Retry-After"))
+		})
+	}
+}
+
+func Test_clientIP(t *testing.T) {
+	h := NewBaseHandler(middlewares.NewHMACKeySet(testSecret), 3600, nil, nil,
+		TrustedProxiesOption([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}),
+	)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "Test#1. Untrusted client, its headers are ignored",
+			remoteAddr: "203.0.113.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "",
+		},
+		{
+			name:       "Test#2. Client of trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.1"},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "Test#3. Address set by client is skipped",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.1, 10.0.0.2"},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "Test#4. Real IP header",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Real-IP": "203.0.113.1"},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "Test#5. Invalid forwarded address",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "unknown"},
+			want:       "",
+		},
+		{
+			name:       "Test#6. Trusted proxy without headers",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/user/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			require.Equal(t, tt.want, h.clientIP(r))
+		})
+	}
+}
+
+func Test_JWTMiddleware(t *testing.T) {
+	ts := newTestServer(t)
+
+	// user is resolved from token, tokens issued before password change are revoked,
+	// password change date is cached
+	changedAt := time.Now().UnixMilli()
+	ts.storage.GetPasswordChangedAt(gomock.Any(), "2").AnyTimes().Return(int64(0), models.ErrUserNotExists)
+	ts.storage.GetPasswordChangedAt(gomock.Any(), "3").Times(1).Return(changedAt, nil)
+	ts.storage.GetUserBalance(gomock.Any(), "1").AnyTimes().Return(&models.UserBalance{Current: 100}, nil)
+	ts.storage.GetUserBalance(gomock.Any(), "3").AnyTimes().Return(&models.UserBalance{Current: 100}, nil)
+
+	ts.auth().Get("/api/user/balance", ts.handle(ts.handler.Balance))
+
+	signed := func(method jwt.SigningMethod, key any, claims middlewares.Claims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+	expiresAt := jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	valid, err := middlewares.GenerateJWT(ts.handler.keys, "1", "user1", 0, 3600)
+	require.NoError(t, err)
+	deleted, err := middlewares.GenerateJWT(ts.handler.keys, "2", "user2", 0, 3600)
+	require.NoError(t, err)
+	changed, err := middlewares.GenerateJWT(ts.handler.keys, "3", "user3", changedAt, 3600)
+	require.NoError(t, err)
+	issuedBefore, err := middlewares.GenerateJWT(ts.handler.keys, "3", "user3", changedAt-1, 3600)
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{
+			name:   "Test#1. No token",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "Test#2. Valid token",
+			token:  valid,
+			status: http.StatusOK,
+		},
+		{
+			name:   "Test#3. Another secret",
+			token:  signed(jwt.SigningMethodHS256, []byte("another"), middlewares.Claims{Login: "user1", RegisteredClaims: jwt.RegisteredClaims{Subject: "1", ExpiresAt: expiresAt}}),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "Test#4. Unsigned token",
+			token:  signed(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, middlewares.Claims{Login: "user1", RegisteredClaims: jwt.RegisteredClaims{Subject: "1", ExpiresAt: expiresAt}}),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "Test#5. Token without subject",
+			token:  signed(jwt.SigningMethodHS256, testSecret, middlewares.Claims{Login: "user1", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: expiresAt}}),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "Test#6. Deleted user",
+			token:  deleted,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "Test#7. Token issued after password change",
+			token:  changed,
+			status: http.StatusOK,
+		},
+		{
+			name:   "Test#8. Token issued before password change",
+			token:  issuedBefore,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "Test#9. Token without password change date after password change",
+			token:  signed(jwt.SigningMethodHS256, testSecret, middlewares.Claims{Login: "user3", RegisteredClaims: jwt.RegisteredClaims{Subject: "3", ExpiresAt: expiresAt}}),
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		resp, _ := testRequest(t,
+			ts.Server,
+			http.MethodGet,
+			"/api/user/balance",
+			tt.token,
+			nil)
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s, want: %d, have: %d", tt.name, tt.status, resp.StatusCode))
+	}
+}
+
+func Test_DeletedUser(t *testing.T) {
+	ts := newTestServer(t)
+
+	// user is deleted after token was checked
+	ts.storage.GetPasswordChangedAt(gomock.Any(), "2").AnyTimes().Return(int64(0), nil)
+	ts.storage.GetUserOrders(gomock.Any(), "2", gomock.Any()).Return(nil, models.ErrUserNotExists)
+	ts.storage.GetWithdrawals(gomock.Any(), "2", gomock.Any()).Return(nil, models.ErrUserNotExists)
+	ts.storage.GetTransfers(gomock.Any(), "2", gomock.Any()).Return(nil, models.ErrUserNotExists)
+	ts.storage.GetUserTier(gomock.Any(), "2").Return(nil, models.ErrUserNotExists)
+	ts.storage.RevokeRefreshToken(gomock.Any(), "2", models.HashRefreshToken("token")).Return(models.ErrUserNotExists)
+	ts.storage.RevokeRefreshTokens(gomock.Any(), "2").Return(models.ErrUserNotExists)
+
+	ts.auth().Get("/api/user/orders", ts.handle(ts.handler.GetOrder))
+	ts.auth().Get("/api/user/withdrawals", ts.handle(ts.handler.Withdrawals))
+	ts.auth().Get("/api/user/balance/transfers", ts.handle(ts.handler.Transfers))
+	ts.auth().Get("/api/user/tier", ts.handle(ts.handler.Tier))
+	ts.auth().Post("/api/user/logout", ts.handle(ts.handler.Logout))
+	ts.auth().Post("/api/user/logout-all", ts.handle(ts.handler.LogoutAll))
+
+	deleted, err := middlewares.GenerateJWT(ts.handler.keys, "2", "user2", 0, 3600)
+	require.NoError(t, err)
+
+	var tests = []struct {
+		method  string
+		url     string
+		request string
+	}{
+		{method: http.MethodGet, url: "/api/user/orders"},
+		{method: http.MethodGet, url: "/api/user/withdrawals"},
+		{method: http.MethodGet, url: "/api/user/balance/transfers"},
+		{method: http.MethodGet, url: "/api/user/tier"},
+		{method: http.MethodPost, url: "/api/user/logout", request: `{"refresh_token":"token"}`},
+		{method: http.MethodPost, url: "/api/user/logout-all"},
+	}
+
+	for _, tt := range tests {
+		resp, _ := testRequest(t,
+			ts.Server,
+			tt.method,
+			tt.url,
+			deleted,
+			strings.NewReader(tt.request))
 
-	secret := []byte("supersecret")
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode, fmt.Sprintf("Test: %s %s, want: %d, have: %d", tt.method, tt.url, http.StatusUnauthorized, resp.StatusCode))
+	}
+}
 
-	bHandler := NewBaseHandler(
-		secret,
-		3600,
-		mockStorage,
-		mockHasher,
+func Test_Register(t *testing.T) {
+	ts := newTestServer(t,
+		PasswordPolicyOption(models.PasswordPolicy{
+			MinLength: 8,
+			Breached:  map[string]struct{}{"password1": {}},
+		}),
 	)
 
 	userForm1 := models.UserForm{
 		Login:    "user",
-		Password: "valid",
+		Password: "valid password",
 	}
 
 	userForm2 := models.UserForm{
 		Login:    "user2",
-		Password: "valid",
+		Password: "valid password",
+	}
+
+	userForm3 := models.UserForm{
+		Login:    "user3",
+		Password: "valid password",
+		Referral: "UNKNOWN",
+	}
+
+	userForm4 := models.UserForm{
+		Login:    "user4",
+		Password: "valid password",
+		Referral: "FULL",
 	}
 
 	validUser := models.User{
@@ -140,20 +416,19 @@ func Test_Register(t *testing.T) {
 		PasswordHash: "valid",
 	}
 
-	mockHasher.EXPECT().GetHash(userForm1.Password).Return(validUser.PasswordHash, nil)
-	mockHasher.EXPECT().GetHash(userForm2.Password).Return(userForm2.Password, nil)
+	ts.hasher.GetHash(userForm1.Password).Return(userForm1.Password, nil)
+	ts.hasher.GetHash(userForm2.Password).Return(userForm2.Password, nil)
+	ts.hasher.GetHash(userForm3.Password).Return(userForm3.Password, nil)
+	ts.hasher.GetHash(userForm4.Password).Return(userForm4.Password, nil)
 
-	storageRecorder := mockStorage.EXPECT()
-	createUser := storageRecorder.CreateUser(gomock.Any(), userForm1)
+	createUser := ts.storage.CreateUser(gomock.Any(), userForm1)
 	createUser.Return(&validUser, nil)
 
-	storageRecorder.CreateUser(gomock.Any(), userForm2).After(createUser).Return(nil, models.ErrUserAlreadyExists)
+	ts.storage.CreateUser(gomock.Any(), userForm2).After(createUser).Return(nil, models.ErrUserAlreadyExists)
+	ts.storage.CreateUser(gomock.Any(), userForm3).Return(nil, models.ErrReferralNotExists)
+	ts.storage.CreateUser(gomock.Any(), userForm4).Return(nil, models.ErrReferralLimitReached)
 
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		bHandler.Register(r.Context(), w, r)
-	})
-	srv := httptest.NewServer(handler)
-	defer srv.Close()
+	ts.mux.Post("/api/user/register", ts.handle(ts.handler.Register))
 
 	tests := []struct {
 		name     string
@@ -165,17 +440,59 @@ func Test_Register(t *testing.T) {
 		{
 			name:     "Test#1. Valid user",
 			url:      "/api/user/register",
-			userForm: models.UserForm{Login: "user", Password: "valid"},
+			userForm: models.UserForm{Login: "user", Password: "valid password"},
 			method:   http.MethodPost,
 			status:   http.StatusOK,
 		},
 		{
 			name:     "Test#2. Already exists user",
 			url:      "/api/user/register",
-			userForm: models.UserForm{Login: "user2", Password: "valid"},
+			userForm: models.UserForm{Login: "user2", Password: "valid password"},
 			method:   http.MethodPost,
 			status:   http.StatusConflict,
 		},
+		{
+			name:     "Test#3. Unknown referral code",
+			url:      "/api/user/register",
+			userForm: userForm3,
+			method:   http.MethodPost,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:     "Test#4. Referrals limit is reached",
+			url:      "/api/user/register",
+			userForm: userForm4,
+			method:   http.MethodPost,
+			status:   http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Test#5. Empty password",
+			url:      "/api/user/register",
+			userForm: models.UserForm{Login: "user5"},
+			method:   http.MethodPost,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:     "Test#6. Short password",
+			url:      "/api/user/register",
+			userForm: models.UserForm{Login: "user6", Password: "short"},
+			method:   http.MethodPost,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:     "Test#7. Breached password",
+			url:      "/api/user/register",
+			userForm: models.UserForm{Login: "user7", Password: "password1"},
+			method:   http.MethodPost,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:     "Test#8. Invalid login format",
+			url:      "/api/user/register",
+			userForm: models.UserForm{Login: "us er", Password: "valid password"},
+			method:   http.MethodPost,
+			status:   http.StatusBadRequest,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -186,7 +503,7 @@ func Test_Register(t *testing.T) {
 
 			resp, _ := testRequest(
 				t,
-				srv,
+				ts.Server,
 				tt.method,
 				tt.url,
 				"",
@@ -202,21 +519,240 @@ func Test_Register(t *testing.T) {
 	}
 }
 
-func Test_AddOrder(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
+func Test_PasswordPolicyOption(t *testing.T) {
+	breached := map[string]struct{}{"password1": {}}
 
-	mockStorage := mocks.NewMockStorage(ctrl)
-	mockHasher := mocks.NewMockHasher(ctrl)
+	// breached passwords are checked with default min length
+	h := NewBaseHandler(middlewares.NewHMACKeySet(testSecret), 3600, nil, nil,
+		PasswordPolicyOption(models.PasswordPolicy{Breached: breached}),
+	)
+	require.Equal(t, models.PasswordPolicy{MinLength: models.DefaultPasswordPolicy().MinLength, Breached: breached}, h.passwords)
+	require.ErrorIs(t, h.passwords.Validate("user", "password1"), models.ErrWeakPassword)
+}
 
-	secret := []byte("supersecret")
+func Test_RefreshToken(t *testing.T) {
+	ts := newTestServer(t, RefreshTokenTTLOption(time.Hour))
 
-	bHandler := NewBaseHandler(
-		secret,
-		3600,
-		mockStorage,
-		mockHasher,
-	)
+	user1 := models.User{
+		ID:    "1",
+		Login: "user1",
+	}
+
+	ts.storage.RotateRefreshToken(gomock.Any(), models.HashRefreshToken("valid"), gomock.Any(), time.Hour).Return(&user1, nil)
+	ts.storage.RotateRefreshToken(gomock.Any(), models.HashRefreshToken("unknown"), gomock.Any(), time.Hour).
+		Return(nil, models.ErrRefreshTokenInvalid)
+	ts.storage.RotateRefreshToken(gomock.Any(), models.HashRefreshToken("reused"), gomock.Any(), time.Hour).
+		Return(nil, models.ErrRefreshTokenReused)
+
+	ts.mux.Post("/api/user/token/refresh", ts.handle(ts.handler.RefreshToken))
+
+	var tests = []struct {
+		name    string
+		request string
+		status  int
+	}{
+		{
+			name:    "Test#1. Empty token",
+			request: `{}`,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "Test#2. Valid token",
+			request: `{"refresh_token":"valid"}`,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "Test#3. Unknown token",
+			request: `{"refresh_token":"unknown"}`,
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name:    "Test#4. Reused token",
+			request: `{"refresh_token":"reused"}`,
+			status:  http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t,
+			ts.Server,
+			http.MethodPost,
+			"/api/user/token/refresh",
+			"",
+			strings.NewReader(tt.request))
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s, want: %d, have: %d", tt.name, tt.status, resp.StatusCode))
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+
+		// new pair of tokens
+		var tk tokens
+		require.NoError(t, json.Unmarshal(body, &tk))
+		require.NotEmpty(t, tk.RefreshToken)
+		require.NotEqual(t, "valid", tk.RefreshToken)
+		require.Equal(t, ts.handler.tokenExpr, tk.ExpiresIn)
+		require.Equal(t, tk.AccessToken, resp.Header.Get("Authorization"))
+
+		claims, err := middlewares.ParseJWT(ts.handler.keys, tk.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, user1.ID, claims.Subject)
+	}
+}
+
+func Test_Logout(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.storage.RevokeRefreshToken(gomock.Any(), testUser.ID, models.HashRefreshToken("token")).Return(nil)
+	ts.storage.RevokeRefreshTokens(gomock.Any(), testUser.ID).Return(nil)
+
+	ts.auth().Post("/api/user/logout", ts.handle(ts.handler.Logout))
+	ts.auth().Post("/api/user/logout-all", ts.handle(ts.handler.LogoutAll))
+
+	var tests = []struct {
+		name    string
+		url     string
+		auth    *models.UserForm
+		request string
+		status  int
+	}{
+		{
+			name:    "Test#1. Unauthorized",
+			url:     "/api/user/logout",
+			request: `{"refresh_token":"token"}`,
+			status:  http.StatusUnauthorized,
+			auth:    nil,
+		},
+		{
+			name:    "Test#2. Empty token",
+			url:     "/api/user/logout",
+			request: `{}`,
+			status:  http.StatusBadRequest,
+			auth:    &testUserForm,
+		},
+		{
+			name:    "Test#3. Logout",
+			url:     "/api/user/logout",
+			request: `{"refresh_token":"token"}`,
+			status:  http.StatusOK,
+			auth:    &testUserForm,
+		},
+		{
+			name:   "Test#4. Logout from all sessions",
+			url:    "/api/user/logout-all",
+			status: http.StatusOK,
+			auth:   &testUserForm,
+		},
+	}
+
+	for _, tt := range tests {
+		resp, _ := testRequest(t,
+			ts.Server,
+			http.MethodPost,
+			tt.url,
+			getAuthToken(t, ts.Server, tt.auth),
+			strings.NewReader(tt.request))
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s, want: %d, have: %d", tt.name, tt.status, resp.StatusCode))
+	}
+}
+
+func Test_ChangePassword(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.hasher.CompareHashAndPass("pass1", "wrong").Return(false)
+	ts.hasher.GetHash("new password").Return("new hash", nil)
+
+	ts.storage.GetUser(gomock.Any(), models.UserForm{Login: "user1"}).AnyTimes().Return(&testUser, nil)
+
+	// password is changed once
+	changedAt := time.Now().UnixMilli()
+	ts.storage.ChangePassword(gomock.Any(), testUser.ID, "new hash").Return(changedAt, nil)
+
+	ts.auth().Post("/api/user/password", ts.handle(ts.handler.ChangePassword))
+
+	var tests = []struct {
+		name    string
+		auth    *models.UserForm
+		request string
+		status  int
+	}{
+		{
+			name:    "Test#1. Unauthorized",
+			request: `{"current_password":"pass1","new_password":"new password"}`,
+			status:  http.StatusUnauthorized,
+			auth:    nil,
+		},
+		{
+			name:    "Test#2. No current password",
+			request: `{"new_password":"new password"}`,
+			status:  http.StatusBadRequest,
+			auth:    &testUserForm,
+		},
+		{
+			name:    "Test#3. Wrong current password",
+			request: `{"current_password":"wrong","new_password":"new password"}`,
+			status:  http.StatusForbidden,
+			auth:    &testUserForm,
+		},
+		{
+			name:    "Test#4. Short new password",
+			request: `{"current_password":"pass1","new_password":"short"}`,
+			status:  http.StatusBadRequest,
+			auth:    &testUserForm,
+		},
+		{
+			name:    "Test#5. New password is the current one",
+			request: `{"current_password":"pass1","new_password":"pass1"}`,
+			status:  http.StatusBadRequest,
+			auth:    &testUserForm,
+		},
+		{
+			name:    "Test#6. Password changed",
+			request: `{"current_password":"pass1","new_password":"new password"}`,
+			status:  http.StatusOK,
+			auth:    &testUserForm,
+		},
+	}
+
+	for _, tt := range tests {
+		token := getAuthToken(t, ts.Server, tt.auth)
+		resp, body := testRequest(t,
+			ts.Server,
+			http.MethodPost,
+			"/api/user/password",
+			token,
+			strings.NewReader(tt.request))
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s, want: %d, have: %d", tt.name, tt.status, resp.StatusCode))
+
+		// tokens of the new session
+		if tt.status == http.StatusOK {
+			var tk tokens
+			require.NoError(t, json.Unmarshal(body, &tk))
+			require.NotEmpty(t, tk.RefreshToken)
+			require.NotEmpty(t, resp.Header.Get("Authorization"))
+
+			// new access token isn't revoked by the change
+			claims, err := middlewares.ParseJWT(ts.handler.keys, tk.AccessToken)
+			require.NoError(t, err)
+			require.Equal(t, changedAt, claims.PasswordChangedAt)
+			require.False(t, claims.RevokedBy(changedAt))
+
+			// old access token is revoked at once
+			resp, _ = testRequest(t,
+				ts.Server,
+				http.MethodPost,
+				"/api/user/password",
+				token,
+				strings.NewReader(tt.request))
+			require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	}
+}
+
+func Test_AddOrder(t *testing.T) {
+	ts := newTestServer(t)
 
 	orderForm1 := models.OrderForm{
 		UserID: "1",
@@ -242,65 +778,27 @@ func Test_AddOrder(t *testing.T) {
 		UploadedAt: time.Now(),
 	}
 
-	userForm1 := models.UserForm{
-		Login:    "user1",
-		Password: "pass1",
-	}
-
-	userFormClaims1 := models.UserForm{
-		Login: "user1",
-	}
-
 	userForm2 := models.UserForm{
 		Login:    "user2",
 		Password: "pass2",
 	}
 
-	userFormClaims2 := models.UserForm{
-		Login: "user2",
-	}
-
-	user1 := models.User{
-		ID:           "1",
-		Login:        "user1",
-		PasswordHash: "pass1",
-	}
-
 	user2 := models.User{
 		ID:           "2",
 		Login:        "user2",
 		PasswordHash: "pass2",
 	}
 
-	mockHasher.EXPECT().CompareHashAndPass(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
-
-	storageRecorder := mockStorage.EXPECT()
-
-	storageRecorder.GetUser(gomock.Any(), userForm1).AnyTimes().Return(&user1, nil)
-	storageRecorder.GetUser(gomock.Any(), userForm2).AnyTimes().Return(&user2, nil)
-	storageRecorder.GetUser(gomock.Any(), userFormClaims1).AnyTimes().Return(&user1, nil)
-	storageRecorder.GetUser(gomock.Any(), userFormClaims2).AnyTimes().Return(&user2, nil)
+	ts.hasher.CompareHashAndPass(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
 
-	storageRecorder.CreateOrder(gomock.Any(), orderForm1).AnyTimes().Return(&order1, nil)
-	storageRecorder.CreateOrder(gomock.Any(), orderForm2).AnyTimes().Return(nil, models.ErrOrderAlreadyExists)
-	storageRecorder.CreateOrder(gomock.Any(), orderForm3).AnyTimes().Return(nil, errors.New("not found"))
+	ts.storage.GetUser(gomock.Any(), userForm2).AnyTimes().Return(&user2, nil)
+	ts.storage.GetPasswordChangedAt(gomock.Any(), user2.ID).AnyTimes().Return(int64(0), nil)
 
-	login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		bHandler.Login(r.Context(), w, r)
-	})
-	orders := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			bHandler.PostOrder(r.Context(), w, r)
-		})
-		bHandler.JWTMiddleware(handler).ServeHTTP(w, r)
-	})
-
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/user/login", login)
-	mux.HandleFunc("/api/user/orders", withMiddleware(orders, bHandler.JWTMiddleware))
+	ts.storage.CreateOrder(gomock.Any(), orderForm1).AnyTimes().Return(&order1, nil)
+	ts.storage.CreateOrder(gomock.Any(), orderForm2).AnyTimes().Return(nil, models.ErrOrderAlreadyExists)
+	ts.storage.CreateOrder(gomock.Any(), orderForm3).AnyTimes().Return(nil, errors.New("not found"))
 
-	srv := httptest.NewServer(mux)
-	defer srv.Close()
+	ts.auth().Post("/api/user/orders", ts.handle(ts.handler.PostOrder))
 
 	var tests = []struct {
 		body   any
@@ -315,7 +813,7 @@ func Test_AddOrder(t *testing.T) {
 			url:    "/api/user/orders",
 			status: http.StatusAccepted,
 			method: http.MethodPost,
-			auth:   &models.UserForm{Login: "user1", Password: "pass1"},
+			auth:   &testUserForm,
 			body:   7305748056314637,
 		},
 		{
@@ -331,7 +829,7 @@ func Test_AddOrder(t *testing.T) {
 			url:    "/api/user/orders",
 			status: http.StatusAccepted,
 			method: http.MethodPost,
-			auth:   &models.UserForm{Login: "user1", Password: "pass1"},
+			auth:   &testUserForm,
 			body:   7305748056314637,
 		},
 		{
@@ -342,6 +840,22 @@ func Test_AddOrder(t *testing.T) {
 			auth:   &models.UserForm{Login: "user2", Password: "pass2"},
 			body:   7305748056314637,
 		},
+		{
+			name:   "Test#5. Not digits order number",
+			url:    "/api/user/orders",
+			status: http.StatusUnprocessableEntity,
+			method: http.MethodPost,
+			auth:   &testUserForm,
+			body:   "abc",
+		},
+		{
+			name:   "Test#6. Quoted order number",
+			url:    "/api/user/orders",
+			status: http.StatusUnprocessableEntity,
+			method: http.MethodPost,
+			auth:   &testUserForm,
+			body:   "7305748056314637",
+		},
 	}
 
 	for _, tt := range tests {
@@ -351,10 +865,10 @@ func Test_AddOrder(t *testing.T) {
 		}
 
 		resp, _ := testRequest(t,
-			srv,
+			ts.Server,
 			tt.method,
 			tt.url,
-			getAuthToken(t, srv, tt.auth),
+			getAuthToken(t, ts.Server, tt.auth),
 			bytes.NewBuffer(b))
 
 		if err := resp.Body.Close(); err != nil {
@@ -366,209 +880,868 @@ func Test_AddOrder(t *testing.T) {
 }
 
 func Test_GetOrder(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
-
-	mockStorage := mocks.NewMockStorage(ctrl)
-	mockHasher := mocks.NewMockHasher(ctrl)
+	ts := newTestServer(t)
 
-	secret := []byte("supersecret")
+	orders := []*models.Order{
+		{
+			ID:         "1",
+			UserID:     "1",
+			Number:     "7305748056314637",
+			Status:     models.OrderStatusNew,
+			Accrual:    0,
+			UploadedAt: time.Now(),
+		},
+		{
+			ID:         "2",
+			UserID:     "1",
+			Number:     "1090888814505555",
+			Status:     models.OrderStatusNew,
+			Accrual:    0,
+			UploadedAt: time.Now(),
+		},
+	}
 
-	bHandler := NewBaseHandler(
-		secret,
-		3600,
-		mockStorage,
-		mockHasher,
+	ts.storage.GetUserOrders(gomock.Any(), testUser.ID, gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ any, _ string, q models.ListQuery) ([]*models.Order, error) {
+			if q.Limit > 0 && len(orders) > q.Limit {
+				return orders[:q.Limit], nil
+			}
+			return orders, nil
+		},
 	)
 
-	userForm1 := models.UserForm{
-		Login:    "user1",
-		Password: "pass1",
+	ts.auth().Get("/api/user/orders", ts.handle(ts.handler.GetOrder))
+
+	var tests = []struct {
+		name     string
+		url      string
+		method   string
+		auth     *models.UserForm
+		status   int
+		wantLen  int
+		wantNext bool
+	}{
+		{
+			name:   "Test#1. Unauthorized",
+			url:    "/api/user/orders",
+			status: http.StatusUnauthorized,
+			method: http.MethodGet,
+			auth:   nil,
+		},
+		{
+			name:    "Test#2. Valid",
+			url:     "/api/user/orders",
+			status:  http.StatusOK,
+			method:  http.MethodGet,
+			auth:    &testUserForm,
+			wantLen: 2,
+		},
+		{
+			name:     "Test#3. First page",
+			url:      "/api/user/orders?limit=1&status=NEW,PROCESSING&sort=desc",
+			status:   http.StatusOK,
+			method:   http.MethodGet,
+			auth:     &testUserForm,
+			wantLen:  1,
+			wantNext: true,
+		},
+		{
+			name:   "Test#4. Invalid status",
+			url:    "/api/user/orders?status=CANCELLED",
+			status: http.StatusBadRequest,
+			method: http.MethodGet,
+			auth:   &testUserForm,
+		},
+		{
+			name:   "Test#5. Invalid cursor",
+			url:    "/api/user/orders?cursor=abc",
+			status: http.StatusBadRequest,
+			method: http.MethodGet,
+			auth:   &testUserForm,
+		},
 	}
 
-	userFormClaims1 := models.UserForm{
-		Login: "user1",
+	for _, tt := range tests {
+		var b []byte
+		resp, rBytes := testRequest(t,
+			ts.Server,
+			tt.method,
+			tt.url,
+			getAuthToken(t, ts.Server, tt.auth),
+			bytes.NewBuffer(b))
+
+		if err := resp.Body.Close(); err != nil {
+			t.Error(err)
+		}
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s URL: %s, want: %d, have: %d", tt.name, tt.url, tt.status, resp.StatusCode))
+
+		if resp.StatusCode == http.StatusOK {
+			var list []*models.Order
+			if err := json.Unmarshal(rBytes, &list); err != nil {
+				t.Error(err)
+			}
+			require.Len(t, list, tt.wantLen)
+			require.Equal(t, tt.wantNext, resp.Header.Get("Link") != "")
+
+			if tt.wantNext {
+				c, err := models.DecodeCursor(resp.Header.Get("X-Next-Cursor"))
+				require.NoError(t, err)
+				require.Equal(t, list[len(list)-1].ID, c.ID)
+			}
+		}
 	}
+}
 
-	user1 := models.User{
-		ID:           "1",
-		Login:        "user1",
-		PasswordHash: "pass1",
+func Test_parseListQuery(t *testing.T) {
+	cursor := models.Cursor{At: time.Date(2020, 12, 10, 15, 15, 45, 0, time.UTC), ID: "7"}
+
+	var tests = []struct {
+		name    string
+		url     string
+		want    models.ListQuery
+		wantErr bool
+	}{
+		{
+			name: "Test#1. Unlimited list",
+			url:  "/api/user/orders",
+			want: models.ListQuery{},
+		},
+		{
+			name: "Test#2. Limited list",
+			url:  "/api/user/orders?limit=10&sort=desc",
+			want: models.ListQuery{Desc: true, Limit: 10},
+		},
+		{
+			name: "Test#3. Cursor page has default limit",
+			url:  "/api/user/orders?cursor=" + cursor.Encode(),
+			want: models.ListQuery{After: &cursor, Limit: defaultListLimit},
+		},
+		{
+			name: "Test#4. Cursor page with limit",
+			url:  "/api/user/orders?limit=5&cursor=" + cursor.Encode(),
+			want: models.ListQuery{After: &cursor, Limit: 5},
+		},
+		{
+			name:    "Test#5. Limit is too big",
+			url:     "/api/user/orders?limit=1001",
+			wantErr: true,
+		},
 	}
 
-	orders := []*models.Order{
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		q, err := parseListQuery(r, orderStatuses, false)
+		if tt.wantErr {
+			require.Error(t, err, tt.name)
+			continue
+		}
+		require.NoError(t, err, tt.name)
+		require.Equal(t, tt.want, q, tt.name)
+	}
+}
+
+func Test_Balance(t *testing.T) {
+	ts := newTestServer(t)
+
+	expiresAt := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	userBalance1 := models.UserBalance{
+		Current:        10,
+		Pending:        20,
+		Withdrawn:      50,
+		TransferredIn:  30,
+		TransferredOut: 40,
+		ExpiringSoon: []models.ExpiringPoints{
+			{Amount: 5, ExpiresAt: expiresAt},
+		},
+	}
+
+	ts.storage.GetUserBalance(gomock.Any(), testUser.ID).AnyTimes().Return(&userBalance1, nil)
+
+	ts.auth().Get("/api/user/balance", ts.handle(ts.handler.Balance))
+
+	var tests = []struct {
+		name          string
+		url           string
+		method        string
+		auth          *models.UserForm
+		status        int
+		wantCurrent   models.Points
+		wantWithdrawn models.Points
+	}{
 		{
-			ID:         "1",
-			UserID:     "1",
-			Number:     "7305748056314637",
-			Status:     models.OrderStatusNew,
-			Accrual:    0,
-			UploadedAt: time.Now(),
+			name:   "Test#1. Unauthorized",
+			url:    "/api/user/balance",
+			status: http.StatusUnauthorized,
+			method: http.MethodGet,
+			auth:   nil,
+		},
+		{
+			name:          "Test#2. Valid",
+			url:           "/api/user/balance",
+			status:        http.StatusOK,
+			method:        http.MethodGet,
+			auth:          &testUserForm,
+			wantCurrent:   10,
+			wantWithdrawn: 50,
+		},
+	}
+
+	for _, tt := range tests {
+		var b []byte
+		resp, rBytes := testRequest(t,
+			ts.Server,
+			tt.method,
+			tt.url,
+			getAuthToken(t, ts.Server, tt.auth),
+			bytes.NewBuffer(b))
+
+		if err := resp.Body.Close(); err != nil {
+			t.Error(err)
+		}
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s URL: %s, want: %d, have: %d", tt.name, tt.url, tt.status, resp.StatusCode))
+
+		if resp.StatusCode == http.StatusOK {
+			var balance models.UserBalance
+
+			if err := json.Unmarshal(rBytes, &balance); err != nil {
+				t.Error(err)
+			}
+
+			require.Equal(t, tt.wantCurrent, balance.Current,
+				fmt.Sprintf("Current balance: %s URL: %s, want: %s, have: %s",
+					tt.name, tt.url, tt.wantCurrent, balance.Current))
+
+			require.Equal(t, tt.wantWithdrawn, balance.Withdrawn,
+				fmt.Sprintf("Withdrawn balance: %s URL: %s, want: %s, have: %s",
+					tt.name, tt.url, tt.wantWithdrawn, balance.Withdrawn))
+
+			require.Equal(t, models.Points(20), balance.Pending)
+			require.Equal(t, models.Points(30), balance.TransferredIn)
+			require.Equal(t, models.Points(40), balance.TransferredOut)
+			require.Len(t, balance.ExpiringSoon, 1)
+			require.Equal(t, models.Points(5), balance.ExpiringSoon[0].Amount)
+			require.True(t, expiresAt.Equal(balance.ExpiringSoon[0].ExpiresAt))
+		}
+	}
+}
+
+func Test_BalanceWithdrawn(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.storage.CreateWithdrawal(gomock.Any(), testUser.ID, "7305748056314637", models.Points(10010)).AnyTimes().Return(nil)
+	ts.storage.CreateWithdrawal(gomock.Any(), testUser.ID, "1090888814505555", models.Points(20020)).AnyTimes().Return(models.ErrNotEnoughPoints)
+	ts.storage.CreateWithdrawal(gomock.Any(), testUser.ID, "4561261212345467", gomock.Any()).AnyTimes().Return(models.ErrWithdrawalAlreadyExists)
+	ts.storage.CreateWithdrawal(gomock.Any(), testUser.ID, "79927398713", gomock.Any()).AnyTimes().Return(models.ErrWithdrawalAlreadyExists)
+	ts.storage.GetWithdrawal(gomock.Any(), "4561261212345467").AnyTimes().
+		Return(&models.Withdrawal{UserID: testUser.ID, OrderNumber: "4561261212345467", Total: 10010}, nil)
+	ts.storage.GetWithdrawal(gomock.Any(), "79927398713").AnyTimes().
+		Return(&models.Withdrawal{UserID: "2", OrderNumber: "79927398713", Total: 5000}, nil)
+
+	ts.auth().Post("/api/user/balance/withdraw", ts.handle(ts.handler.Withdraw))
+
+	var tests = []struct {
+		name   string
+		url    string
+		method string
+		auth   *models.UserForm
+		status int
+		order  string
+		sum    float64
+		body   string
+	}{
+		{
+			name:   "Test#1. Unauthorized",
+			url:    "/api/user/balance/withdraw",
+			status: http.StatusUnauthorized,
+			method: http.MethodPost,
+			auth:   nil,
+		},
+		{
+			name:   "Test#2. Valid withdraw",
+			url:    "/api/user/balance/withdraw",
+			status: http.StatusOK,
+			method: http.MethodPost,
+			auth:   &testUserForm,
+			order:  "7305748056314637",
+			sum:    100.100,
+		},
+		{
+			name:   "Test#3. Not enough points",
+			url:    "/api/user/balance/withdraw",
+			status: http.StatusPaymentRequired,
+			method: http.MethodPost,
+			auth:   &testUserForm,
+			order:  "1090888814505555",
+			sum:    200.200,
+		},
+		{
+			name:   "Test#4. Own withdrawal already exists",
+			url:    "/api/user/balance/withdraw",
+			status: http.StatusConflict,
+			method: http.MethodPost,
+			auth:   &testUserForm,
+			order:  "4561261212345467",
+			sum:    100.100,
+			body:   `{"error":"withdrawal is already exists","own":true,"sum":100.1}`,
+		},
+		{
+			name:   "Test#5. Another user's withdrawal already exists",
+			url:    "/api/user/balance/withdraw",
+			status: http.StatusConflict,
+			method: http.MethodPost,
+			auth:   &testUserForm,
+			order:  "79927398713",
+			sum:    100.100,
+			body:   `{"error":"withdrawal is already exists","own":false}`,
+		},
+	}
+
+	for _, tt := range tests {
+		withdraw := struct {
+			Order string  `json:"order"`
+			Sum   float64 `json:"sum"`
+		}{
+			Order: tt.order,
+			Sum:   tt.sum,
+		}
+		b, err := json.Marshal(withdraw)
+		if err != nil {
+			t.Error(err)
+		}
+		resp, body := testRequest(t,
+			ts.Server,
+			tt.method,
+			tt.url,
+			getAuthToken(t, ts.Server, tt.auth),
+			bytes.NewBuffer(b))
+
+		if err := resp.Body.Close(); err != nil {
+			t.Error(err)
+		}
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s URL: %s, want: %d, have: %d", tt.name, tt.url, tt.status, resp.StatusCode))
+		if tt.body != "" {
+			require.JSONEq(t, tt.body, string(body), tt.name)
+		}
+	}
+}
+
+func Test_Withdrawals(t *testing.T) {
+	ts := newTestServer(t)
+
+	withdrawals := []*models.Withdrawal{
+		{
+			OrderNumber: "8885901057661813",
+			Total:       10,
+			Status:      models.WithdrawalStatusPending,
+			CreatedAt:   time.Now(),
+		},
+		{
+			OrderNumber: "1154576128108785",
+			Total:       10010,
+			Status:      models.WithdrawalStatusCompleted,
+			CreatedAt:   time.Now(),
+		},
+		{
+			OrderNumber: "7956829830887973",
+			Total:       11122,
+			Status:      models.WithdrawalStatusCancelled,
+			CreatedAt:   time.Now(),
+		},
+	}
+
+	ts.storage.GetWithdrawals(gomock.Any(), testUser.ID, gomock.Any()).AnyTimes().Return(withdrawals, nil)
+
+	ts.auth().Get("/api/user/withdrawals", ts.handle(ts.handler.Withdrawals))
+
+	var tests = []struct {
+		name   string
+		url    string
+		method string
+		auth   *models.UserForm
+		status int
+	}{
+		{
+			name:   "Test#1. Unauthorized",
+			url:    "/api/user/withdrawals",
+			status: http.StatusUnauthorized,
+			method: http.MethodGet,
+			auth:   nil,
+		},
+		{
+			name:   "Test#2. Valid",
+			url:    "/api/user/withdrawals",
+			status: http.StatusOK,
+			method: http.MethodGet,
+			auth:   &testUserForm,
+		},
+		{
+			name:   "Test#3. Status filter",
+			url:    "/api/user/withdrawals?status=cancelled,pending",
+			status: http.StatusOK,
+			method: http.MethodGet,
+			auth:   &testUserForm,
+		},
+		{
+			name:   "Test#4. Invalid status filter",
+			url:    "/api/user/withdrawals?status=NEW",
+			status: http.StatusBadRequest,
+			method: http.MethodGet,
+			auth:   &testUserForm,
+		},
+	}
+
+	for _, tt := range tests {
+		var b []byte
+		resp, _ := testRequest(t,
+			ts.Server,
+			tt.method,
+			tt.url,
+			getAuthToken(t, ts.Server, tt.auth),
+			bytes.NewBuffer(b))
+
+		if err := resp.Body.Close(); err != nil {
+			t.Error(err)
+		}
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s URL: %s, want: %d, have: %d", tt.name, tt.url, tt.status, resp.StatusCode))
+	}
+}
+
+func Test_CancelWithdrawal(t *testing.T) {
+	ts := newTestServer(t, WithdrawalCancelWindowOption(time.Hour))
+
+	cancelledAt := time.Now()
+
+	ts.storage.CancelWithdrawal(gomock.Any(), testUser.ID, "8885901057661813", time.Hour).
+		Return(&models.Withdrawal{
+			OrderNumber: "8885901057661813",
+			Total:       10010,
+			Status:      models.WithdrawalStatusCancelled,
+			CreatedAt:   cancelledAt.Add(-time.Minute),
+			CancelledAt: &cancelledAt,
+		}, nil)
+	ts.storage.CancelWithdrawal(gomock.Any(), testUser.ID, "1154576128108785", time.Hour).
+		Return(nil, models.ErrWithdrawalNotCancellable)
+	ts.storage.CancelWithdrawal(gomock.Any(), testUser.ID, "7956829830887973", time.Hour).
+		Return(nil, models.ErrWithdrawalNotExists)
+
+	ts.auth().Post("/api/user/withdrawals/{order}/cancel", ts.handle(ts.handler.CancelWithdrawal))
+
+	var tests = []struct {
+		name   string
+		url    string
+		auth   *models.UserForm
+		status int
+	}{
+		{
+			name:   "Test#1. Unauthorized",
+			url:    "/api/user/withdrawals/8885901057661813/cancel",
+			status: http.StatusUnauthorized,
+			auth:   nil,
+		},
+		{
+			name:   "Test#2. Cancelled",
+			url:    "/api/user/withdrawals/8885901057661813/cancel",
+			status: http.StatusOK,
+			auth:   &testUserForm,
+		},
+		{
+			name:   "Test#3. Completed withdrawal",
+			url:    "/api/user/withdrawals/1154576128108785/cancel",
+			status: http.StatusConflict,
+			auth:   &testUserForm,
+		},
+		{
+			name:   "Test#4. Unknown withdrawal",
+			url:    "/api/user/withdrawals/7956829830887973/cancel",
+			status: http.StatusNotFound,
+			auth:   &testUserForm,
+		},
+		{
+			name:   "Test#5. Invalid order number",
+			url:    "/api/user/withdrawals/12345/cancel",
+			status: http.StatusNotFound,
+			auth:   &testUserForm,
+		},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t,
+			ts.Server,
+			http.MethodPost,
+			tt.url,
+			getAuthToken(t, ts.Server, tt.auth),
+			nil)
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s URL: %s, want: %d, have: %d", tt.name, tt.url, tt.status, resp.StatusCode))
+
+		if tt.status == http.StatusOK {
+			var wd models.Withdrawal
+			require.NoError(t, json.Unmarshal(body, &wd))
+			require.Equal(t, models.WithdrawalStatusCancelled, wd.Status)
+			require.NotNil(t, wd.CancelledAt)
+		}
+	}
+}
+
+func Test_Transfer(t *testing.T) {
+	ts := newTestServer(t)
+
+	createdAt, _ := time.Parse(time.RFC3339, "2020-12-10T15:15:45+03:00")
+
+	ts.storage.CreateTransfer(gomock.Any(), testUser.ID, "user2", models.Points(1050)).
+		Return(&models.Transfer{ID: "1", Direction: models.TransferDirectionOut, Login: "user2", Amount: 1050, CreatedAt: createdAt}, nil)
+	ts.storage.CreateTransfer(gomock.Any(), testUser.ID, "unknown", gomock.Any()).Return(nil, models.ErrRecipientNotExists)
+	ts.storage.CreateTransfer(gomock.Any(), testUser.ID, "user1", gomock.Any()).Return(nil, models.ErrTransferToSelf)
+	ts.storage.CreateTransfer(gomock.Any(), testUser.ID, "user2", models.Points(100000)).Return(nil, models.ErrNotEnoughPoints)
+	ts.storage.CreateTransfer(gomock.Any(), testUser.ID, "user2", models.Points(50000)).Return(nil, models.ErrTransferLimitExceeded)
+
+	ts.auth().Post("/api/user/balance/transfer", ts.handle(ts.handler.Transfer))
+
+	var tests = []struct {
+		name    string
+		auth    *models.UserForm
+		request string
+		status  int
+		body    string
+	}{
+		{
+			name:    "Test#1. Unauthorized",
+			request: `{"login":"user2","amount":10.5}`,
+			status:  http.StatusUnauthorized,
+			auth:    nil,
+		},
+		{
+			name:    "Test#2. Valid transfer",
+			request: `{"login":"user2","amount":10.5}`,
+			status:  http.StatusOK,
+			auth:    &testUserForm,
+			body:    `{"direction":"OUT","login":"user2","amount":10.5,"created_at":"2020-12-10T15:15:45+03:00"}`,
+		},
+		{
+			name:    "Test#3. Bad request",
+			request: `{"amount":10.5}`,
+			status:  http.StatusBadRequest,
+			auth:    &testUserForm,
+		},
+		{
+			name:    "Test#4. Invalid amount",
+			request: `{"login":"user2","amount":-1}`,
+			status:  http.StatusUnprocessableEntity,
+			auth:    &testUserForm,
+		},
+		{
+			name:    "Test#5. Unknown recipient",
+			request: `{"login":"unknown","amount":1}`,
+			status:  http.StatusNotFound,
+			auth:    &testUserForm,
+		},
+		{
+			name:    "Test#6. Transfer to yourself",
+			request: `{"login":"user1","amount":1}`,
+			status:  http.StatusBadRequest,
+			auth:    &testUserForm,
+		},
+		{
+			name:    "Test#7. Not enough points",
+			request: `{"login":"user2","amount":1000}`,
+			status:  http.StatusPaymentRequired,
+			auth:    &testUserForm,
+		},
+		{
+			name:    "Test#8. Daily limit is exceeded",
+			request: `{"login":"user2","amount":500}`,
+			status:  http.StatusUnprocessableEntity,
+			auth:    &testUserForm,
+		},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t,
+			ts.Server,
+			http.MethodPost,
+			"/api/user/balance/transfer",
+			getAuthToken(t, ts.Server, tt.auth),
+			strings.NewReader(tt.request))
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s, want: %d, have: %d", tt.name, tt.status, resp.StatusCode))
+		if tt.body != "" {
+			require.JSONEq(t, tt.body, string(body), tt.name)
+		}
+	}
+}
+
+func Test_Transfers(t *testing.T) {
+	ts := newTestServer(t)
+
+	createdAt, _ := time.Parse(time.RFC3339, "2020-12-10T15:15:45+03:00")
+
+	ts.storage.GetTransfers(gomock.Any(), testUser.ID, models.ListQuery{Desc: true}).
+		Return([]*models.Transfer{
+			{ID: "2", Direction: models.TransferDirectionIn, Login: "user3", Amount: 500, CreatedAt: createdAt},
+			{ID: "1", Direction: models.TransferDirectionOut, Login: "user2", Amount: 1050, CreatedAt: createdAt},
+		}, nil)
+	ts.storage.GetTransfers(gomock.Any(), testUser.ID, models.ListQuery{
+		Statuses: []string{models.TransferDirectionIn},
+		Desc:     true,
+	}).Return(nil, nil)
+
+	ts.auth().Get("/api/user/balance/transfers", ts.handle(ts.handler.Transfers))
+
+	var tests = []struct {
+		name   string
+		url    string
+		auth   *models.UserForm
+		status int
+		body   string
+	}{
+		{
+			name:   "Test#1. Unauthorized",
+			url:    "/api/user/balance/transfers",
+			status: http.StatusUnauthorized,
+			auth:   nil,
+		},
+		{
+			name:   "Test#2. Incoming and outgoing transfers",
+			url:    "/api/user/balance/transfers",
+			status: http.StatusOK,
+			auth:   &testUserForm,
+			body: `[{"direction":"IN","login":"user3","amount":5,"created_at":"2020-12-10T15:15:45+03:00"},` +
+				`{"direction":"OUT","login":"user2","amount":10.5,"created_at":"2020-12-10T15:15:45+03:00"}]`,
+		},
+		{
+			name:   "Test#3. No incoming transfers",
+			url:    "/api/user/balance/transfers?status=in",
+			status: http.StatusNoContent,
+			auth:   &testUserForm,
+		},
+		{
+			name:   "Test#4. Invalid direction",
+			url:    "/api/user/balance/transfers?status=sideways",
+			status: http.StatusBadRequest,
+			auth:   &testUserForm,
+		},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t,
+			ts.Server,
+			http.MethodGet,
+			tt.url,
+			getAuthToken(t, ts.Server, tt.auth),
+			nil)
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s, want: %d, have: %d", tt.name, tt.status, resp.StatusCode))
+		if tt.body != "" {
+			require.JSONEq(t, tt.body, string(body), tt.name)
+		}
+	}
+}
+
+func Test_Tier(t *testing.T) {
+	ts := newTestServer(t,
+		TierRulesOption(models.TierRules{
+			PeriodMonths: 12,
+			Tiers: []models.Tier{
+				{Name: "Basic", Threshold: 0, Multiplier: 1},
+				{Name: "Silver", Threshold: 100000, Multiplier: 1.05},
+			},
+		}),
+	)
+
+	ts.storage.GetUserTier(gomock.Any(), testUser.ID).AnyTimes().
+		Return(&models.UserTier{UserID: testUser.ID, Tier: "Basic", Accrued: 25050}, nil)
+
+	ts.auth().Get("/api/user/tier", ts.handle(ts.handler.Tier))
+
+	var tests = []struct {
+		name   string
+		auth   *models.UserForm
+		status int
+		body   string
+	}{
+		{
+			name:   "Test#1. Unauthorized",
+			status: http.StatusUnauthorized,
+			auth:   nil,
+		},
+		{
+			name:   "Test#2. Progress to the next tier",
+			status: http.StatusOK,
+			auth:   &testUserForm,
+			body: `{"tier":"Basic","multiplier":1,"accrued":250.5,` +
+				`"next_tier":"Silver","next_threshold":1000,"remaining":749.5}`,
 		},
 	}
 
-	mockHasher.EXPECT().CompareHashAndPass(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
-
-	storageRecorder := mockStorage.EXPECT()
-
-	storageRecorder.GetUser(gomock.Any(), userForm1).AnyTimes().Return(&user1, nil)
-	storageRecorder.GetUser(gomock.Any(), userFormClaims1).AnyTimes().Return(&user1, nil)
+	for _, tt := range tests {
+		resp, body := testRequest(t,
+			ts.Server,
+			http.MethodGet,
+			"/api/user/tier",
+			getAuthToken(t, ts.Server, tt.auth),
+			nil)
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s, want: %d, have: %d", tt.name, tt.status, resp.StatusCode))
+		if tt.body != "" {
+			require.JSONEq(t, tt.body, string(body), tt.name)
+		}
+	}
+}
 
-	storageRecorder.GetUserOrders(gomock.Any(), &user1).AnyTimes().Return(orders, nil)
+func Test_Referrals(t *testing.T) {
+	ts := newTestServer(t)
 
-	login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		bHandler.Login(r.Context(), w, r)
-	})
-	ordersHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			bHandler.GetOrder(r.Context(), w, r)
-		})
-		bHandler.JWTMiddleware(handler).ServeHTTP(w, r)
-	})
+	registeredAt, _ := time.Parse(time.RFC3339, "2020-12-10T15:15:45+03:00")
+	paidAt, _ := time.Parse(time.RFC3339, "2020-12-11T10:00:00+03:00")
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/user/login", login)
-	mux.HandleFunc("/api/user/orders", withMiddleware(ordersHandler, bHandler.JWTMiddleware))
+	ts.storage.GetReferrals(gomock.Any(), testUser.ID).AnyTimes().Return(&models.Referrals{
+		Code: "ABCDEFGH23",
+		Referrals: []*models.Referral{
+			{Login: "user2", Status: models.ReferralStatusPaid, Bonus: 10000, CreatedAt: registeredAt, PaidAt: &paidAt},
+			{Login: "user3", Status: models.ReferralStatusPending, CreatedAt: registeredAt},
+		},
+	}, nil)
 
-	srv := httptest.NewServer(mux)
-	defer srv.Close()
+	ts.auth().Get("/api/user/referrals", ts.handle(ts.handler.Referrals))
 
 	var tests = []struct {
 		name   string
-		url    string
-		method string
 		auth   *models.UserForm
 		status int
+		body   string
 	}{
 		{
 			name:   "Test#1. Unauthorized",
-			url:    "/api/user/orders",
 			status: http.StatusUnauthorized,
-			method: http.MethodGet,
 			auth:   nil,
 		},
 		{
-			name:   "Test#2. Valid",
-			url:    "/api/user/orders",
+			name:   "Test#2. Referred users",
 			status: http.StatusOK,
-			method: http.MethodGet,
-			auth:   &models.UserForm{Login: "user1", Password: "pass1"},
+			auth:   &testUserForm,
+			body: `{"code":"ABCDEFGH23","referrals":[` +
+				`{"login":"user2","status":"PAID","bonus":100,` +
+				`"registered_at":"2020-12-10T15:15:45+03:00","paid_at":"2020-12-11T10:00:00+03:00"},` +
+				`{"login":"user3","status":"PENDING","bonus":0,"registered_at":"2020-12-10T15:15:45+03:00"}]}`,
 		},
 	}
 
 	for _, tt := range tests {
-		var b []byte
-		resp, _ := testRequest(t,
-			srv,
-			tt.method,
-			tt.url,
-			getAuthToken(t, srv, tt.auth),
-			bytes.NewBuffer(b))
-
-		if err := resp.Body.Close(); err != nil {
-			t.Error(err)
+		resp, body := testRequest(t,
+			ts.Server,
+			http.MethodGet,
+			"/api/user/referrals",
+			getAuthToken(t, ts.Server, tt.auth),
+			nil)
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s, want: %d, have: %d", tt.name, tt.status, resp.StatusCode))
+		if tt.body != "" {
+			require.JSONEq(t, tt.body, string(body), tt.name)
 		}
-
-		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s URL: %s, want: %d, have: %d", tt.name, tt.url, tt.status, resp.StatusCode))
 	}
 }
 
-func Test_Balance(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
-
-	mockStorage := mocks.NewMockStorage(ctrl)
-	mockHasher := mocks.NewMockHasher(ctrl)
-
-	secret := []byte("supersecret")
-
-	bHandler := NewBaseHandler(
-		secret,
-		3600,
-		mockStorage,
-		mockHasher,
-	)
-
-	userForm1 := models.UserForm{
-		Login:    "user1",
-		Password: "pass1",
-	}
+func Test_OrderHistory(t *testing.T) {
+	ts := newTestServer(t)
 
-	userFormClaims1 := models.UserForm{
-		Login: "user1",
+	order1 := models.Order{
+		ID:         "1",
+		UserID:     "1",
+		Number:     "7305748056314637",
+		Status:     models.OrderStatusProcessed,
+		Accrual:    50000,
+		UploadedAt: time.Now(),
 	}
 
-	user1 := models.User{
-		ID:           "1",
-		Login:        "user1",
-		PasswordHash: "pass1",
+	order2 := models.Order{
+		ID:         "2",
+		UserID:     "2",
+		Number:     "1090888814505555",
+		Status:     models.OrderStatusNew,
+		UploadedAt: time.Now(),
 	}
 
-	userBalance1 := models.UserBalance{
-		Current:   0.100,
-		Withdrawn: 0.500,
+	history := []*models.OrderStatusChange{
+		{
+			OrderID:   "1",
+			To:        models.OrderStatusNew,
+			CreatedAt: time.Now(),
+		},
+		{
+			OrderID:         "1",
+			From:            models.OrderStatusNew,
+			To:              models.OrderStatusProcessed,
+			Accrual:         50000,
+			AccrualResponse: []byte(`{"order":"7305748056314637","status":"PROCESSED","accrual":500}`),
+			CreatedAt:       time.Now(),
+		},
 	}
 
-	mockHasher.EXPECT().CompareHashAndPass(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
-
-	storageRecorder := mockStorage.EXPECT()
-
-	storageRecorder.GetUser(gomock.Any(), userForm1).AnyTimes().Return(&user1, nil)
-	storageRecorder.GetUser(gomock.Any(), userFormClaims1).AnyTimes().Return(&user1, nil)
-
-	storageRecorder.GetUserBalance(gomock.Any(), user1.ID).AnyTimes().Return(&userBalance1, nil)
+	ts.storage.GetOrder(gomock.Any(), models.OrderForm{Number: order1.Number}).AnyTimes().Return(&order1, nil)
+	ts.storage.GetOrder(gomock.Any(), models.OrderForm{Number: order2.Number}).AnyTimes().Return(&order2, nil)
+	ts.storage.GetOrder(gomock.Any(), models.OrderForm{Number: "8885901057661813"}).AnyTimes().Return(nil, models.ErrOrderNotExists)
+	ts.storage.GetOrder(gomock.Any(), models.OrderForm{Number: "abc"}).AnyTimes().Return(nil, models.ErrOrderNotExists)
+	ts.storage.GetOrderHistory(gomock.Any(), order1.ID).AnyTimes().Return(history, nil)
 
-	login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		bHandler.Login(r.Context(), w, r)
-	})
-	ordersHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			bHandler.Balance(r.Context(), w, r)
-		})
-		bHandler.JWTMiddleware(handler).ServeHTTP(w, r)
-	})
-
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/user/login", login)
-	mux.HandleFunc("/api/user/balance", withMiddleware(ordersHandler, bHandler.JWTMiddleware))
-
-	srv := httptest.NewServer(mux)
-	defer srv.Close()
+	ts.auth().Get("/api/user/orders/{number}/history", ts.handle(ts.handler.OrderHistory))
 
 	var tests = []struct {
-		name          string
-		url           string
-		method        string
-		auth          *models.UserForm
-		status        int
-		wantCurrent   float64
-		wantWithdrawn float64
+		name    string
+		url     string
+		method  string
+		auth    *models.UserForm
+		status  int
+		wantLen int
 	}{
 		{
 			name:   "Test#1. Unauthorized",
-			url:    "/api/user/balance",
+			url:    "/api/user/orders/7305748056314637/history",
 			status: http.StatusUnauthorized,
 			method: http.MethodGet,
 			auth:   nil,
 		},
 		{
-			name:          "Test#2. Valid",
-			url:           "/api/user/balance",
-			status:        http.StatusOK,
-			method:        http.MethodGet,
-			auth:          &models.UserForm{Login: "user1", Password: "pass1"},
-			wantCurrent:   0.100,
-			wantWithdrawn: 0.500,
+			name:    "Test#2. Valid",
+			url:     "/api/user/orders/7305748056314637/history",
+			status:  http.StatusOK,
+			method:  http.MethodGet,
+			auth:    &testUserForm,
+			wantLen: 2,
+		},
+		{
+			name:   "Test#3. Order of another user",
+			url:    "/api/user/orders/1090888814505555/history",
+			status: http.StatusNotFound,
+			method: http.MethodGet,
+			auth:   &testUserForm,
+		},
+		{
+			name:   "Test#4. Unknown order",
+			url:    "/api/user/orders/8885901057661813/history",
+			status: http.StatusNotFound,
+			method: http.MethodGet,
+			auth:   &testUserForm,
+		},
+		{
+			name:   "Test#5. Invalid order number",
+			url:    "/api/user/orders/abc/history",
+			status: http.StatusNotFound,
+			method: http.MethodGet,
+			auth:   &testUserForm,
 		},
 	}
 
 	for _, tt := range tests {
 		var b []byte
 		resp, rBytes := testRequest(t,
-			srv,
+			ts.Server,
 			tt.method,
 			tt.url,
-			getAuthToken(t, srv, tt.auth),
+			getAuthToken(t, ts.Server, tt.auth),
 			bytes.NewBuffer(b))
 
 		if err := resp.Body.Close(); err != nil {
@@ -578,80 +1751,44 @@ func Test_Balance(t *testing.T) {
 		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s URL: %s, want: %d, have: %d", tt.name, tt.url, tt.status, resp.StatusCode))
 
 		if resp.StatusCode == http.StatusOK {
-			var balance models.UserBalance
-
-			if err := json.Unmarshal(rBytes, &balance); err != nil {
+			var changes []*models.OrderStatusChange
+			if err := json.Unmarshal(rBytes, &changes); err != nil {
 				t.Error(err)
 			}
-
-			require.Equal(t, tt.wantCurrent, balance.Current,
-				fmt.Sprintf("Current balance: %s URL: %s, want: %g, have: %g",
-					tt.name, tt.url, tt.wantCurrent, balance.Current))
-
-			require.Equal(t, tt.wantWithdrawn, balance.Withdrawn,
-				fmt.Sprintf("Withdrawn balance: %s URL: %s, want: %g, have: %g",
-					tt.name, tt.url, tt.wantWithdrawn, balance.Withdrawn))
+			require.Len(t, changes, tt.wantLen)
 		}
 	}
 }
 
-func Test_BalanceWithdrawn(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
-
-	mockStorage := mocks.NewMockStorage(ctrl)
-	mockHasher := mocks.NewMockHasher(ctrl)
-
-	secret := []byte("supersecret")
-
-	bHandler := NewBaseHandler(
-		secret,
-		3600,
-		mockStorage,
-		mockHasher,
-	)
-
-	userForm1 := models.UserForm{
-		Login:    "user1",
-		Password: "pass1",
-	}
+func Test_GetOrderByNumber(t *testing.T) {
+	ts := newTestServer(t)
 
-	userFormClaims1 := models.UserForm{
-		Login: "user1",
+	order1 := models.Order{
+		ID:         "1",
+		UserID:     "1",
+		Number:     "7305748056314637",
+		Status:     models.OrderStatusProcessing,
+		UploadedAt: time.Now(),
 	}
 
-	user1 := models.User{
-		ID:           "1",
-		Login:        "user1",
-		PasswordHash: "pass1",
+	order2 := models.Order{
+		ID:         "2",
+		UserID:     "2",
+		Number:     "1090888814505555",
+		Status:     models.OrderStatusNew,
+		UploadedAt: time.Now(),
 	}
 
-	mockHasher.EXPECT().CompareHashAndPass(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
-
-	storageRecorder := mockStorage.EXPECT()
-
-	storageRecorder.GetUser(gomock.Any(), userForm1).AnyTimes().Return(&user1, nil)
-	storageRecorder.GetUser(gomock.Any(), userFormClaims1).AnyTimes().Return(&user1, nil)
-
-	storageRecorder.CreateWithdrawal(gomock.Any(), user1.ID, "7305748056314637", 100.100).AnyTimes().Return(nil)
-	storageRecorder.CreateWithdrawal(gomock.Any(), user1.ID, "1090888814505555", 200.200).AnyTimes().Return(models.ErrNotEnoughPoints)
-
-	login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		bHandler.Login(r.Context(), w, r)
-	})
-	withdraw := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			bHandler.Withdraw(r.Context(), w, r)
-		})
-		bHandler.JWTMiddleware(handler).ServeHTTP(w, r)
-	})
-
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/user/login", login)
-	mux.HandleFunc("/api/user/balance/withdraw", withMiddleware(withdraw, bHandler.JWTMiddleware))
+	ts.storage.GetOrder(gomock.Any(), models.OrderForm{Number: order1.Number}).AnyTimes().DoAndReturn(
+		func(_ any, _ models.OrderForm) (*models.Order, error) {
+			o := order1
+			return &o, nil
+		},
+	)
+	ts.storage.GetOrder(gomock.Any(), models.OrderForm{Number: order2.Number}).AnyTimes().Return(&order2, nil)
+	ts.storage.GetOrder(gomock.Any(), models.OrderForm{Number: "8885901057661813"}).AnyTimes().Return(nil, models.ErrOrderNotExists)
 
-	srv := httptest.NewServer(mux)
-	defer srv.Close()
+	ts.auth().Get("/api/user/orders/{number}", ts.handle(ts.handler.GetOrderByNumber))
 
 	var tests = []struct {
 		name   string
@@ -659,53 +1796,44 @@ func Test_BalanceWithdrawn(t *testing.T) {
 		method string
 		auth   *models.UserForm
 		status int
-		order  string
-		sum    float64
 	}{
 		{
 			name:   "Test#1. Unauthorized",
-			url:    "/api/user/balance/withdraw",
+			url:    "/api/user/orders/7305748056314637",
 			status: http.StatusUnauthorized,
-			method: http.MethodPost,
+			method: http.MethodGet,
 			auth:   nil,
 		},
 		{
-			name:   "Test#2. Valid withdraw",
-			url:    "/api/user/balance/withdraw",
+			name:   "Test#2. Valid",
+			url:    "/api/user/orders/7305748056314637",
 			status: http.StatusOK,
-			method: http.MethodPost,
-			auth:   &models.UserForm{Login: "user1", Password: "pass1"},
-			order:  "7305748056314637",
-			sum:    100.100,
+			method: http.MethodGet,
+			auth:   &testUserForm,
 		},
 		{
-			name:   "Test#3. Not enough points",
-			url:    "/api/user/balance/withdraw",
-			status: http.StatusPaymentRequired,
-			method: http.MethodPost,
-			auth:   &models.UserForm{Login: "user1", Password: "pass1"},
-			order:  "1090888814505555",
-			sum:    200.200,
+			name:   "Test#3. Order of another user",
+			url:    "/api/user/orders/1090888814505555",
+			status: http.StatusNotFound,
+			method: http.MethodGet,
+			auth:   &testUserForm,
+		},
+		{
+			name:   "Test#4. Unknown order",
+			url:    "/api/user/orders/8885901057661813",
+			status: http.StatusNotFound,
+			method: http.MethodGet,
+			auth:   &testUserForm,
 		},
 	}
 
 	for _, tt := range tests {
-		withdraw := struct {
-			Order string  `json:"order"`
-			Sum   float64 `json:"sum"`
-		}{
-			Order: tt.order,
-			Sum:   tt.sum,
-		}
-		b, err := json.Marshal(withdraw)
-		if err != nil {
-			t.Error(err)
-		}
-		resp, _ := testRequest(t,
-			srv,
+		var b []byte
+		resp, rBytes := testRequest(t,
+			ts.Server,
 			tt.method,
 			tt.url,
-			getAuthToken(t, srv, tt.auth),
+			getAuthToken(t, ts.Server, tt.auth),
 			bytes.NewBuffer(b))
 
 		if err := resp.Body.Close(); err != nil {
@@ -713,122 +1841,183 @@ func Test_BalanceWithdrawn(t *testing.T) {
 		}
 
 		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s URL: %s, want: %d, have: %d", tt.name, tt.url, tt.status, resp.StatusCode))
+
+		if resp.StatusCode == http.StatusOK {
+			var o models.Order
+			if err := json.Unmarshal(rBytes, &o); err != nil {
+				t.Error(err)
+			}
+			require.Equal(t, order1.Number, o.Number)
+			require.Empty(t, o.UserID)
+			require.NotEmpty(t, resp.Header.Get("ETag"))
+		}
 	}
-}
 
-func Test_Withdrawals(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
+	// not modified order
+	token := getAuthToken(t, ts.Server, &testUserForm)
+	resp, _ := testRequest(t, ts.Server, http.MethodGet, "/api/user/orders/7305748056314637", token, nil)
+	tag := resp.Header.Get("ETag")
 
-	mockStorage := mocks.NewMockStorage(ctrl)
-	mockHasher := mocks.NewMockHasher(ctrl)
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/user/orders/7305748056314637", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", token)
+	req.Header.Set("If-None-Match", tag)
 
-	secret := []byte("supersecret")
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	if err := resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+}
 
-	bHandler := NewBaseHandler(
-		secret,
-		3600,
-		mockStorage,
-		mockHasher,
-	)
+func Test_Idempotency(t *testing.T) {
+	ts := newTestServer(t, IdempotencyTTLOption(time.Hour))
 
-	userForm1 := models.UserForm{
-		Login:    "user1",
-		Password: "pass1",
-	}
+	// the first request creates withdrawal, retries get the stored response
+	var stored *models.IdempotentResponse
+	ts.storage.StartIdempotentRequest(gomock.Any(), testUser.ID, "key-1", gomock.Any(), time.Hour, idempotencyKeyLock).
+		Times(2).
+		DoAndReturn(func(_ any, _, _, _ string, _, _ time.Duration) (*models.IdempotentResponse, error) {
+			return stored, nil
+		})
+	ts.storage.FinishIdempotentRequest(gomock.Any(), testUser.ID, "key-1", gomock.Any()).
+		Times(1).
+		DoAndReturn(func(_ any, _, _ string, resp models.IdempotentResponse) error {
+			stored = &resp
+			return nil
+		})
+	ts.storage.StartIdempotentRequest(gomock.Any(), testUser.ID, "key-2", gomock.Any(), time.Hour, idempotencyKeyLock).
+		Return(nil, models.ErrIdempotencyKeyMismatch)
+	ts.storage.StartIdempotentRequest(gomock.Any(), testUser.ID, "key-3", gomock.Any(), time.Hour, idempotencyKeyLock).
+		Return(nil, models.ErrIdempotencyKeyInProgress)
+	ts.storage.CreateWithdrawal(gomock.Any(), testUser.ID, "7305748056314637", models.Points(10010)).Times(1).Return(nil)
 
-	userFormClaims1 := models.UserForm{
-		Login: "user1",
-	}
+	ts.auth().With(ts.handler.IdempotencyMiddleware).Post("/api/user/balance/withdraw", ts.handle(ts.handler.Withdraw))
 
-	user1 := models.User{
-		ID:           "1",
-		Login:        "user1",
-		PasswordHash: "pass1",
-	}
+	token := getAuthToken(t, ts.Server, &testUserForm)
 
-	withdrawals := []*models.Withdrawal{
+	var tests = []struct {
+		name     string
+		key      string
+		status   int
+		replayed bool
+	}{
 		{
-			OrderNumber: "8885901057661813",
-			Total:       0.100,
-			CreatedAt:   time.Now(),
+			name:   "Test#1. First request",
+			key:    "key-1",
+			status: http.StatusOK,
 		},
 		{
-			OrderNumber: "1154576128108785",
-			Total:       100.100,
-			CreatedAt:   time.Now(),
+			name:     "Test#2. Retry replays response",
+			key:      "key-1",
+			status:   http.StatusOK,
+			replayed: true,
 		},
 		{
-			OrderNumber: "7956829830887973",
-			Total:       111.222,
-			CreatedAt:   time.Now(),
+			name:   "Test#3. Key reused with other request",
+			key:    "key-2",
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "Test#4. First request is in progress",
+			key:    "key-3",
+			status: http.StatusConflict,
 		},
 	}
 
-	mockHasher.EXPECT().CompareHashAndPass(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/user/balance/withdraw",
+			bytes.NewBufferString(`{"order":"7305748056314637","sum":100.1}`))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", token)
+		req.Header.Set("Idempotency-Key", tt.key)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		if err := resp.Body.Close(); err != nil {
+			t.Error(err)
+		}
+
+		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s, want: %d, have: %d", tt.name, tt.status, resp.StatusCode))
+		require.Equal(t, tt.replayed, resp.Header.Get("Idempotent-Replayed") == "true", tt.name)
+	}
+}
+
+// test user logged in by test server and secret of its access tokens
+var (
+	testSecret   = []byte("supersecret")
+	testUserForm = models.UserForm{Login: "user1", Password: "pass1"}
+	testUser     = models.User{ID: "1", Login: "user1", PasswordHash: "pass1"}
+)
 
-	storageRecorder := mockStorage.EXPECT()
+// testServer is a server of handlers with mocked storage and hasher, the test user can log in
+type testServer struct {
+	*httptest.Server
+	storage *mocks.MockStorageMockRecorder
+	hasher  *mocks.MockHasherMockRecorder
+	handler baseHandler
+	mux     *chi.Mux
+}
 
-	storageRecorder.GetUser(gomock.Any(), userForm1).AnyTimes().Return(&user1, nil)
-	storageRecorder.GetUser(gomock.Any(), userFormClaims1).AnyTimes().Return(&user1, nil)
+// newTestServer returns started test server with login route, handler options are applied
+func newTestServer(t *testing.T, opts ...Option) *testServer {
+	t.Helper()
 
-	storageRecorder.GetWithdrawals(gomock.Any(), user1.ID).AnyTimes().Return(withdrawals, nil)
+	ctrl := gomock.NewController(t)
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mockHasher := mocks.NewMockHasher(ctrl)
 
-	login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		bHandler.Login(r.Context(), w, r)
-	})
-	ordersHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			bHandler.Withdrawals(r.Context(), w, r)
-		})
-		bHandler.JWTMiddleware(handler).ServeHTTP(w, r)
-	})
+	ts := &testServer{
+		storage: mockStorage.EXPECT(),
+		hasher:  mockHasher.EXPECT(),
+		handler: NewBaseHandler(middlewares.NewHMACKeySet(testSecret), 3600, mockStorage, mockHasher, opts...),
+		mux:     chi.NewRouter(),
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/user/login", login)
-	mux.HandleFunc("/api/user/withdrawals", withMiddleware(ordersHandler, bHandler.JWTMiddleware))
+	// test user logs in
+	ts.hasher.CompareHashAndPass(testUser.PasswordHash, testUserForm.Password).AnyTimes().Return(true)
+	ts.storage.GetUser(gomock.Any(), testUserForm).AnyTimes().Return(&testUser, nil)
+	ts.storage.CreateRefreshToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	ts.storage.GetPasswordChangedAt(gomock.Any(), testUser.ID).AnyTimes().Return(int64(0), nil)
+	ts.mux.Post("/api/user/login", ts.handle(ts.handler.Login))
 
-	srv := httptest.NewServer(mux)
-	defer srv.Close()
+	ts.Server = httptest.NewServer(ts.mux)
+	t.Cleanup(ts.Close)
 
-	var tests = []struct {
-		name   string
-		url    string
-		method string
-		auth   *models.UserForm
-		status int
-	}{
-		{
-			name:   "Test#1. Unauthorized",
-			url:    "/api/user/withdrawals",
-			status: http.StatusUnauthorized,
-			method: http.MethodGet,
-			auth:   nil,
-		},
-		{
-			name:   "Test#2. Valid",
-			url:    "/api/user/withdrawals",
-			status: http.StatusOK,
-			method: http.MethodGet,
-			auth:   &models.UserForm{Login: "user1", Password: "pass1"},
-		},
+	return ts
+}
+
+// handle returns http.HandlerFunc of the handler method
+func (ts *testServer) handle(fn func(context.Context, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fn(r.Context(), w, r)
 	}
+}
 
-	for _, tt := range tests {
-		var b []byte
-		resp, _ := testRequest(t,
-			srv,
-			tt.method,
-			tt.url,
-			getAuthToken(t, srv, tt.auth),
-			bytes.NewBuffer(b))
+// auth returns router of routes requiring access token
+func (ts *testServer) auth() chi.Router {
+	return ts.mux.With(ts.handler.JWTMiddleware)
+}
 
-		if err := resp.Body.Close(); err != nil {
-			t.Error(err)
-		}
+// loginRequest makes login request with the headers
+func loginRequest(t *testing.T, ts *httptest.Server, uf models.UserForm, headers map[string]string) *http.Response {
+	t.Helper()
 
-		require.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("Test: %s URL: %s, want: %d, have: %d", tt.name, tt.url, tt.status, resp.StatusCode))
+	b, err := json.Marshal(uf)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/user/login", bytes.NewBuffer(b))
+	require.NoError(t, err)
+	for k, v := range headers {
+		req.Header.Set(k, v)
 	}
+
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	return resp
 }
 
 func getAuthToken(t *testing.T, ts *httptest.Server, uf *models.UserForm) string {
@@ -859,10 +2048,16 @@ func testRequest(t *testing.T, ts *httptest.Server,
 ) (*http.Response, []byte) {
 	t.Helper()
 
-	r, err := url.JoinPath(ts.URL, path)
+	u, err := url.Parse(path)
+	require.NoError(t, err)
+
+	r, err := url.JoinPath(ts.URL, u.Path)
 	if err != nil {
 		t.Errorf("URL %s test request  error : %v", err, path)
 	}
+	if u.RawQuery != "" {
+		r += "?" + u.RawQuery
+	}
 
 	req, err := http.NewRequest(method, r, body)
 	if jwt != "" {
@@ -885,8 +2080,31 @@ func testRequest(t *testing.T, ts *httptest.Server,
 	return resp, respBody
 }
 
-func withMiddleware(handler http.HandlerFunc, middleware func(http.Handler) http.Handler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		middleware(handler).ServeHTTP(w, r)
-	}
+func Test_passwordCache(t *testing.T) {
+	now := time.Now()
+	c := newPasswordCache(time.Minute)
+	c.now = func() time.Time { return now }
+
+	// not cached
+	_, ok := c.get("1")
+	require.False(t, ok)
+
+	// cached until TTL is over
+	c.set("1", 100)
+	changedAt, ok := c.get("1")
+	require.True(t, ok)
+	require.Equal(t, int64(100), changedAt)
+
+	// password change replaces cached date
+	c.set("1", 200)
+	changedAt, ok = c.get("1")
+	require.True(t, ok)
+	require.Equal(t, int64(200), changedAt)
+
+	// expired dates are swept
+	now = now.Add(time.Minute)
+	_, ok = c.get("1")
+	require.False(t, ok)
+	c.set("2", 0)
+	require.Len(t, c.changes, 1)
 }
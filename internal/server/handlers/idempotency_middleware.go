@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/internal/server/auth"
+	"github.com/SerjRamone/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// idempotencyKeyHeader request header with client generated unique key
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotencyKeyMaxLen max length of idempotency key
+	idempotencyKeyMaxLen = 255
+
+	// idempotencyKeyLock time request holds idempotency key, retries take over the key of the crashed request after it
+	idempotencyKeyLock = time.Minute
+)
+
+// recordingResponseWriter http.ResponseWriter implementation which keeps response copy
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// Write ...
+func (r *recordingResponseWriter) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// WriteHeader ...
+func (r *recordingResponseWriter) WriteHeader(statusCode int) {
+	r.status = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// IdempotencyMiddleware replays the stored response to retries of request with the same Idempotency-Key header.
+// Must be used after JWTMiddleware, keys are stored per user
+func (bHandler baseHandler) IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > idempotencyKeyMaxLen {
+			w.WriteHeader(http.StatusBadRequest) // 400
+			return
+		}
+
+		// get authenticated user
+		u, ok := auth.FromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
+
+		// read request body and put it back for the handler
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Error("reading request body error", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(b))
+
+		// the same key must be used with the same request only
+		h := sha256.New()
+		h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		h.Write(b)
+		hash := hex.EncodeToString(h.Sum(nil))
+
+		stored, err := bHandler.storage.StartIdempotentRequest(r.Context(), u.UserID, key, hash, bHandler.idempotencyTTL, idempotencyKeyLock)
+		switch {
+		case errors.Is(err, models.ErrIdempotencyKeyMismatch):
+			w.WriteHeader(http.StatusUnprocessableEntity) // 422
+			return
+		case errors.Is(err, models.ErrIdempotencyKeyInProgress):
+			w.WriteHeader(http.StatusConflict) // 409
+			return
+		case err != nil:
+			logger.Error("start idempotent request error", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		case stored != nil:
+			// replay the first response
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			if _, err := w.Write(stored.Body); err != nil {
+				logger.Error("write response error", zap.Error(err))
+			}
+			return
+		}
+
+		rw := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		// store the response even if client is gone
+		ctx := context.WithoutCancel(r.Context())
+
+		// server errors are not stored, so the request may be retried
+		if rw.status >= http.StatusInternalServerError {
+			if err := bHandler.storage.CancelIdempotentRequest(ctx, u.UserID, key); err != nil {
+				logger.Error("cancel idempotent request error", zap.Error(err))
+			}
+			return
+		}
+
+		resp := models.IdempotentResponse{
+			Status:      rw.status,
+			ContentType: rw.Header().Get("Content-Type"),
+			Body:        rw.body.Bytes(),
+		}
+		if err := bHandler.storage.FinishIdempotentRequest(ctx, u.UserID, key, resp); err != nil {
+			logger.Error("finish idempotent request error", zap.Error(err))
+		}
+	})
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/SerjRamone/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// JWKS is "GET /.well-known/jwks.json" handler, publishes public keys access tokens are verified by
+func (bHandler baseHandler) JWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(bHandler.keys.JWKS())
+	if err != nil {
+		logger.Error("marshal JWKS error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// keys are rotated rarely, verifiers may cache them for a while
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if _, err = w.Write(b); err != nil {
+		logger.Error("write response error", zap.Error(err))
+	}
+}
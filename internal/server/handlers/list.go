@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+)
+
+const (
+	// defaultListLimit default page size of lists requested by cursor
+	defaultListLimit = 100
+
+	// maxListLimit max page size of lists
+	maxListLimit = 1000
+)
+
+// parseListQuery returns list query from request URL params:
+// limit, cursor, status (comma separated), from, to (RFC3339) and sort (asc or desc).
+// The list is unlimited if neither limit nor cursor is given
+func parseListQuery(r *http.Request, statuses []string, desc bool) (models.ListQuery, error) {
+	params := r.URL.Query()
+	q := models.ListQuery{
+		Desc: desc,
+	}
+
+	// page size
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+		q.Limit = limit
+	}
+
+	// page position
+	if v := params.Get("cursor"); v != "" {
+		c, err := models.DecodeCursor(v)
+		if err != nil {
+			return q, err
+		}
+		q.After = c
+		if q.Limit == 0 {
+			q.Limit = defaultListLimit
+		}
+	}
+
+	// statuses filter
+	for _, v := range params["status"] {
+		for _, status := range strings.Split(v, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if !contains(statuses, status) {
+				return q, fmt.Errorf("invalid status %q", status)
+			}
+			q.Statuses = append(q.Statuses, status)
+		}
+	}
+
+	// dates filter
+	var err error
+	if q.From, err = parseTimeParam(params, "from"); err != nil {
+		return q, err
+	}
+	if q.To, err = parseTimeParam(params, "to"); err != nil {
+		return q, err
+	}
+
+	// sort direction
+	switch params.Get("sort") {
+	case "":
+	case "asc":
+		q.Desc = false
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("invalid sort %q", params.Get("sort"))
+	}
+
+	return q, nil
+}
+
+// parseTimeParam parses RFC3339 date URL param, returns zero time if param is empty
+func parseTimeParam(params url.Values, name string) (time.Time, error) {
+	v := params.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s date %q: %w", name, v, err)
+	}
+	return t, nil
+}
+
+// setNextPage sets Link and X-Next-Cursor headers pointing to the next page of list
+func setNextPage(w http.ResponseWriter, r *http.Request, c models.Cursor) {
+	cursor := c.Encode()
+
+	params := r.URL.Query()
+	params.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: params.Encode()}
+
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+	w.Header().Set("X-Next-Cursor", cursor)
+}
+
+// contains returns true if slice contains value
+func contains(slice []string, value string) bool {
+	for _, s := range slice {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
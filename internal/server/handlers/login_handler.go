@@ -4,10 +4,15 @@ package handlers
 import (
 	"context"
 	"errors"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/SerjRamone/gophermart/internal/models"
-	"github.com/SerjRamone/gophermart/internal/server/middlewares"
 	"github.com/SerjRamone/gophermart/pkg/logger"
 	"go.uber.org/zap"
 )
@@ -21,12 +26,25 @@ func (bHandler baseHandler) Login(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 
+	// count the attempt, the login and the client mustn't be locked out before password hash is evaluated
+	ip := bHandler.clientIP(r)
+	wait, err := bHandler.guard.AttemptLogin(ctx, uf.Login, ip)
+	if err != nil {
+		logger.Error("login attempt error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+	if wait > 0 {
+		tooManyAttempts(w, wait)
+		return
+	}
+
 	// find user in storage
 	u, err := bHandler.storage.GetUser(ctx, *uf)
 	if err != nil {
 		if errors.Is(err, models.ErrUserNotExists) {
 			logger.Error("user not found", zap.Error(err))
-			w.WriteHeader(http.StatusUnauthorized)
+			bHandler.loginFailed(ctx, w, uf.Login, ip, http.StatusUnauthorized)
 			return
 		}
 
@@ -37,16 +55,92 @@ func (bHandler baseHandler) Login(ctx context.Context, w http.ResponseWriter, r
 
 	// hashes did not match
 	if !bHandler.hasher.CompareHashAndPass(u.PasswordHash, uf.Password) {
-		w.WriteHeader(http.StatusUnauthorized)
+		bHandler.loginFailed(ctx, w, uf.Login, ip, http.StatusUnauthorized)
 		return
 	}
 
-	token, err := middlewares.GenerateJWT(bHandler.secret, u.Login, bHandler.tokenExpr)
+	// reset failures of the login
+	if err := bHandler.guard.SucceedLogin(ctx, uf.Login, ip); err != nil {
+		logger.Error("reset login failures error", zap.Error(err))
+	}
+
+	// generate auth tokens
+	if err := bHandler.issueTokens(ctx, w, u); err != nil {
+		logger.Error("issue tokens error", zap.Error(err))
+	}
+}
+
+// loginFailed records failed login attempt and writes response with the status
+func (bHandler baseHandler) loginFailed(ctx context.Context, w http.ResponseWriter, login, ip string, status int) {
+	lockout, err := bHandler.guard.FailLogin(ctx, login, ip)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		logger.Error("record login failure error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
 		return
 	}
 
-	w.Header().Set("Authorization", token)
-	w.WriteHeader(http.StatusOK)
+	logger.Warn("login failed", zap.String("login", login), zap.String("ip", ip), zap.Duration("lockout", lockout))
+	w.WriteHeader(status)
+}
+
+// tooManyAttempts writes 429 response with lockout time left in seconds
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests) // 429
+}
+
+// clientIP returns IP of the request client forwarded by a trusted proxy. Empty IP is returned
+// for other requests, their address can't be told from the address of an unknown proxy
+func (bHandler baseHandler) clientIP(r *http.Request) string {
+	if !bHandler.trustedProxy(r.RemoteAddr) {
+		return ""
+	}
+
+	// the rightmost address not added by trusted proxies, the left ones are set by the client
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			if !bHandler.trustedProxy(hops[i]) {
+				if ip, ok := parseAddr(hops[i]); ok {
+					return ip.String()
+				}
+				return ""
+			}
+		}
+		return ""
+	}
+
+	if ip, ok := parseAddr(r.Header.Get("X-Real-IP")); ok {
+		return ip.String()
+	}
+	return ""
+}
+
+// trustedProxy reports whether the address belongs to a trusted proxy
+func (bHandler baseHandler) trustedProxy(addr string) bool {
+	ip, ok := parseAddr(addr)
+	if !ok {
+		return false
+	}
+
+	for _, p := range bHandler.proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddr returns IP of the address with or without port
+func parseAddr(addr string) (netip.Addr, bool) {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
 }
@@ -7,6 +7,7 @@ package mocks
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	models "github.com/SerjRamone/gophermart/internal/models"
 	gomock "github.com/golang/mock/gomock"
@@ -35,6 +36,80 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
+// CancelIdempotentRequest mocks base method.
+func (m *MockStorage) CancelIdempotentRequest(ctx context.Context, userID, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelIdempotentRequest", ctx, userID, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CancelIdempotentRequest indicates an expected call of CancelIdempotentRequest.
+func (mr *MockStorageMockRecorder) CancelIdempotentRequest(ctx, userID, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelIdempotentRequest", reflect.TypeOf((*MockStorage)(nil).CancelIdempotentRequest), ctx, userID, key)
+}
+
+// CancelWithdrawal mocks base method.
+func (m *MockStorage) CancelWithdrawal(ctx context.Context, userID, number string, window time.Duration) (*models.Withdrawal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelWithdrawal", ctx, userID, number, window)
+	ret0, _ := ret[0].(*models.Withdrawal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelWithdrawal indicates an expected call of CancelWithdrawal.
+func (mr *MockStorageMockRecorder) CancelWithdrawal(ctx, userID, number, window interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelWithdrawal", reflect.TypeOf((*MockStorage)(nil).CancelWithdrawal), ctx, userID, number, window)
+}
+
+// ChangePassword mocks base method.
+func (m *MockStorage) ChangePassword(ctx context.Context, userID, passwordHash string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChangePassword", ctx, userID, passwordHash)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ChangePassword indicates an expected call of ChangePassword.
+func (mr *MockStorageMockRecorder) ChangePassword(ctx, userID, passwordHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangePassword", reflect.TypeOf((*MockStorage)(nil).ChangePassword), ctx, userID, passwordHash)
+}
+
+// ClaimOrders mocks base method.
+func (m *MockStorage) ClaimOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]*models.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimOrders", ctx, owner, limit, lease)
+	ret0, _ := ret[0].([]*models.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimOrders indicates an expected call of ClaimOrders.
+func (mr *MockStorageMockRecorder) ClaimOrders(ctx, owner, limit, lease interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimOrders", reflect.TypeOf((*MockStorage)(nil).ClaimOrders), ctx, owner, limit, lease)
+}
+
+// ClaimProcessedOrders mocks base method.
+func (m *MockStorage) ClaimProcessedOrders(ctx context.Context, owner string, limit int, lease, window time.Duration) ([]*models.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimProcessedOrders", ctx, owner, limit, lease, window)
+	ret0, _ := ret[0].([]*models.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimProcessedOrders indicates an expected call of ClaimProcessedOrders.
+func (mr *MockStorageMockRecorder) ClaimProcessedOrders(ctx, owner, limit, lease, window interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimProcessedOrders", reflect.TypeOf((*MockStorage)(nil).ClaimProcessedOrders), ctx, owner, limit, lease, window)
+}
+
 // CreateOrder mocks base method.
 func (m *MockStorage) CreateOrder(arg0 context.Context, arg1 models.OrderForm) (*models.Order, error) {
 	m.ctrl.T.Helper()
@@ -50,6 +125,35 @@ func (mr *MockStorageMockRecorder) CreateOrder(arg0, arg1 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrder", reflect.TypeOf((*MockStorage)(nil).CreateOrder), arg0, arg1)
 }
 
+// CreateRefreshToken mocks base method.
+func (m *MockStorage) CreateRefreshToken(ctx context.Context, userID, tokenHash string, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRefreshToken", ctx, userID, tokenHash, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateRefreshToken indicates an expected call of CreateRefreshToken.
+func (mr *MockStorageMockRecorder) CreateRefreshToken(ctx, userID, tokenHash, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRefreshToken", reflect.TypeOf((*MockStorage)(nil).CreateRefreshToken), ctx, userID, tokenHash, ttl)
+}
+
+// CreateTransfer mocks base method.
+func (m *MockStorage) CreateTransfer(ctx context.Context, senderID, recipientLogin string, amount models.Points) (*models.Transfer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateTransfer", ctx, senderID, recipientLogin, amount)
+	ret0, _ := ret[0].(*models.Transfer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateTransfer indicates an expected call of CreateTransfer.
+func (mr *MockStorageMockRecorder) CreateTransfer(ctx, senderID, recipientLogin, amount interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTransfer", reflect.TypeOf((*MockStorage)(nil).CreateTransfer), ctx, senderID, recipientLogin, amount)
+}
+
 // CreateUser mocks base method.
 func (m *MockStorage) CreateUser(arg0 context.Context, arg1 models.UserForm) (*models.User, error) {
 	m.ctrl.T.Helper()
@@ -66,7 +170,7 @@ func (mr *MockStorageMockRecorder) CreateUser(arg0, arg1 interface{}) *gomock.Ca
 }
 
 // CreateWithdrawal mocks base method.
-func (m *MockStorage) CreateWithdrawal(ctx context.Context, userID, number string, total float64) error {
+func (m *MockStorage) CreateWithdrawal(ctx context.Context, userID, number string, total models.Points) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "CreateWithdrawal", ctx, userID, number, total)
 	ret0, _ := ret[0].(error)
@@ -79,6 +183,20 @@ func (mr *MockStorageMockRecorder) CreateWithdrawal(ctx, userID, number, total i
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateWithdrawal", reflect.TypeOf((*MockStorage)(nil).CreateWithdrawal), ctx, userID, number, total)
 }
 
+// FinishIdempotentRequest mocks base method.
+func (m *MockStorage) FinishIdempotentRequest(ctx context.Context, userID, key string, resp models.IdempotentResponse) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FinishIdempotentRequest", ctx, userID, key, resp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FinishIdempotentRequest indicates an expected call of FinishIdempotentRequest.
+func (mr *MockStorageMockRecorder) FinishIdempotentRequest(ctx, userID, key, resp interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FinishIdempotentRequest", reflect.TypeOf((*MockStorage)(nil).FinishIdempotentRequest), ctx, userID, key, resp)
+}
+
 // GetOrder mocks base method.
 func (m *MockStorage) GetOrder(arg0 context.Context, arg1 models.OrderForm) (*models.Order, error) {
 	m.ctrl.T.Helper()
@@ -94,19 +212,64 @@ func (mr *MockStorageMockRecorder) GetOrder(arg0, arg1 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrder", reflect.TypeOf((*MockStorage)(nil).GetOrder), arg0, arg1)
 }
 
-// GetUnprocessedOrders mocks base method.
-func (m *MockStorage) GetUnprocessedOrders(ctx context.Context) ([]*models.Order, error) {
+// GetOrderHistory mocks base method.
+func (m *MockStorage) GetOrderHistory(ctx context.Context, orderID string) ([]*models.OrderStatusChange, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUnprocessedOrders", ctx)
-	ret0, _ := ret[0].([]*models.Order)
+	ret := m.ctrl.Call(m, "GetOrderHistory", ctx, orderID)
+	ret0, _ := ret[0].([]*models.OrderStatusChange)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderHistory indicates an expected call of GetOrderHistory.
+func (mr *MockStorageMockRecorder) GetOrderHistory(ctx, orderID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderHistory", reflect.TypeOf((*MockStorage)(nil).GetOrderHistory), ctx, orderID)
+}
+
+// GetPasswordChangedAt mocks base method.
+func (m *MockStorage) GetPasswordChangedAt(ctx context.Context, userID string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPasswordChangedAt", ctx, userID)
+	ret0, _ := ret[0].(int64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetUnprocessedOrders indicates an expected call of GetUnprocessedOrders.
-func (mr *MockStorageMockRecorder) GetUnprocessedOrders(ctx interface{}) *gomock.Call {
+// GetPasswordChangedAt indicates an expected call of GetPasswordChangedAt.
+func (mr *MockStorageMockRecorder) GetPasswordChangedAt(ctx, userID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUnprocessedOrders", reflect.TypeOf((*MockStorage)(nil).GetUnprocessedOrders), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPasswordChangedAt", reflect.TypeOf((*MockStorage)(nil).GetPasswordChangedAt), ctx, userID)
+}
+
+// GetReferrals mocks base method.
+func (m *MockStorage) GetReferrals(ctx context.Context, userID string) (*models.Referrals, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReferrals", ctx, userID)
+	ret0, _ := ret[0].(*models.Referrals)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetReferrals indicates an expected call of GetReferrals.
+func (mr *MockStorageMockRecorder) GetReferrals(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReferrals", reflect.TypeOf((*MockStorage)(nil).GetReferrals), ctx, userID)
+}
+
+// GetTransfers mocks base method.
+func (m *MockStorage) GetTransfers(ctx context.Context, userID string, q models.ListQuery) ([]*models.Transfer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTransfers", ctx, userID, q)
+	ret0, _ := ret[0].([]*models.Transfer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTransfers indicates an expected call of GetTransfers.
+func (mr *MockStorageMockRecorder) GetTransfers(ctx, userID, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransfers", reflect.TypeOf((*MockStorage)(nil).GetTransfers), ctx, userID, q)
 }
 
 // GetUser mocks base method.
@@ -140,47 +303,177 @@ func (mr *MockStorageMockRecorder) GetUserBalance(ctx, userID interface{}) *gomo
 }
 
 // GetUserOrders mocks base method.
-func (m *MockStorage) GetUserOrders(ctx context.Context, order *models.User) ([]*models.Order, error) {
+func (m *MockStorage) GetUserOrders(ctx context.Context, userID string, q models.ListQuery) ([]*models.Order, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUserOrders", ctx, order)
+	ret := m.ctrl.Call(m, "GetUserOrders", ctx, userID, q)
 	ret0, _ := ret[0].([]*models.Order)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetUserOrders indicates an expected call of GetUserOrders.
-func (mr *MockStorageMockRecorder) GetUserOrders(ctx, order interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) GetUserOrders(ctx, userID, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserOrders", reflect.TypeOf((*MockStorage)(nil).GetUserOrders), ctx, userID, q)
+}
+
+// GetUserTier mocks base method.
+func (m *MockStorage) GetUserTier(ctx context.Context, userID string) (*models.UserTier, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserTier", ctx, userID)
+	ret0, _ := ret[0].(*models.UserTier)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserTier indicates an expected call of GetUserTier.
+func (mr *MockStorageMockRecorder) GetUserTier(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserTier", reflect.TypeOf((*MockStorage)(nil).GetUserTier), ctx, userID)
+}
+
+// GetWithdrawal mocks base method.
+func (m *MockStorage) GetWithdrawal(ctx context.Context, number string) (*models.Withdrawal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWithdrawal", ctx, number)
+	ret0, _ := ret[0].(*models.Withdrawal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWithdrawal indicates an expected call of GetWithdrawal.
+func (mr *MockStorageMockRecorder) GetWithdrawal(ctx, number interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserOrders", reflect.TypeOf((*MockStorage)(nil).GetUserOrders), ctx, order)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawal", reflect.TypeOf((*MockStorage)(nil).GetWithdrawal), ctx, number)
 }
 
 // GetWithdrawals mocks base method.
-func (m *MockStorage) GetWithdrawals(ctx context.Context, userID string) ([]*models.Withdrawal, error) {
+func (m *MockStorage) GetWithdrawals(ctx context.Context, userID string, q models.ListQuery) ([]*models.Withdrawal, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetWithdrawals", ctx, userID)
+	ret := m.ctrl.Call(m, "GetWithdrawals", ctx, userID, q)
 	ret0, _ := ret[0].([]*models.Withdrawal)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetWithdrawals indicates an expected call of GetWithdrawals.
-func (mr *MockStorageMockRecorder) GetWithdrawals(ctx, userID interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) GetWithdrawals(ctx, userID, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawals", reflect.TypeOf((*MockStorage)(nil).GetWithdrawals), ctx, userID, q)
+}
+
+// ReleaseOrder mocks base method.
+func (m *MockStorage) ReleaseOrder(ctx context.Context, owner, orderID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseOrder", ctx, owner, orderID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseOrder indicates an expected call of ReleaseOrder.
+func (mr *MockStorageMockRecorder) ReleaseOrder(ctx, owner, orderID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseOrder", reflect.TypeOf((*MockStorage)(nil).ReleaseOrder), ctx, owner, orderID)
+}
+
+// ReleaseOrders mocks base method.
+func (m *MockStorage) ReleaseOrders(ctx context.Context, owner string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseOrders", ctx, owner)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseOrders indicates an expected call of ReleaseOrders.
+func (mr *MockStorageMockRecorder) ReleaseOrders(ctx, owner interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseOrders", reflect.TypeOf((*MockStorage)(nil).ReleaseOrders), ctx, owner)
+}
+
+// ReviseOrder mocks base method.
+func (m *MockStorage) ReviseOrder(ctx context.Context, owner string, order *models.Order) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReviseOrder", ctx, owner, order)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReviseOrder indicates an expected call of ReviseOrder.
+func (mr *MockStorageMockRecorder) ReviseOrder(ctx, owner, order interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReviseOrder", reflect.TypeOf((*MockStorage)(nil).ReviseOrder), ctx, owner, order)
+}
+
+// RevokeRefreshToken mocks base method.
+func (m *MockStorage) RevokeRefreshToken(ctx context.Context, userID, tokenHash string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeRefreshToken", ctx, userID, tokenHash)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeRefreshToken indicates an expected call of RevokeRefreshToken.
+func (mr *MockStorageMockRecorder) RevokeRefreshToken(ctx, userID, tokenHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeRefreshToken", reflect.TypeOf((*MockStorage)(nil).RevokeRefreshToken), ctx, userID, tokenHash)
+}
+
+// RevokeRefreshTokens mocks base method.
+func (m *MockStorage) RevokeRefreshTokens(ctx context.Context, userID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeRefreshTokens", ctx, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeRefreshTokens indicates an expected call of RevokeRefreshTokens.
+func (mr *MockStorageMockRecorder) RevokeRefreshTokens(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeRefreshTokens", reflect.TypeOf((*MockStorage)(nil).RevokeRefreshTokens), ctx, userID)
+}
+
+// RotateRefreshToken mocks base method.
+func (m *MockStorage) RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash string, ttl time.Duration) (*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateRefreshToken", ctx, tokenHash, newTokenHash, ttl)
+	ret0, _ := ret[0].(*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateRefreshToken indicates an expected call of RotateRefreshToken.
+func (mr *MockStorageMockRecorder) RotateRefreshToken(ctx, tokenHash, newTokenHash, ttl interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawals", reflect.TypeOf((*MockStorage)(nil).GetWithdrawals), ctx, userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateRefreshToken", reflect.TypeOf((*MockStorage)(nil).RotateRefreshToken), ctx, tokenHash, newTokenHash, ttl)
+}
+
+// StartIdempotentRequest mocks base method.
+func (m *MockStorage) StartIdempotentRequest(ctx context.Context, userID, key, requestHash string, ttl, lock time.Duration) (*models.IdempotentResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StartIdempotentRequest", ctx, userID, key, requestHash, ttl, lock)
+	ret0, _ := ret[0].(*models.IdempotentResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StartIdempotentRequest indicates an expected call of StartIdempotentRequest.
+func (mr *MockStorageMockRecorder) StartIdempotentRequest(ctx, userID, key, requestHash, ttl, lock interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartIdempotentRequest", reflect.TypeOf((*MockStorage)(nil).StartIdempotentRequest), ctx, userID, key, requestHash, ttl, lock)
 }
 
 // UpdateOrder mocks base method.
-func (m *MockStorage) UpdateOrder(ctx context.Context, order *models.Order) error {
+func (m *MockStorage) UpdateOrder(ctx context.Context, owner string, order *models.Order) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateOrder", ctx, order)
+	ret := m.ctrl.Call(m, "UpdateOrder", ctx, owner, order)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateOrder indicates an expected call of UpdateOrder.
-func (mr *MockStorageMockRecorder) UpdateOrder(ctx, order interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) UpdateOrder(ctx, owner, order interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrder", reflect.TypeOf((*MockStorage)(nil).UpdateOrder), ctx, order)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrder", reflect.TypeOf((*MockStorage)(nil).UpdateOrder), ctx, owner, order)
 }
 
 // MockHasher is a mock of Hasher interface.
@@ -234,3 +527,70 @@ func (mr *MockHasherMockRecorder) GetHash(password interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHash", reflect.TypeOf((*MockHasher)(nil).GetHash), password)
 }
+
+// MockLoginGuard is a mock of LoginGuard interface.
+type MockLoginGuard struct {
+	ctrl     *gomock.Controller
+	recorder *MockLoginGuardMockRecorder
+}
+
+// MockLoginGuardMockRecorder is the mock recorder for MockLoginGuard.
+type MockLoginGuardMockRecorder struct {
+	mock *MockLoginGuard
+}
+
+// NewMockLoginGuard creates a new mock instance.
+func NewMockLoginGuard(ctrl *gomock.Controller) *MockLoginGuard {
+	mock := &MockLoginGuard{ctrl: ctrl}
+	mock.recorder = &MockLoginGuardMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLoginGuard) EXPECT() *MockLoginGuardMockRecorder {
+	return m.recorder
+}
+
+// AttemptLogin mocks base method.
+func (m *MockLoginGuard) AttemptLogin(ctx context.Context, login, ip string) (time.Duration, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AttemptLogin", ctx, login, ip)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AttemptLogin indicates an expected call of AttemptLogin.
+func (mr *MockLoginGuardMockRecorder) AttemptLogin(ctx, login, ip interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AttemptLogin", reflect.TypeOf((*MockLoginGuard)(nil).AttemptLogin), ctx, login, ip)
+}
+
+// FailLogin mocks base method.
+func (m *MockLoginGuard) FailLogin(ctx context.Context, login, ip string) (time.Duration, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FailLogin", ctx, login, ip)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FailLogin indicates an expected call of FailLogin.
+func (mr *MockLoginGuardMockRecorder) FailLogin(ctx, login, ip interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailLogin", reflect.TypeOf((*MockLoginGuard)(nil).FailLogin), ctx, login, ip)
+}
+
+// SucceedLogin mocks base method.
+func (m *MockLoginGuard) SucceedLogin(ctx context.Context, login, ip string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SucceedLogin", ctx, login, ip)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SucceedLogin indicates an expected call of SucceedLogin.
+func (mr *MockLoginGuardMockRecorder) SucceedLogin(ctx, login, ip interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SucceedLogin", reflect.TypeOf((*MockLoginGuard)(nil).SucceedLogin), ctx, login, ip)
+}
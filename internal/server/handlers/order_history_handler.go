@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/internal/server/auth"
+	"github.com/SerjRamone/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// OrderHistory is "GET /api/user/orders/{number}/history" handler
+func (bHandler baseHandler) OrderHistory(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	// get user's order
+	o, err := bHandler.getUserOrder(ctx, w, r, u.UserID)
+	if err != nil {
+		if !errors.Is(err, models.ErrOrderNotExists) {
+			logger.Error("get user's order error", zap.Error(err))
+		}
+		return
+	}
+
+	// get order status history from storage
+	history, err := bHandler.storage.GetOrderHistory(ctx, o.ID)
+	if err != nil {
+		logger.Error("get order history error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// marshal history
+	b, err := json.Marshal(history)
+	if err != nil {
+		logger.Error("marshal order history error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// write response
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(b); err != nil {
+		logger.Error("write response error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -8,17 +8,25 @@ import (
 	"net/http"
 
 	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/internal/server/auth"
 	"github.com/SerjRamone/gophermart/pkg/logger"
 	"go.uber.org/zap"
 )
 
+// orderStatuses order statuses allowed in orders list filter
+var orderStatuses = []string{
+	models.OrderStatusNew,
+	models.OrderStatusProcessing,
+	models.OrderStatusInvalid,
+	models.OrderStatusProcessed,
+}
+
 // PostOrder is "POST /api/user/orders" handler
 func (bHandler baseHandler) PostOrder(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	// get user from token
-	u, err := bHandler.getUserFromToken(r)
-	if err != nil {
-		logger.Error("get user from token error", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError) // 500
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
 		return
 	}
 
@@ -37,7 +45,7 @@ func (bHandler baseHandler) PostOrder(ctx context.Context, w http.ResponseWriter
 
 	of := models.OrderForm{
 		Number: string(b),
-		UserID: u.ID,
+		UserID: u.UserID,
 	}
 
 	// validate order number
@@ -48,6 +56,12 @@ func (bHandler baseHandler) PostOrder(ctx context.Context, w http.ResponseWriter
 
 	// create order in storage
 	if _, err = bHandler.storage.CreateOrder(ctx, of); err != nil {
+		// user is deleted after token was issued
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
+
 		// get unknown error
 		if !errors.Is(err, models.ErrOrderAlreadyExists) {
 			logger.Error("order create error", zap.Error(err))
@@ -63,7 +77,7 @@ func (bHandler baseHandler) PostOrder(ctx context.Context, w http.ResponseWriter
 			return
 		}
 		// check if order by another user
-		if o.UserID != u.ID {
+		if o.UserID != u.UserID {
 			w.WriteHeader(http.StatusConflict) // 409
 			return
 		}
@@ -77,17 +91,35 @@ func (bHandler baseHandler) PostOrder(ctx context.Context, w http.ResponseWriter
 
 // GetOrder is "GET /api/user/orders" handler
 func (bHandler baseHandler) GetOrder(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	// get user from token
-	u, err := bHandler.getUserFromToken(r)
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	// get list params
+	q, err := parseListQuery(r, orderStatuses, false)
 	if err != nil {
-		logger.Error("get user from token error", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+		logger.Error("parse orders list query error", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest) // 400
 		return
 	}
 
+	// get one extra order to know if there is the next page
+	limit := q.Limit
+	if limit > 0 {
+		q.Limit++
+	}
+
 	// get orders from storage
-	orders, err := bHandler.storage.GetUserOrders(ctx, u)
+	orders, err := bHandler.storage.GetUserOrders(ctx, u.UserID, q)
 	if err != nil {
+		// user is deleted after token was issued
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
 		logger.Error("get user's order error", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -99,6 +131,13 @@ func (bHandler baseHandler) GetOrder(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
+	// link to the next page
+	if limit > 0 && len(orders) > limit {
+		orders = orders[:limit]
+		last := orders[limit-1]
+		setNextPage(w, r, models.Cursor{At: last.UploadedAt, ID: last.ID})
+	}
+
 	// marshal orders
 	b, err := json.Marshal(orders)
 	if err != nil {
@@ -115,3 +154,48 @@ func (bHandler baseHandler) GetOrder(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 }
+
+// GetOrderByNumber is "GET /api/user/orders/{number}" handler
+func (bHandler baseHandler) GetOrderByNumber(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	// get user's order
+	o, err := bHandler.getUserOrder(ctx, w, r, u.UserID)
+	if err != nil {
+		if !errors.Is(err, models.ErrOrderNotExists) {
+			logger.Error("get user's order error", zap.Error(err))
+		}
+		return
+	}
+	o.UserID = ""
+
+	// marshal order
+	b, err := json.Marshal(o)
+	if err != nil {
+		logger.Error("marshal order error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// order is not changed since the client's last request
+	tag := etag(b)
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if isETagMatched(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified) // 304
+		return
+	}
+
+	// write response
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(b); err != nil {
+		logger.Error("write response error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultPasswordCacheTTL time user's password change date is cached by JWT middleware.
+// Password changed on another replica revokes access tokens checked by this one within it
+const defaultPasswordCacheTTL = 30 * time.Second
+
+// cachedPasswordChange cached password change date of a user
+type cachedPasswordChange struct {
+	changedAt int64
+	expiresAt time.Time
+}
+
+// passwordCache caches users password change dates, so most of requests are authenticated without storage
+type passwordCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	changes map[string]cachedPasswordChange
+	sweepAt time.Time
+	now     func() time.Time
+}
+
+// newPasswordCache returns password change dates cache
+func newPasswordCache(ttl time.Duration) *passwordCache {
+	return &passwordCache{
+		ttl:     ttl,
+		changes: map[string]cachedPasswordChange{},
+		now:     time.Now,
+	}
+}
+
+// get returns cached password change date of the user, false if it isn't cached or expired
+func (c *passwordCache) get(userID string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pc, ok := c.changes[userID]
+	if !ok || !c.now().Before(pc.expiresAt) {
+		return 0, false
+	}
+	return pc.changedAt, true
+}
+
+// set caches password change date of the user
+func (c *passwordCache) set(userID string, changedAt int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.sweep(now)
+	c.changes[userID] = cachedPasswordChange{changedAt: changedAt, expiresAt: now.Add(c.ttl)}
+}
+
+// sweep deletes expired dates once per TTL
+func (c *passwordCache) sweep(now time.Time) {
+	if now.Before(c.sweepAt) {
+		return
+	}
+	for userID, pc := range c.changes {
+		if !now.Before(pc.expiresAt) {
+			delete(c.changes, userID)
+		}
+	}
+	c.sweepAt = now.Add(c.ttl)
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/internal/server/auth"
+	"github.com/SerjRamone/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type passwordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePassword is "POST /api/user/password" handler. Changed password logs the user out
+// from all sessions, the new session tokens are returned
+func (bHandler baseHandler) ChangePassword(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	pc := passwordChange{}
+
+	// read request body
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Error("read /password request body error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// unmarshal body
+	if err := json.Unmarshal(b, &pc); err != nil || pc.CurrentPassword == "" {
+		logger.Error("unmarshal password change body error", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest) // 400
+		return
+	}
+
+	// current password guessing is limited as login
+	ip := bHandler.clientIP(r)
+	wait, err := bHandler.guard.AttemptLogin(ctx, u.Login, ip)
+	if err != nil {
+		logger.Error("login attempt error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+	if wait > 0 {
+		tooManyAttempts(w, wait)
+		return
+	}
+
+	// find user in storage
+	user, err := bHandler.storage.GetUser(ctx, models.UserForm{Login: u.Login})
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
+		logger.Error("get user error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// check current password
+	if !bHandler.hasher.CompareHashAndPass(user.PasswordHash, pc.CurrentPassword) {
+		bHandler.loginFailed(ctx, w, u.Login, ip, http.StatusForbidden) // 403
+		return
+	}
+
+	// reset failures of the login
+	if err := bHandler.guard.SucceedLogin(ctx, u.Login, ip); err != nil {
+		logger.Error("reset login failures error", zap.Error(err))
+	}
+
+	// validate new password
+	if pc.NewPassword == pc.CurrentPassword {
+		w.WriteHeader(http.StatusBadRequest) // 400
+		return
+	}
+	if err := bHandler.passwords.Validate(u.Login, pc.NewPassword); err != nil {
+		logger.Error("invalid new password", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest) // 400
+		return
+	}
+
+	// hash password
+	hash, err := bHandler.hasher.GetHash(pc.NewPassword)
+	if err != nil {
+		logger.Error("get password hash error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// change password and revoke tokens
+	changedAt, err := bHandler.storage.ChangePassword(ctx, u.UserID, hash)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
+		logger.Error("change password error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// tokens issued before the change are revoked by this replica at once
+	bHandler.passwordCache.set(u.UserID, changedAt)
+	user.PasswordChangedAt = changedAt
+
+	// generate auth tokens of the new session
+	if err := bHandler.issueTokens(ctx, w, user); err != nil {
+		logger.Error("issue tokens error", zap.Error(err))
+	}
+}
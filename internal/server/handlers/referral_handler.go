@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/internal/server/auth"
+	"github.com/SerjRamone/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Referrals is "GET /api/user/referrals" handler
+func (bHandler baseHandler) Referrals(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	rs, err := bHandler.storage.GetReferrals(ctx, u.UserID)
+	if err != nil {
+		// user is deleted after token was issued
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
+		logger.Error("get user's referrals error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// marshal referrals
+	b, err := json.Marshal(rs)
+	if err != nil {
+		logger.Error("marshal referrals error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// write response
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(b); err != nil {
+		logger.Error("write response error", zap.Error(err))
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"net/http"
 
 	"github.com/SerjRamone/gophermart/internal/models"
-	"github.com/SerjRamone/gophermart/internal/server/middlewares"
 	"github.com/SerjRamone/gophermart/pkg/logger"
 	"go.uber.org/zap"
 )
@@ -20,6 +19,13 @@ func (bHandler baseHandler) Register(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
+	// validate login and password
+	if err := bHandler.validateCredentials(uf); err != nil {
+		logger.Error("invalid credentials", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	// hash password
 	uf.Password, err = bHandler.hasher.GetHash(uf.Password)
 	if err != nil {
@@ -29,29 +35,31 @@ func (bHandler baseHandler) Register(ctx context.Context, w http.ResponseWriter,
 	}
 
 	// create user
-	if _, err = bHandler.storage.CreateUser(ctx, *uf); err != nil {
+	u, err := bHandler.storage.CreateUser(ctx, *uf)
+	if err != nil {
 		if errors.Is(err, models.ErrUserAlreadyExists) {
 			logger.Error("login is already exists", zap.Error(err))
 			w.WriteHeader(http.StatusConflict)
 			return
 		}
+		if errors.Is(err, models.ErrReferralNotExists) {
+			logger.Error("unknown referral code", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, models.ErrReferralLimitReached) {
+			logger.Error("referrals limit is reached", zap.Error(err))
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
 
 		logger.Error("failed add user in the /register request", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// w.WriteHeader(http.StatusInternalServerError)
-	// return
-
-	// generate auth token
-	token, err := middlewares.GenerateJWT(bHandler.secret, uf.Login, bHandler.tokenExpr)
-	if err != nil {
-		logger.Error("generate JWT error", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	// generate auth tokens
+	if err := bHandler.issueTokens(ctx, w, u); err != nil {
+		logger.Error("issue tokens error", zap.Error(err))
 	}
-
-	w.Header().Set("Authorization", token)
-	w.WriteHeader(http.StatusOK)
 }
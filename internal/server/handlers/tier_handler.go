@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/internal/server/auth"
+	"github.com/SerjRamone/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Tier is "GET /api/user/tier" handler
+func (bHandler baseHandler) Tier(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	ut, err := bHandler.storage.GetUserTier(ctx, u.UserID)
+	if err != nil {
+		// user is deleted after token was issued
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
+		logger.Error("get user's tier error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// marshal tier progress
+	b, err := json.Marshal(bHandler.tiers.Progress(*ut))
+	if err != nil {
+		logger.Error("marshal tier error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// write response
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(b); err != nil {
+		logger.Error("write response error", zap.Error(err))
+	}
+}
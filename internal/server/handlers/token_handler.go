@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/internal/server/auth"
+	"github.com/SerjRamone/gophermart/internal/server/middlewares"
+	"github.com/SerjRamone/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// tokens is the response to login, registration and tokens refresh
+type tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // access token lifetime in seconds
+}
+
+// refreshRequest is the refresh and logout request body
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken is "POST /api/user/token/refresh" handler
+func (bHandler baseHandler) RefreshToken(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// get refresh token from request
+	token, err := getRefreshToken(w, r)
+	if err != nil {
+		logger.Error("get refresh token error", zap.Error(err))
+		return
+	}
+
+	refresh, err := models.NewRefreshToken()
+	if err != nil {
+		logger.Error("refresh token generation error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// rotate refresh token
+	u, err := bHandler.storage.RotateRefreshToken(ctx, models.HashRefreshToken(token), models.HashRefreshToken(refresh), bHandler.refreshTTL)
+	if err != nil {
+		if errors.Is(err, models.ErrRefreshTokenInvalid) || errors.Is(err, models.ErrRefreshTokenReused) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
+		logger.Error("rotate refresh token error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	access, err := middlewares.GenerateJWT(bHandler.keys, u.ID, u.Login, u.PasswordChangedAt, bHandler.tokenExpr)
+	if err != nil {
+		logger.Error("generate JWT error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	bHandler.writeTokens(w, access, refresh)
+}
+
+// Logout is "POST /api/user/logout" handler, revokes refresh tokens of the session
+func (bHandler baseHandler) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	// get refresh token from request
+	token, err := getRefreshToken(w, r)
+	if err != nil {
+		logger.Error("get refresh token error", zap.Error(err))
+		return
+	}
+
+	if err := bHandler.storage.RevokeRefreshToken(ctx, u.UserID, models.HashRefreshToken(token)); err != nil {
+		// user is deleted after token was issued
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
+		logger.Error("revoke refresh token error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// LogoutAll is "POST /api/user/logout-all" handler, revokes refresh tokens of all user's sessions
+func (bHandler baseHandler) LogoutAll(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	if err := bHandler.storage.RevokeRefreshTokens(ctx, u.UserID); err != nil {
+		// user is deleted after token was issued
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
+		logger.Error("revoke refresh tokens error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// issueTokens starts a new session of the user: writes access token and the first refresh token of a new family
+func (bHandler baseHandler) issueTokens(ctx context.Context, w http.ResponseWriter, u *models.User) error {
+	access, err := middlewares.GenerateJWT(bHandler.keys, u.ID, u.Login, u.PasswordChangedAt, bHandler.tokenExpr)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return fmt.Errorf("generate JWT error: %w", err)
+	}
+
+	refresh, err := models.NewRefreshToken()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return err
+	}
+	if err := bHandler.storage.CreateRefreshToken(ctx, u.ID, models.HashRefreshToken(refresh), bHandler.refreshTTL); err != nil {
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return err
+	}
+
+	bHandler.writeTokens(w, access, refresh)
+	return nil
+}
+
+// writeTokens writes access token to Authorization header and both tokens to response body
+func (bHandler baseHandler) writeTokens(w http.ResponseWriter, access, refresh string) {
+	b, err := json.Marshal(tokens{AccessToken: access, RefreshToken: refresh, ExpiresIn: bHandler.tokenExpr})
+	if err != nil {
+		logger.Error("marshal tokens error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	w.Header().Set("Authorization", access)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err = w.Write(b); err != nil {
+		logger.Error("write response error", zap.Error(err))
+	}
+}
+
+// getRefreshToken returns refresh token from request body or error
+func getRefreshToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	var rr refreshRequest
+
+	// read requst body
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return "", fmt.Errorf("request body read error: %w", err)
+	}
+
+	// unmarshal body
+	if err := json.Unmarshal(b, &rr); err != nil {
+		w.WriteHeader(http.StatusBadRequest) // 400
+		return "", fmt.Errorf("unmarshalling error: %w", err)
+	}
+
+	// token is required
+	if rr.RefreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest) // 400
+		return "", errors.New("refresh token is empty")
+	}
+
+	return rr.RefreshToken, nil
+}
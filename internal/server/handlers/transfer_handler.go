@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/internal/server/auth"
+	"github.com/SerjRamone/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// transferDirections all transfer directions for list filter
+var transferDirections = []string{
+	models.TransferDirectionIn,
+	models.TransferDirectionOut,
+}
+
+type transfer struct {
+	Login  string        `json:"login"`
+	Amount models.Points `json:"amount"`
+}
+
+// Transfer is "POST /api/user/balance/transfer" handler
+func (bHandler baseHandler) Transfer(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	tr := transfer{}
+
+	// read request body
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Error("read /transfer request body error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// unmarshal body
+	if err := json.Unmarshal(b, &tr); err != nil || tr.Login == "" {
+		logger.Error("unmarshal transfer body error", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest) // 400
+		return
+	}
+
+	// validate amount
+	if tr.Amount <= 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity) // 422
+		return
+	}
+
+	// transfer points
+	t, err := bHandler.storage.CreateTransfer(ctx, u.UserID, tr.Login, tr.Amount)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecipientNotExists):
+			w.WriteHeader(http.StatusNotFound) // 404
+		case errors.Is(err, models.ErrUserNotExists):
+			w.WriteHeader(http.StatusUnauthorized) // 401
+		case errors.Is(err, models.ErrTransferToSelf):
+			w.WriteHeader(http.StatusBadRequest) // 400
+		case errors.Is(err, models.ErrNotEnoughPoints):
+			w.WriteHeader(http.StatusPaymentRequired) // 402
+		case errors.Is(err, models.ErrTransferLimitExceeded):
+			w.WriteHeader(http.StatusUnprocessableEntity) // 422
+		default:
+			logger.Error("create transfer error", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError) // 500
+		}
+		return
+	}
+
+	// marshal transfer
+	b, err = json.Marshal(t)
+	if err != nil {
+		logger.Error("marshal transfer error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(b); err != nil {
+		logger.Error("write response error", zap.Error(err))
+	}
+}
+
+// Transfers is "GET /api/user/balance/transfers" handler
+func (bHandler baseHandler) Transfers(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	// get list params, direction is filtered by status param
+	q, err := parseListQuery(r, transferDirections, true)
+	if err != nil {
+		logger.Error("parse transfers list query error", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest) // 400
+		return
+	}
+
+	// get one extra transfer to know if there is the next page
+	limit := q.Limit
+	if limit > 0 {
+		q.Limit++
+	}
+
+	// get models from storage
+	transfers, err := bHandler.storage.GetTransfers(ctx, u.UserID, q)
+	if err != nil {
+		// user is deleted after token was issued
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
+		logger.Error("get transfers list error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// check `no content`
+	if len(transfers) == 0 {
+		w.WriteHeader(http.StatusNoContent) // 204
+		return
+	}
+
+	// link to the next page
+	if limit > 0 && len(transfers) > limit {
+		transfers = transfers[:limit]
+		last := transfers[limit-1]
+		setNextPage(w, r, models.Cursor{At: last.CreatedAt, ID: last.ID})
+	}
+
+	// marshal list
+	b, err := json.Marshal(&transfers)
+	if err != nil {
+		logger.Error("marshal transfers list error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(b); err != nil {
+		logger.Error("write response error", zap.Error(err))
+	}
+}
@@ -8,22 +8,38 @@ import (
 	"net/http"
 
 	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/SerjRamone/gophermart/internal/server/auth"
 	"github.com/SerjRamone/gophermart/pkg/logger"
+	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
 
+// withdrawalStatuses all withdrawal statuses for list filter
+var withdrawalStatuses = []string{
+	models.WithdrawalStatusPending,
+	models.WithdrawalStatusCompleted,
+	models.WithdrawalStatusCancelled,
+}
+
 type withdrawal struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+	Order string        `json:"order"`
+	Sum   models.Points `json:"sum"`
+}
+
+// withdrawalConflict is the response to withdrawal with already used order number.
+// Amount of another user's withdrawal isn't disclosed
+type withdrawalConflict struct {
+	Error string         `json:"error"`
+	Own   bool           `json:"own"`
+	Sum   *models.Points `json:"sum,omitempty"`
 }
 
 // Balance is "GET /api/user/balance/withdraw" handler
 func (bHandler baseHandler) Withdraw(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	// get user from token
-	u, err := bHandler.getUserFromToken(r)
-	if err != nil {
-		logger.Error("get user from token error", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
 		return
 	}
 
@@ -44,38 +60,96 @@ func (bHandler baseHandler) Withdraw(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
-	// validate order number
+	// validate order number and sum
 	of := models.OrderForm{Number: wd.Order}
-	if !of.IsValidNumber() {
+	if !of.IsValidNumber() || wd.Sum <= 0 {
 		w.WriteHeader(http.StatusUnprocessableEntity) // 422
 		return
 	}
 
 	// create withdrawal
-	if err := bHandler.storage.CreateWithdrawal(ctx, u.ID, wd.Order, wd.Sum); err != nil {
+	if err := bHandler.storage.CreateWithdrawal(ctx, u.UserID, wd.Order, wd.Sum); err != nil {
 		if errors.Is(err, models.ErrNotEnoughPoints) {
 			w.WriteHeader(http.StatusPaymentRequired) // 402
 			return
 		}
+		if errors.Is(err, models.ErrWithdrawalAlreadyExists) {
+			bHandler.withdrawalConflict(ctx, w, u.UserID, wd.Order)
+			return
+		}
+		// user is deleted after token was issued
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
 		logger.Error("creaet withdrawal error", zap.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 }
 
+// withdrawalConflict writes 409 response about existing withdrawal with the order number
+func (bHandler baseHandler) withdrawalConflict(ctx context.Context, w http.ResponseWriter, userID, number string) {
+	// try to get withdrawal from storage
+	existing, err := bHandler.storage.GetWithdrawal(ctx, number)
+	if err != nil {
+		logger.Error("withdrawal get error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	resp := withdrawalConflict{Error: models.ErrWithdrawalAlreadyExists.Error()}
+	// check if withdrawal by another user
+	if existing.UserID == userID {
+		resp.Own = true
+		resp.Sum = &existing.Total
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("marshal withdrawal conflict error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict) // 409
+	if _, err = w.Write(b); err != nil {
+		logger.Error("write response error", zap.Error(err))
+	}
+}
+
 // Balance is "GET /api/user/balance/withdrawals" handler
 func (bHandler baseHandler) Withdrawals(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	// get user from token
-	u, err := bHandler.getUserFromToken(r)
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	// get list params
+	q, err := parseListQuery(r, withdrawalStatuses, true)
 	if err != nil {
-		logger.Error("get user from token error", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+		logger.Error("parse withdrawals list query error", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	// get one extra withdrawal to know if there is the next page
+	limit := q.Limit
+	if limit > 0 {
+		q.Limit++
+	}
+
 	// get models from storage
-	withdrwls, err := bHandler.storage.GetWithdrawals(ctx, u.ID)
+	withdrwls, err := bHandler.storage.GetWithdrawals(ctx, u.UserID, q)
 	if err != nil {
+		// user is deleted after token was issued
+		if errors.Is(err, models.ErrUserNotExists) {
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
 		logger.Error("get withdrawals list error", zap.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -87,6 +161,13 @@ func (bHandler baseHandler) Withdrawals(ctx context.Context, w http.ResponseWrit
 		return
 	}
 
+	// link to the next page
+	if limit > 0 && len(withdrwls) > limit {
+		withdrwls = withdrwls[:limit]
+		last := withdrwls[limit-1]
+		setNextPage(w, r, models.Cursor{At: last.CreatedAt, ID: last.ID})
+	}
+
 	// marshal list
 	b, err := json.Marshal(&withdrwls)
 	if err != nil {
@@ -103,3 +184,51 @@ func (bHandler baseHandler) Withdrawals(ctx context.Context, w http.ResponseWrit
 		return
 	}
 }
+
+// CancelWithdrawal is "POST /api/user/withdrawals/{order}/cancel" handler
+func (bHandler baseHandler) CancelWithdrawal(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// get authenticated user
+	u, ok := auth.FromContext(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		return
+	}
+
+	// validate order number
+	of := models.OrderForm{Number: chi.URLParam(r, "order")}
+	if !of.IsValidNumber() {
+		w.WriteHeader(http.StatusNotFound) // 404
+		return
+	}
+
+	// cancel and refund
+	wd, err := bHandler.storage.CancelWithdrawal(ctx, u.UserID, of.Number, bHandler.cancelWindow)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrWithdrawalNotExists):
+			w.WriteHeader(http.StatusNotFound) // 404
+		case errors.Is(err, models.ErrWithdrawalNotCancellable):
+			w.WriteHeader(http.StatusConflict) // 409
+		case errors.Is(err, models.ErrUserNotExists):
+			w.WriteHeader(http.StatusUnauthorized) // 401
+		default:
+			logger.Error("cancel withdrawal error", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError) // 500
+		}
+		return
+	}
+
+	// marshal withdrawal
+	b, err := json.Marshal(wd)
+	if err != nil {
+		logger.Error("marshal withdrawal error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return
+	}
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(b); err != nil {
+		logger.Error("write response error", zap.Error(err))
+	}
+}
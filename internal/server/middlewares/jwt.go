@@ -2,31 +2,64 @@
 package middlewares
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// Claims is a custom JWT Claims Set
+// Claims is a custom JWT Claims Set, user ID is the subject
 type Claims struct {
 	Login string
+	// PasswordChangedAt user's password change the token is issued after, in milliseconds since epoch
+	PasswordChangedAt int64 `json:"pwd_changed_at,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT ...
-func GenerateJWT(secret []byte, login string, tokenExperation int) (string, error) {
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+// GenerateJWT returns access token of the user signed by the active key of the key set.
+// Password change date is the one the user has when the token is issued, zero if it has never been changed
+func GenerateJWT(keys *KeySet, userID, login string, passwordChangedAt int64, tokenExperation int) (string, error) {
+	now := time.Now()
+	token, err := keys.Sign(Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(tokenExperation) * time.Second)),
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(tokenExperation) * time.Second)),
 		},
-		Login: login,
+		Login:             login,
+		PasswordChangedAt: passwordChangedAt,
 	})
-
-	token, err := t.SignedString(secret)
 	if err != nil {
 		return "", fmt.Errorf("token SignedString error: %w", err)
 	}
 
 	return token, nil
 }
+
+// ParseJWT returns claims of valid token with subject signed by a key of the key set
+func ParseJWT(keys *KeySet, token string) (*Claims, error) {
+	claims := &Claims{}
+
+	// parse claims to struct
+	t, err := jwt.ParseWithClaims(token, claims, keys.keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("token parse error: %w", err)
+	}
+	if !t.Valid {
+		return nil, errors.New("token is not valid")
+	}
+
+	// tokens issued before user ID was put in subject
+	if claims.Subject == "" {
+		return nil, errors.New("token subject is empty")
+	}
+
+	return claims, nil
+}
+
+// RevokedBy reports whether token is issued before the user's password change, in milliseconds since epoch.
+// Both dates are taken from the storage, so replicas clocks don't matter
+func (c Claims) RevokedBy(passwordChangedAt int64) bool {
+	return c.PasswordChangedAt < passwordChangedAt
+}
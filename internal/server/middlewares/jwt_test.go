@@ -0,0 +1,44 @@
+package middlewares
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaims_RevokedBy(t *testing.T) {
+	tests := []struct {
+		name              string
+		tokenChangedAt    int64
+		passwordChangedAt int64
+		want              bool
+	}{
+		{
+			name: "Test#1. Password has never been changed",
+			want: false,
+		},
+		{
+			name:              "Test#2. Token issued before the first change",
+			passwordChangedAt: 1700000000001,
+			want:              true,
+		},
+		{
+			name:              "Test#3. Token issued before the last change",
+			tokenChangedAt:    1700000000000,
+			passwordChangedAt: 1700000000001,
+			want:              true,
+		},
+		{
+			name:              "Test#4. Token issued after the last change",
+			tokenChangedAt:    1700000000001,
+			passwordChangedAt: 1700000000001,
+			want:              false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Claims{PasswordChangedAt: tt.tokenChangedAt}
+			require.Equal(t, tt.want, c.RevokedBy(tt.passwordChangedAt))
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package middlewares
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyFileExt extension of PEM key files in keys directory
+const keyFileExt = ".pem"
+
+var (
+	// ErrNoSigningKey neither keys directory nor secret is configured error
+	ErrNoSigningKey = errors.New("no JWT signing key")
+)
+
+// verificationKey token verification key with the only algorithm allowed for it
+type verificationKey struct {
+	method jwt.SigningMethod
+	key    any
+}
+
+// KeySet is a set of JWT keys identified by kid. Tokens are signed by the active key,
+// verified by any key of the set, so tokens signed by rotated out keys stay valid
+// while their public keys are kept in keys directory
+type KeySet struct {
+	keys      map[string]verificationKey
+	activeKID string
+	active    crypto.Signer // nil if tokens are signed by HMAC secret
+	secret    []byte        // HS256 secret, verifies tokens without kid
+}
+
+// NewHMACKeySet returns key set signing and verifying tokens by HS256 secret
+func NewHMACKeySet(secret []byte) *KeySet {
+	return &KeySet{keys: map[string]verificationKey{}, secret: secret}
+}
+
+// NewKeySet returns key set of keys loaded from directory, if it's set, with HS256 secret fallback.
+// Each file `<kid>.pem` of the directory keeps PKCS#8 RSA or Ed25519 private key or PKIX public key
+// of retired key. The last private key in kid order is the active one.
+// Secret verifies tokens issued without kid, and signs tokens if directory isn't set
+func NewKeySet(dir string, secret []byte) (*KeySet, error) {
+	ks := NewHMACKeySet(secret)
+	if dir == "" {
+		if len(secret) == 0 {
+			return nil, ErrNoSigningKey
+		}
+		return ks, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+keyFileExt))
+	if err != nil {
+		return nil, fmt.Errorf("keys directory read error: %w", err)
+	}
+	sort.Strings(files)
+
+	for _, f := range files {
+		kid := strings.TrimSuffix(filepath.Base(f), keyFileExt)
+		if err := ks.load(kid, f); err != nil {
+			return nil, err
+		}
+	}
+	if ks.active == nil {
+		return nil, fmt.Errorf("%w: no private key in %s", ErrNoSigningKey, dir)
+	}
+
+	return ks, nil
+}
+
+// load adds the key file to the set, private key becomes the active one
+func (ks *KeySet) load(kid, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("key file read error: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return fmt.Errorf("key %s: no PEM data", kid)
+	}
+
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return fmt.Errorf("key %s: unsupported PEM block %q", kid, block.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("key %s parse error: %w", kid, err)
+	}
+
+	// private key signs tokens, its public part verifies them
+	if signer, ok := key.(crypto.Signer); ok {
+		ks.active, ks.activeKID = signer, kid
+		key = signer.Public()
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		ks.keys[kid] = verificationKey{method: jwt.SigningMethodRS256, key: k}
+	case ed25519.PublicKey:
+		ks.keys[kid] = verificationKey{method: jwt.SigningMethodEdDSA, key: k}
+	default:
+		return fmt.Errorf("key %s: unsupported key type %T", kid, key)
+	}
+
+	return nil
+}
+
+// Sign returns token with claims signed by the active key
+func (ks *KeySet) Sign(claims Claims) (string, error) {
+	if ks.active == nil {
+		if len(ks.secret) == 0 {
+			return "", ErrNoSigningKey
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.secret)
+	}
+
+	t := jwt.NewWithClaims(ks.keys[ks.activeKID].method, claims)
+	t.Header["kid"] = ks.activeKID
+	return t.SignedString(ks.active)
+}
+
+// keyFunc returns verification key by token kid, token must be signed by the key algorithm
+func (ks *KeySet) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok || len(ks.secret) == 0 {
+			return nil, fmt.Errorf("invalid signing alg method: %v", t.Header["alg"])
+		}
+		return ks.secret, nil
+	}
+
+	vk, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if t.Method.Alg() != vk.method.Alg() {
+		return nil, fmt.Errorf("invalid signing alg method: %v", t.Header["alg"])
+	}
+	return vk.key, nil
+}
+
+// JWK is a public JSON Web Key
+type JWK struct {
+	KTY string `json:"kty"`
+	KID string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns public keys of the set in kid order. HS256 secret is never published
+func (ks *KeySet) JWKS() JWKS {
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKS{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		vk := ks.keys[kid]
+		jwk := JWK{KID: kid, Use: "sig", Alg: vk.method.Alg()}
+		switch k := vk.key.(type) {
+		case *rsa.PublicKey:
+			jwk.KTY = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KTY = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
@@ -0,0 +1,140 @@
+package middlewares
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKey writes PKCS#8 private key or PKIX public key of the key to `<kid>.pem`
+func writeKey(t *testing.T, dir, kid string, key any, private bool) {
+	t.Helper()
+
+	var (
+		b   []byte
+		typ = "PRIVATE KEY"
+		err error
+	)
+	if private {
+		b, err = x509.MarshalPKCS8PrivateKey(key)
+	} else {
+		typ = "PUBLIC KEY"
+		b, err = x509.MarshalPKIXPublicKey(key)
+	}
+	require.NoError(t, err)
+
+	f := filepath.Join(dir, kid+keyFileExt)
+	require.NoError(t, os.WriteFile(f, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0o600))
+}
+
+func TestNewKeySet(t *testing.T) {
+	_, err := NewKeySet("", nil)
+	require.ErrorIs(t, err, ErrNoSigningKey)
+
+	_, err = NewKeySet(t.TempDir(), []byte("supersecret"))
+	require.ErrorIs(t, err, ErrNoSigningKey)
+
+	ks, err := NewKeySet("", []byte("supersecret"))
+	require.NoError(t, err)
+	require.Empty(t, ks.JWKS().Keys)
+
+	_, err = NewHMACKeySet(nil).Sign(Claims{})
+	require.ErrorIs(t, err, ErrNoSigningKey)
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	secret := []byte("supersecret")
+	dir := t.TempDir()
+
+	// HS256 tokens issued before keys directory was set
+	hmacToken, err := GenerateJWT(NewHMACKeySet(secret), "1", "user", 0, 60)
+	require.NoError(t, err)
+
+	// RSA key is active
+	writeKey(t, dir, "2023-01", rsaKey, true)
+	ks, err := NewKeySet(dir, secret)
+	require.NoError(t, err)
+	rsaToken, err := GenerateJWT(ks, "1", "user", 0, 60)
+	require.NoError(t, err)
+
+	// RSA key is retired, Ed25519 key is active
+	writeKey(t, dir, "2023-01", rsaKey.Public(), false)
+	writeKey(t, dir, "2023-02", edKey, true)
+	ks, err = NewKeySet(dir, secret)
+	require.NoError(t, err)
+	edToken, err := GenerateJWT(ks, "1", "user", 0, 60)
+	require.NoError(t, err)
+
+	t.Run("Test#1. Tokens of all keys are valid", func(t *testing.T) {
+		for _, token := range []string{hmacToken, rsaToken, edToken} {
+			claims, err := ParseJWT(ks, token)
+			require.NoError(t, err)
+			require.Equal(t, "1", claims.Subject)
+			require.Equal(t, "user", claims.Login)
+		}
+	})
+
+	t.Run("Test#2. Active key is identified by kid", func(t *testing.T) {
+		parsed, _, err := jwt.NewParser().ParseUnverified(edToken, &Claims{})
+		require.NoError(t, err)
+		require.Equal(t, "2023-02", parsed.Header["kid"])
+		require.Equal(t, "EdDSA", parsed.Method.Alg())
+	})
+
+	t.Run("Test#3. Token without kid is invalid without secret", func(t *testing.T) {
+		noSecret, err := NewKeySet(dir, nil)
+		require.NoError(t, err)
+		_, err = ParseJWT(noSecret, hmacToken)
+		require.Error(t, err)
+		_, err = ParseJWT(noSecret, edToken)
+		require.NoError(t, err)
+	})
+
+	t.Run("Test#4. Token of removed key is invalid", func(t *testing.T) {
+		require.NoError(t, os.Remove(filepath.Join(dir, "2023-01"+keyFileExt)))
+		ks, err := NewKeySet(dir, secret)
+		require.NoError(t, err)
+		_, err = ParseJWT(ks, rsaToken)
+		require.Error(t, err)
+	})
+
+	t.Run("Test#5. Key algorithm can't be changed", func(t *testing.T) {
+		// HS256 token signed by public key bytes with RSA key kid
+		tok := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: "1"},
+		})
+		tok.Header["kid"] = "2023-02"
+		s, err := tok.SignedString([]byte(edKey.Public().(ed25519.PublicKey)))
+		require.NoError(t, err)
+		_, err = ParseJWT(ks, s)
+		require.Error(t, err)
+	})
+
+	t.Run("Test#6. JWKS publishes public keys", func(t *testing.T) {
+		writeKey(t, dir, "2023-01", rsaKey.Public(), false)
+		ks, err := NewKeySet(dir, secret)
+		require.NoError(t, err)
+
+		set := ks.JWKS()
+		require.Len(t, set.Keys, 2)
+		require.Equal(t, JWK{KTY: "RSA", KID: "2023-01", Use: "sig", Alg: "RS256", N: set.Keys[0].N, E: "AQAB"}, set.Keys[0])
+		require.NotEmpty(t, set.Keys[0].N)
+		require.Equal(t, "OKP", set.Keys[1].KTY)
+		require.Equal(t, "Ed25519", set.Keys[1].Crv)
+		require.Equal(t, "EdDSA", set.Keys[1].Alg)
+		require.NotEmpty(t, set.Keys[1].X)
+	})
+}
@@ -11,12 +11,17 @@ import (
 )
 
 // NewRouter returns chi.Router
-func NewRouter(secret []byte, tokenExpr int, storage handlers.Storage) chi.Router {
+func NewRouter(keys *middlewares.KeySet, tokenExpr int, storage handlers.Storage, opts ...handlers.Option) chi.Router {
 	hasher := security.NewHasher()
-	baseHandler := handlers.NewBaseHandler(secret, tokenExpr, storage, hasher)
+	baseHandler := handlers.NewBaseHandler(keys, tokenExpr, storage, hasher, opts...)
 	mux := chi.NewRouter()
 	mux.Use(middlewares.RequestLogger)
 
+	// public keys to verify access tokens
+	mux.Get("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		baseHandler.JWKS(r.Context(), w, r)
+	})
+
 	// api
 	mux.Route("/api/user", func(r chi.Router) {
 		r.Post("/register", func(w http.ResponseWriter, r *http.Request) {
@@ -27,26 +32,62 @@ func NewRouter(secret []byte, tokenExpr int, storage handlers.Storage) chi.Route
 			baseHandler.Login(r.Context(), w, r)
 		})
 
+		r.Post("/token/refresh", func(w http.ResponseWriter, r *http.Request) {
+			baseHandler.RefreshToken(r.Context(), w, r)
+		})
+
 		r.Group(func(r chi.Router) {
 			r.Use(baseHandler.JWTMiddleware)
 
-			r.Post("/orders", func(w http.ResponseWriter, r *http.Request) {
+			r.Post("/logout", func(w http.ResponseWriter, r *http.Request) {
+				baseHandler.Logout(r.Context(), w, r)
+			})
+			r.Post("/logout-all", func(w http.ResponseWriter, r *http.Request) {
+				baseHandler.LogoutAll(r.Context(), w, r)
+			})
+			r.Post("/password", func(w http.ResponseWriter, r *http.Request) {
+				baseHandler.ChangePassword(r.Context(), w, r)
+			})
+
+			r.With(baseHandler.IdempotencyMiddleware).Post("/orders", func(w http.ResponseWriter, r *http.Request) {
 				baseHandler.PostOrder(r.Context(), w, r)
 			})
 			r.Get("/orders", func(w http.ResponseWriter, r *http.Request) {
 				baseHandler.GetOrder(r.Context(), w, r)
 			})
+			r.Get("/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+				baseHandler.GetOrderByNumber(r.Context(), w, r)
+			})
+			r.Get("/orders/{number}/history", func(w http.ResponseWriter, r *http.Request) {
+				baseHandler.OrderHistory(r.Context(), w, r)
+			})
 
 			r.Get("/balance", func(w http.ResponseWriter, r *http.Request) {
 				baseHandler.Balance(r.Context(), w, r)
 			})
-			r.Post("/balance/withdraw", func(w http.ResponseWriter, r *http.Request) {
+			r.With(baseHandler.IdempotencyMiddleware).Post("/balance/withdraw", func(w http.ResponseWriter, r *http.Request) {
 				baseHandler.Withdraw(r.Context(), w, r)
 			})
+			r.With(baseHandler.IdempotencyMiddleware).Post("/balance/transfer", func(w http.ResponseWriter, r *http.Request) {
+				baseHandler.Transfer(r.Context(), w, r)
+			})
+			r.Get("/balance/transfers", func(w http.ResponseWriter, r *http.Request) {
+				baseHandler.Transfers(r.Context(), w, r)
+			})
+
+			r.Get("/tier", func(w http.ResponseWriter, r *http.Request) {
+				baseHandler.Tier(r.Context(), w, r)
+			})
+			r.Get("/referrals", func(w http.ResponseWriter, r *http.Request) {
+				baseHandler.Referrals(r.Context(), w, r)
+			})
 
 			r.Get("/withdrawals", func(w http.ResponseWriter, r *http.Request) {
 				baseHandler.Withdrawals(r.Context(), w, r)
 			})
+			r.Post("/withdrawals/{order}/cancel", func(w http.ResponseWriter, r *http.Request) {
+				baseHandler.CancelWithdrawal(r.Context(), w, r)
+			})
 		})
 	})
 
@@ -0,0 +1,125 @@
+package security
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+)
+
+// loginFailures failures counter of login or client IP
+type loginFailures struct {
+	count       int
+	failedAt    time.Time
+	lockedUntil time.Time
+}
+
+// loginGuard in-memory login guard for single node, failures are lost on restart
+type loginGuard struct {
+	mu       sync.Mutex
+	policy   models.LoginPolicy
+	failures map[string]*loginFailures
+	sweepAt  time.Time
+	now      func() time.Time
+}
+
+// NewLoginGuard returns in-memory login guard
+func NewLoginGuard(policy models.LoginPolicy) *loginGuard {
+	return &loginGuard{
+		policy:   policy,
+		failures: map[string]*loginFailures{},
+		now:      time.Now,
+	}
+}
+
+// AttemptLogin counts attempt of the login and the IP before password is checked, so concurrent
+// attempts can't pass the check together. Returns time left until they are unlocked
+// if the attempt is rejected, zero if it's allowed
+func (g *loginGuard) AttemptLogin(_ context.Context, login, ip string) (time.Duration, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.sweep(now)
+
+	keys := models.LoginGuardKeys(login, ip)
+
+	// rejected attempt isn't counted
+	var wait time.Duration
+	for _, key := range keys {
+		if f, ok := g.failures[key]; ok {
+			wait = max(wait, f.lockedUntil.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return wait, nil
+	}
+
+	// count attempt as failure until it succeeds
+	for _, key := range keys {
+		f, ok := g.failures[key]
+		if !ok || now.Sub(f.failedAt) > g.policy.MaxLockout {
+			f = &loginFailures{}
+			g.failures[key] = f
+		}
+		f.count++
+		f.failedAt = now
+		f.lockedUntil = now.Add(g.policy.Lockout(key, f.count))
+	}
+
+	return 0, nil
+}
+
+// FailLogin returns lockout caused by failed attempt, the attempt is already counted
+func (g *loginGuard) FailLogin(_ context.Context, login, ip string) (time.Duration, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	var lockout time.Duration
+	for _, key := range models.LoginGuardKeys(login, ip) {
+		if f, ok := g.failures[key]; ok {
+			lockout = max(lockout, f.lockedUntil.Sub(now))
+		}
+	}
+
+	return lockout, nil
+}
+
+// SucceedLogin resets failures of the login and uncounts the attempt of the IP,
+// other failures of the IP are kept
+func (g *loginGuard) SucceedLogin(_ context.Context, login, ip string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.failures, models.LoginGuardKey(login))
+
+	key := models.IPGuardKey(ip)
+	if f, ok := g.failures[key]; ok && f.count > 0 {
+		f.count--
+		f.lockedUntil = minTime(f.lockedUntil, f.failedAt.Add(g.policy.Lockout(key, f.count)))
+	}
+	return nil
+}
+
+// sweep deletes forgotten failures once per max lockout
+func (g *loginGuard) sweep(now time.Time) {
+	if now.Before(g.sweepAt) {
+		return
+	}
+	for key, f := range g.failures {
+		if now.Sub(f.failedAt) > g.policy.MaxLockout {
+			delete(g.failures, key)
+		}
+	}
+	g.sweepAt = now.Add(g.policy.MaxLockout)
+}
+
+// minTime returns the earliest of the times
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
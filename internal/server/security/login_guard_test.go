@@ -0,0 +1,95 @@
+package security
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SerjRamone/gophermart/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginGuard(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	g := NewLoginGuard(models.LoginPolicy{MaxAttempts: 1, IPMaxAttempts: 10, Delay: time.Minute, MaxLockout: time.Hour})
+	g.now = func() time.Time { return now }
+
+	// attempt fails
+	fail := func() time.Duration {
+		wait, err := g.AttemptLogin(ctx, "user", "127.0.0.1")
+		require.NoError(t, err)
+		require.Zero(t, wait)
+		lockout, err := g.FailLogin(ctx, "user", "127.0.0.1")
+		require.NoError(t, err)
+		return lockout
+	}
+
+	// second failure locks the login out
+	require.Zero(t, fail())
+	require.Equal(t, time.Minute, fail())
+	wait, err := g.AttemptLogin(ctx, "user", "127.0.0.2")
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, wait)
+
+	// lockout is over, failures are still counted
+	now = now.Add(time.Minute)
+	require.Equal(t, 2*time.Minute, fail())
+
+	// failures are forgotten after max lockout
+	now = now.Add(2 * time.Hour)
+	require.Zero(t, fail())
+
+	// success resets the login
+	wait, err = g.AttemptLogin(ctx, "user", "127.0.0.1")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+	require.NoError(t, g.SucceedLogin(ctx, "user", "127.0.0.1"))
+	require.Zero(t, fail())
+
+	// successful attempts don't lock the IP out
+	for i := 0; i < 20; i++ {
+		wait, err = g.AttemptLogin(ctx, "other", "127.0.0.1")
+		require.NoError(t, err)
+		require.Zero(t, wait)
+		require.NoError(t, g.SucceedLogin(ctx, "other", "127.0.0.1"))
+	}
+}
+
+func TestLoginGuard_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	g := NewLoginGuard(models.LoginPolicy{MaxAttempts: 1, IPMaxAttempts: 10, Delay: time.Minute, MaxLockout: time.Hour})
+
+	// concurrent attempts pass as many as sequential ones
+	const workers = 20
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		allowed  int
+		rejected int
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			wait, err := g.AttemptLogin(ctx, "user", "127.0.0.1")
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				t.Error(err)
+			case wait == 0:
+				allowed++
+			default:
+				rejected++
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, 2, allowed)
+	require.Equal(t, workers-2, rejected)
+}
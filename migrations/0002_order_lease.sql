@@ -0,0 +1,24 @@
+-- +goose Up
+BEGIN;
+
+-- order ----------------------
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS locked_by VARCHAR(155);
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS order_unprocessed_idx ON "order" (uploaded_at ASC) WHERE status IN ('NEW', 'PROCESSING');
+
+COMMENT ON COLUMN "order".locked_by IS 'Accrual watcher instance which owns the order';
+COMMENT ON COLUMN "order".locked_until IS 'Order lease expiration date';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- order ----------------------
+DROP INDEX IF EXISTS order_unprocessed_idx;
+ALTER TABLE "order" DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE "order" DROP COLUMN IF EXISTS locked_by;
+
+COMMIT;
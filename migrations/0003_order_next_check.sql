@@ -0,0 +1,26 @@
+-- +goose Up
+BEGIN;
+
+-- order ----------------------
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS next_check_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+DROP INDEX IF EXISTS order_unprocessed_idx;
+CREATE INDEX IF NOT EXISTS order_unprocessed_idx ON "order" (next_check_at ASC) WHERE status IN ('NEW', 'PROCESSING');
+
+COMMENT ON COLUMN "order".attempts IS 'Accrual checks count without status change';
+COMMENT ON COLUMN "order".next_check_at IS 'Next accrual check date';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- order ----------------------
+DROP INDEX IF EXISTS order_unprocessed_idx;
+CREATE INDEX IF NOT EXISTS order_unprocessed_idx ON "order" (uploaded_at ASC) WHERE status IN ('NEW', 'PROCESSING');
+ALTER TABLE "order" DROP COLUMN IF EXISTS next_check_at;
+ALTER TABLE "order" DROP COLUMN IF EXISTS attempts;
+
+COMMIT;
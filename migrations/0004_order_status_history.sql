@@ -0,0 +1,38 @@
+-- +goose Up
+BEGIN;
+
+-- order_status_history ----------------------
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    order_id UUID NOT NULL REFERENCES "order" (id),
+    status_from order_status,
+    status_to order_status NOT NULL,
+    accrual DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_idx ON order_status_history (order_id, created_at ASC);
+
+COMMENT ON TABLE order_status_history IS 'Order status changes';
+
+COMMENT ON COLUMN order_status_history.id IS 'Unique record ID';
+COMMENT ON COLUMN order_status_history.order_id IS 'Order ID';
+COMMENT ON COLUMN order_status_history.status_from IS 'Previous order status, NULL for new order';
+COMMENT ON COLUMN order_status_history.status_to IS 'New order status';
+COMMENT ON COLUMN order_status_history.accrual IS 'Order accrual sum after change';
+COMMENT ON COLUMN order_status_history.created_at IS 'Status change date';
+
+-- existing orders start their history from the current status
+INSERT INTO order_status_history (order_id, status_to, accrual, created_at)
+SELECT id, status, accrual, uploaded_at FROM "order";
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- order_status_history ----------------------
+DROP TABLE IF EXISTS order_status_history CASCADE;
+
+COMMIT;
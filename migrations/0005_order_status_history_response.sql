@@ -0,0 +1,18 @@
+-- +goose Up
+BEGIN;
+
+-- order_status_history ----------------------
+ALTER TABLE order_status_history ADD COLUMN IF NOT EXISTS accrual_response JSONB;
+
+COMMENT ON COLUMN order_status_history.accrual_response IS 'Raw accrual service response caused the change';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- order_status_history ----------------------
+ALTER TABLE order_status_history DROP COLUMN IF EXISTS accrual_response;
+
+COMMIT;
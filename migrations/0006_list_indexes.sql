@@ -0,0 +1,22 @@
+-- +goose Up
+BEGIN;
+
+-- order ----------------------
+CREATE INDEX IF NOT EXISTS order_user_uploaded_at_idx ON "order" (user_id, uploaded_at ASC);
+
+-- withdrawal ----------------------
+CREATE INDEX IF NOT EXISTS withdrawal_user_created_at_idx ON withdrawal (user_id, created_at ASC);
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- withdrawal ----------------------
+DROP INDEX IF EXISTS withdrawal_user_created_at_idx;
+
+-- order ----------------------
+DROP INDEX IF EXISTS order_user_uploaded_at_idx;
+
+COMMIT;
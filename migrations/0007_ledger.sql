@@ -0,0 +1,81 @@
+-- +goose Up
+BEGIN;
+
+-- ledger_entry ----------------------
+DROP TYPE IF EXISTS ledger_entry_kind;
+CREATE TYPE ledger_entry_kind AS ENUM ('ACCRUAL', 'WITHDRAWAL', 'REVERSAL', 'ADJUSTMENT');
+
+CREATE TABLE IF NOT EXISTS ledger_entry (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    kind ledger_entry_kind NOT NULL,
+    from_account VARCHAR(64) NOT NULL,
+    to_account VARCHAR(64) NOT NULL,
+    amount DOUBLE PRECISION NOT NULL CHECK (amount > 0),
+    reference VARCHAR(155) NOT NULL,
+    reason VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (from_account <> to_account)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entry_from_account_idx ON ledger_entry (from_account, created_at ASC);
+CREATE INDEX IF NOT EXISTS ledger_entry_to_account_idx ON ledger_entry (to_account, created_at ASC);
+
+COMMENT ON TABLE ledger_entry IS 'Append-only points ledger, every entry moves points from one account to another';
+
+COMMENT ON COLUMN ledger_entry.id IS 'Unique entry ID';
+COMMENT ON COLUMN ledger_entry.kind IS 'Points movement kind';
+COMMENT ON COLUMN ledger_entry.from_account IS 'Account points are taken from: user:<id> or system:<name>';
+COMMENT ON COLUMN ledger_entry.to_account IS 'Account points are put to: user:<id> or system:<name>';
+COMMENT ON COLUMN ledger_entry.amount IS 'Points amount';
+COMMENT ON COLUMN ledger_entry.reference IS 'Order number the movement refers to';
+COMMENT ON COLUMN ledger_entry.reason IS 'Movement reason';
+COMMENT ON COLUMN ledger_entry.created_at IS 'Entry posting date';
+
+-- balance ----------------------
+CREATE TABLE IF NOT EXISTS balance (
+    user_id UUID PRIMARY KEY REFERENCES "user" (id),
+    current DOUBLE PRECISION NOT NULL DEFAULT 0,
+    withdrawn DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON TABLE balance IS 'User points balances maintained by ledger postings';
+
+COMMENT ON COLUMN balance.user_id IS 'User ID';
+COMMENT ON COLUMN balance.current IS 'Current points balance';
+COMMENT ON COLUMN balance.withdrawn IS 'Total withdrawn points';
+COMMENT ON COLUMN balance.updated_at IS 'Last posting date';
+
+-- move existing points movements to ledger
+INSERT INTO ledger_entry (kind, from_account, to_account, amount, reference, reason, created_at)
+SELECT 'ACCRUAL', 'system:accrual', 'user:' || user_id, accrual, number::TEXT, 'order processed', uploaded_at
+FROM "order"
+WHERE accrual > 0;
+
+INSERT INTO ledger_entry (kind, from_account, to_account, amount, reference, reason, created_at)
+SELECT 'WITHDRAWAL', 'user:' || user_id, 'system:withdrawal', total, number::TEXT, 'points withdrawal', created_at
+FROM withdrawal
+WHERE total > 0;
+
+INSERT INTO balance (user_id, current, withdrawn)
+SELECT
+    u.id,
+    COALESCE((SELECT SUM(o.accrual) FROM "order" o WHERE o.user_id = u.id), 0) -
+    COALESCE((SELECT SUM(w.total) FROM withdrawal w WHERE w.user_id = u.id), 0),
+    COALESCE((SELECT SUM(w.total) FROM withdrawal w WHERE w.user_id = u.id), 0)
+FROM "user" u;
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- balance ----------------------
+DROP TABLE IF EXISTS balance CASCADE;
+
+-- ledger_entry ----------------------
+DROP TABLE IF EXISTS ledger_entry CASCADE;
+DROP TYPE IF EXISTS ledger_entry_kind;
+
+COMMIT;
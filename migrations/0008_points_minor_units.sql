@@ -0,0 +1,58 @@
+-- +goose Up
+BEGIN;
+
+-- points amounts are stored as integer hundredths of a point,
+-- NUMERIC cast keeps exact decimal value of stored doubles, e.g. 729.98 is 72998
+
+-- order ----------------------
+ALTER TABLE "order" ALTER COLUMN accrual TYPE BIGINT USING ROUND(accrual::NUMERIC * 100)::BIGINT;
+ALTER TABLE "order" ALTER COLUMN accrual SET DEFAULT 0;
+
+COMMENT ON COLUMN "order".accrual IS 'Order accrual sum in hundredths of a point';
+
+-- withdrawal ----------------------
+ALTER TABLE withdrawal ALTER COLUMN total TYPE BIGINT USING ROUND(total::NUMERIC * 100)::BIGINT;
+
+COMMENT ON COLUMN withdrawal.total IS 'Withdrawal total sum in hundredths of a point';
+
+-- order_status_history ----------------------
+ALTER TABLE order_status_history ALTER COLUMN accrual TYPE BIGINT USING ROUND(accrual::NUMERIC * 100)::BIGINT;
+
+COMMENT ON COLUMN order_status_history.accrual IS 'Order accrual sum after change in hundredths of a point';
+
+-- ledger_entry ----------------------
+ALTER TABLE ledger_entry ALTER COLUMN amount TYPE BIGINT USING ROUND(amount::NUMERIC * 100)::BIGINT;
+
+COMMENT ON COLUMN ledger_entry.amount IS 'Points amount in hundredths of a point';
+
+-- balance ----------------------
+ALTER TABLE balance ALTER COLUMN current TYPE BIGINT USING ROUND(current::NUMERIC * 100)::BIGINT;
+ALTER TABLE balance ALTER COLUMN withdrawn TYPE BIGINT USING ROUND(withdrawn::NUMERIC * 100)::BIGINT;
+
+COMMENT ON COLUMN balance.current IS 'Current points balance in hundredths of a point';
+COMMENT ON COLUMN balance.withdrawn IS 'Total withdrawn points in hundredths of a point';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- balance ----------------------
+ALTER TABLE balance ALTER COLUMN withdrawn TYPE DOUBLE PRECISION USING withdrawn / 100.0;
+ALTER TABLE balance ALTER COLUMN current TYPE DOUBLE PRECISION USING current / 100.0;
+
+-- ledger_entry ----------------------
+ALTER TABLE ledger_entry ALTER COLUMN amount TYPE DOUBLE PRECISION USING amount / 100.0;
+
+-- order_status_history ----------------------
+ALTER TABLE order_status_history ALTER COLUMN accrual TYPE DOUBLE PRECISION USING accrual / 100.0;
+
+-- withdrawal ----------------------
+ALTER TABLE withdrawal ALTER COLUMN total TYPE DOUBLE PRECISION USING total / 100.0;
+
+-- order ----------------------
+ALTER TABLE "order" ALTER COLUMN accrual DROP DEFAULT;
+ALTER TABLE "order" ALTER COLUMN accrual TYPE DOUBLE PRECISION USING accrual / 100.0;
+
+COMMIT;
@@ -0,0 +1,37 @@
+-- +goose Up
+BEGIN;
+
+-- idempotency_key ----------------------
+CREATE TABLE IF NOT EXISTS idempotency_key (
+    user_id UUID NOT NULL REFERENCES "user" (id),
+    key VARCHAR(255) NOT NULL,
+    request_hash VARCHAR(64) NOT NULL,
+    status INT,
+    content_type VARCHAR(255),
+    body BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_key_created_at_idx ON idempotency_key (created_at ASC);
+
+COMMENT ON TABLE idempotency_key IS 'Responses of requests with Idempotency-Key header';
+
+COMMENT ON COLUMN idempotency_key.user_id IS 'User ID';
+COMMENT ON COLUMN idempotency_key.key IS 'Idempotency-Key header value';
+COMMENT ON COLUMN idempotency_key.request_hash IS 'Request method, path and body hash';
+COMMENT ON COLUMN idempotency_key.status IS 'Response status code, NULL while request is in progress';
+COMMENT ON COLUMN idempotency_key.content_type IS 'Response Content-Type header';
+COMMENT ON COLUMN idempotency_key.body IS 'Response body';
+COMMENT ON COLUMN idempotency_key.created_at IS 'First request date';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- idempotency_key ----------------------
+DROP TABLE IF EXISTS idempotency_key CASCADE;
+
+COMMIT;
@@ -0,0 +1,30 @@
+-- +goose Up
+BEGIN;
+
+-- withdrawal ----------------------
+DROP TYPE IF EXISTS withdrawal_status;
+CREATE TYPE withdrawal_status AS ENUM ('PENDING', 'COMPLETED', 'CANCELLED');
+
+-- existing withdrawals are out of cancellation window already
+ALTER TABLE withdrawal ADD COLUMN IF NOT EXISTS status withdrawal_status NOT NULL DEFAULT 'COMPLETED';
+ALTER TABLE withdrawal ALTER COLUMN status SET DEFAULT 'PENDING';
+ALTER TABLE withdrawal ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS withdrawal_pending_idx ON withdrawal (created_at ASC) WHERE status = 'PENDING';
+
+COMMENT ON COLUMN withdrawal.status IS 'Withdrawal status, PENDING withdrawal may be cancelled';
+COMMENT ON COLUMN withdrawal.cancelled_at IS 'Withdrawal cancellation date';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- withdrawal ----------------------
+DROP INDEX IF EXISTS withdrawal_pending_idx;
+ALTER TABLE withdrawal DROP COLUMN IF EXISTS cancelled_at;
+ALTER TABLE withdrawal DROP COLUMN IF EXISTS status;
+DROP TYPE IF EXISTS withdrawal_status;
+
+COMMIT;
@@ -0,0 +1,57 @@
+-- +goose Up
+BEGIN;
+
+-- order ----------------------
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS processed_at TIMESTAMP WITH TIME ZONE;
+
+-- processed orders get processing date from their history
+UPDATE "order" o SET processed_at = h.created_at
+FROM (
+    SELECT order_id, MAX(created_at) AS created_at
+    FROM order_status_history
+    WHERE status_to = 'PROCESSED'
+    GROUP BY order_id
+) h
+WHERE o.id = h.order_id AND o.status = 'PROCESSED';
+
+CREATE INDEX IF NOT EXISTS order_processed_idx ON "order" (next_check_at ASC) WHERE status = 'PROCESSED';
+
+COMMENT ON COLUMN "order".processed_at IS 'Date order was processed, reconciliation window starts from it';
+
+-- balance_review ----------------------
+CREATE TABLE IF NOT EXISTS balance_review (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id UUID NOT NULL REFERENCES "user" (id),
+    reference VARCHAR(155) NOT NULL,
+    balance BIGINT NOT NULL,
+    reason VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS balance_review_unresolved_idx ON balance_review (created_at ASC) WHERE resolved_at IS NULL;
+
+COMMENT ON TABLE balance_review IS 'Balances flagged for manual review';
+
+COMMENT ON COLUMN balance_review.id IS 'Unique review ID';
+COMMENT ON COLUMN balance_review.user_id IS 'User ID';
+COMMENT ON COLUMN balance_review.reference IS 'Order number caused the review';
+COMMENT ON COLUMN balance_review.balance IS 'Points balance in hundredths of a point when flagged';
+COMMENT ON COLUMN balance_review.reason IS 'Review reason';
+COMMENT ON COLUMN balance_review.created_at IS 'Flag date';
+COMMENT ON COLUMN balance_review.resolved_at IS 'Review resolution date, NULL while unresolved';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- balance_review ----------------------
+DROP TABLE IF EXISTS balance_review CASCADE;
+
+-- order ----------------------
+DROP INDEX IF EXISTS order_processed_idx;
+ALTER TABLE "order" DROP COLUMN IF EXISTS processed_at;
+
+COMMIT;
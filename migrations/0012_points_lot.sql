@@ -0,0 +1,66 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+
+-- ledger_entry ----------------------
+-- enum value can't be added in transaction block before PostgreSQL 12, so it is added by own statement
+ALTER TYPE ledger_entry_kind ADD VALUE IF NOT EXISTS 'EXPIRATION';
+
+-- +goose StatementBegin
+BEGIN;
+
+-- points_lot ----------------------
+CREATE TABLE IF NOT EXISTS points_lot (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id UUID NOT NULL REFERENCES "user" (id),
+    reference VARCHAR(155) NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    remaining BIGINT NOT NULL CHECK (remaining >= 0 AND remaining <= amount),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS points_lot_user_idx ON points_lot (user_id, expires_at ASC) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS points_lot_expires_at_idx ON points_lot (expires_at ASC) WHERE remaining > 0;
+
+COMMENT ON TABLE points_lot IS 'Credited points lots, withdrawals consume them from the soonest expiring';
+
+COMMENT ON COLUMN points_lot.id IS 'Unique lot ID';
+COMMENT ON COLUMN points_lot.user_id IS 'User ID';
+COMMENT ON COLUMN points_lot.reference IS 'Order number points are credited for';
+COMMENT ON COLUMN points_lot.amount IS 'Credited points in hundredths of a point';
+COMMENT ON COLUMN points_lot.remaining IS 'Not consumed points in hundredths of a point';
+COMMENT ON COLUMN points_lot.expires_at IS 'Points expiration date, NULL for never expiring points';
+COMMENT ON COLUMN points_lot.created_at IS 'Credit date';
+
+-- points_lot_allocation ----------------------
+CREATE TABLE IF NOT EXISTS points_lot_allocation (
+    entry_id BIGINT NOT NULL REFERENCES ledger_entry (id),
+    lot_id BIGINT NOT NULL REFERENCES points_lot (id),
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    PRIMARY KEY (entry_id, lot_id)
+);
+
+COMMENT ON TABLE points_lot_allocation IS 'Points lots consumed by ledger entries, used for refunds to the same lots';
+
+COMMENT ON COLUMN points_lot_allocation.entry_id IS 'Ledger entry ID';
+COMMENT ON COLUMN points_lot_allocation.lot_id IS 'Points lot ID';
+COMMENT ON COLUMN points_lot_allocation.amount IS 'Consumed points in hundredths of a point';
+
+-- points credited before expiration policy don't expire
+INSERT INTO points_lot (user_id, reference, amount, remaining)
+SELECT user_id, 'balance', current, current FROM balance WHERE current > 0;
+
+COMMIT;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+BEGIN;
+
+-- points_lot ----------------------
+DROP TABLE IF EXISTS points_lot_allocation CASCADE;
+DROP TABLE IF EXISTS points_lot CASCADE;
+
+COMMIT;
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+BEGIN;
+
+-- balance ----------------------
+ALTER TABLE balance ADD COLUMN IF NOT EXISTS pending BIGINT NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN balance.pending IS 'Held points in hundredths of a point, they are not spendable until release';
+
+-- points_lot ----------------------
+ALTER TABLE points_lot ADD COLUMN IF NOT EXISTS held BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE points_lot ADD COLUMN IF NOT EXISTS available_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS points_lot_held_idx ON points_lot (available_at ASC) WHERE held;
+
+COMMENT ON COLUMN points_lot.held IS 'Lot points are held and counted in pending balance';
+COMMENT ON COLUMN points_lot.available_at IS 'Date held lot points become spendable';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- points_lot ----------------------
+DROP INDEX IF EXISTS points_lot_held_idx;
+ALTER TABLE points_lot DROP COLUMN IF EXISTS available_at;
+ALTER TABLE points_lot DROP COLUMN IF EXISTS held;
+
+-- balance ----------------------
+ALTER TABLE balance DROP COLUMN IF EXISTS pending;
+
+COMMIT;
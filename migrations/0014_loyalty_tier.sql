@@ -0,0 +1,36 @@
+-- +goose Up
+BEGIN;
+
+-- order ----------------------
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS accrual_multiplier NUMERIC(6, 3) NOT NULL DEFAULT 1;
+
+COMMENT ON COLUMN "order".accrual_multiplier IS 'Loyalty tier multiplier applied to accrual service amount';
+
+-- user_tier ----------------------
+CREATE TABLE IF NOT EXISTS user_tier (
+    user_id UUID PRIMARY KEY REFERENCES "user" (id),
+    tier VARCHAR(64) NOT NULL,
+    accrued BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON TABLE user_tier IS 'User loyalty tiers recalculated by background job';
+
+COMMENT ON COLUMN user_tier.user_id IS 'User ID';
+COMMENT ON COLUMN user_tier.tier IS 'Loyalty tier name';
+COMMENT ON COLUMN user_tier.accrued IS 'Points accrued within the tier period in hundredths of a point';
+COMMENT ON COLUMN user_tier.updated_at IS 'Recalculation date';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- user_tier ----------------------
+DROP TABLE IF EXISTS user_tier CASCADE;
+
+-- order ----------------------
+ALTER TABLE "order" DROP COLUMN IF EXISTS accrual_multiplier;
+
+COMMIT;
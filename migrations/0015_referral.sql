@@ -0,0 +1,60 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+
+-- ledger_entry ----------------------
+-- enum value can't be added in transaction block before PostgreSQL 12, so it is added by own statement
+ALTER TYPE ledger_entry_kind ADD VALUE IF NOT EXISTS 'BONUS';
+
+-- +goose StatementBegin
+BEGIN;
+
+-- user ----------------------
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS referral_code VARCHAR(16);
+
+-- existing users get their codes
+UPDATE "user" SET referral_code = UPPER(SUBSTRING(MD5(id::TEXT || RANDOM()::TEXT) FOR 10)) WHERE referral_code IS NULL;
+
+ALTER TABLE "user" ALTER COLUMN referral_code SET NOT NULL;
+ALTER TABLE "user" ADD CONSTRAINT user_referral_code_key UNIQUE (referral_code);
+
+COMMENT ON COLUMN "user".referral_code IS 'Code other users are referred by';
+
+-- referral ----------------------
+CREATE TABLE IF NOT EXISTS referral (
+    referred_id UUID PRIMARY KEY REFERENCES "user" (id),
+    referrer_id UUID NOT NULL REFERENCES "user" (id),
+    bonus BIGINT NOT NULL DEFAULT 0,
+    order_number VARCHAR(155),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    paid_at TIMESTAMP WITH TIME ZONE,
+    CHECK (referred_id <> referrer_id)
+);
+
+CREATE INDEX IF NOT EXISTS referral_referrer_idx ON referral (referrer_id, created_at ASC);
+
+COMMENT ON TABLE referral IS 'Users registered by referral codes';
+
+COMMENT ON COLUMN referral.referred_id IS 'Registered user ID';
+COMMENT ON COLUMN referral.referrer_id IS 'Referral code owner ID';
+COMMENT ON COLUMN referral.bonus IS 'Bonus paid to each user in hundredths of a point';
+COMMENT ON COLUMN referral.order_number IS 'The first processed order of registered user';
+COMMENT ON COLUMN referral.created_at IS 'Registration date';
+COMMENT ON COLUMN referral.paid_at IS 'Bonus payment date, NULL while bonus is pending';
+
+COMMIT;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+BEGIN;
+
+-- referral ----------------------
+DROP TABLE IF EXISTS referral CASCADE;
+
+-- user ----------------------
+ALTER TABLE "user" DROP CONSTRAINT IF EXISTS user_referral_code_key;
+ALTER TABLE "user" DROP COLUMN IF EXISTS referral_code;
+
+COMMIT;
+-- +goose StatementEnd
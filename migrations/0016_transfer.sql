@@ -0,0 +1,44 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+
+-- ledger_entry ----------------------
+-- enum value can't be added in transaction block before PostgreSQL 12, so it is added by own statement
+ALTER TYPE ledger_entry_kind ADD VALUE IF NOT EXISTS 'TRANSFER';
+
+-- +goose StatementBegin
+BEGIN;
+
+-- transfer ----------------------
+CREATE TABLE IF NOT EXISTS transfer (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    sender_id UUID NOT NULL REFERENCES "user" (id),
+    recipient_id UUID NOT NULL REFERENCES "user" (id),
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (sender_id <> recipient_id)
+);
+
+CREATE INDEX IF NOT EXISTS transfer_sender_idx ON transfer (sender_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS transfer_recipient_idx ON transfer (recipient_id, created_at DESC);
+
+COMMENT ON TABLE transfer IS 'Points transfers between users';
+
+COMMENT ON COLUMN transfer.id IS 'Unique transfer ID';
+COMMENT ON COLUMN transfer.sender_id IS 'Sender user ID';
+COMMENT ON COLUMN transfer.recipient_id IS 'Recipient user ID';
+COMMENT ON COLUMN transfer.amount IS 'Transferred points in hundredths of a point';
+COMMENT ON COLUMN transfer.created_at IS 'Transfer date';
+
+COMMIT;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+BEGIN;
+
+-- transfer ----------------------
+DROP TABLE IF EXISTS transfer CASCADE;
+
+COMMIT;
+-- +goose StatementEnd
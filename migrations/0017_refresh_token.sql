@@ -0,0 +1,40 @@
+-- +goose Up
+BEGIN;
+
+-- refresh_token ----------------------
+CREATE TABLE IF NOT EXISTS refresh_token (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id UUID NOT NULL REFERENCES "user" (id),
+    family_id UUID NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS refresh_token_user_idx ON refresh_token (user_id) WHERE revoked_at IS NULL;
+CREATE INDEX IF NOT EXISTS refresh_token_family_idx ON refresh_token (family_id);
+CREATE INDEX IF NOT EXISTS refresh_token_expires_at_idx ON refresh_token (expires_at ASC);
+
+COMMENT ON TABLE refresh_token IS 'Rotating refresh tokens, every login starts a new tokens family';
+
+COMMENT ON COLUMN refresh_token.id IS 'Unique token ID';
+COMMENT ON COLUMN refresh_token.user_id IS 'User ID';
+COMMENT ON COLUMN refresh_token.family_id IS 'ID of tokens issued by rotation from the same login';
+COMMENT ON COLUMN refresh_token.token_hash IS 'SHA-256 hex hash of token, token itself is not stored';
+COMMENT ON COLUMN refresh_token.expires_at IS 'Token expiration date';
+COMMENT ON COLUMN refresh_token.created_at IS 'Token issue date';
+COMMENT ON COLUMN refresh_token.used_at IS 'Token rotation date, reuse of rotated token revokes its family';
+COMMENT ON COLUMN refresh_token.revoked_at IS 'Logout or reuse detection date';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- refresh_token ----------------------
+DROP TABLE IF EXISTS refresh_token CASCADE;
+
+COMMIT;
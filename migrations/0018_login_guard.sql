@@ -0,0 +1,54 @@
+-- +goose Up
+BEGIN;
+
+-- login_lock -------------------------
+CREATE TABLE IF NOT EXISTS login_lock (
+    key TEXT PRIMARY KEY,
+    failures INT NOT NULL,
+    failed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_lock_failed_at_idx ON login_lock (failed_at ASC);
+
+COMMENT ON TABLE login_lock IS 'Failed login attempts counters shared by all replicas';
+
+COMMENT ON COLUMN login_lock.key IS 'Counter key, login:<login> or ip:<client IP>';
+COMMENT ON COLUMN login_lock.failures IS 'Failures since the counter reset';
+COMMENT ON COLUMN login_lock.failed_at IS 'Last failure date, failures are forgotten after max lockout since it';
+COMMENT ON COLUMN login_lock.locked_until IS 'Login attempts are rejected until this date';
+
+-- login_failure ----------------------
+CREATE TABLE IF NOT EXISTS login_failure (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    login TEXT NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    lockout_ms BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS login_failure_login_idx ON login_failure (login, created_at DESC);
+CREATE INDEX IF NOT EXISTS login_failure_ip_idx ON login_failure (ip, created_at DESC);
+CREATE INDEX IF NOT EXISTS login_failure_created_at_idx ON login_failure (created_at ASC);
+
+COMMENT ON TABLE login_failure IS 'Audit of failed login attempts';
+
+COMMENT ON COLUMN login_failure.id IS 'Unique attempt ID';
+COMMENT ON COLUMN login_failure.login IS 'Login of the attempt, user may not exist';
+COMMENT ON COLUMN login_failure.ip IS 'Client IP';
+COMMENT ON COLUMN login_failure.lockout_ms IS 'Lockout caused by the attempt in milliseconds';
+COMMENT ON COLUMN login_failure.created_at IS 'Attempt date';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- login_failure ----------------------
+DROP TABLE IF EXISTS login_failure CASCADE;
+
+-- login_lock -------------------------
+DROP TABLE IF EXISTS login_lock CASCADE;
+
+COMMIT;
@@ -0,0 +1,31 @@
+-- +goose Up
+BEGIN;
+
+-- idempotency_key ----------------------
+ALTER TABLE idempotency_key ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+ALTER TABLE idempotency_key ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE idempotency_key SET locked_until = created_at WHERE status IS NULL;
+UPDATE idempotency_key SET expires_at = created_at + INTERVAL '1 day';
+ALTER TABLE idempotency_key ALTER COLUMN expires_at SET NOT NULL;
+
+DROP INDEX IF EXISTS idempotency_key_created_at_idx;
+CREATE INDEX IF NOT EXISTS idempotency_key_expires_at_idx ON idempotency_key (expires_at ASC);
+
+COMMENT ON COLUMN idempotency_key.locked_until IS 'Date request in progress is considered crashed, so a retry takes over the key';
+COMMENT ON COLUMN idempotency_key.expires_at IS 'Date the key is forgotten';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- idempotency_key ----------------------
+DROP INDEX IF EXISTS idempotency_key_expires_at_idx;
+CREATE INDEX IF NOT EXISTS idempotency_key_created_at_idx ON idempotency_key (created_at ASC);
+
+ALTER TABLE idempotency_key DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE idempotency_key DROP COLUMN IF EXISTS locked_until;
+
+COMMIT;
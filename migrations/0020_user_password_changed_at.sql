@@ -0,0 +1,18 @@
+-- +goose Up
+BEGIN;
+
+-- user -------------------------------
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMP WITH TIME ZONE;
+
+COMMENT ON COLUMN "user".password_changed_at IS 'Last password change date, access tokens issued before it are revoked';
+
+COMMIT;
+
+-- +goose Down
+
+BEGIN;
+
+-- user -------------------------------
+ALTER TABLE "user" DROP COLUMN IF EXISTS password_changed_at;
+
+COMMIT;